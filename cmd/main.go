@@ -2,26 +2,81 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
+	"time"
 
+	"github.com/sessionsdev/blue-octopus/internal/aiapi"
 	"github.com/sessionsdev/blue-octopus/internal/auth"
+	"github.com/sessionsdev/blue-octopus/internal/experiments"
+	"github.com/sessionsdev/blue-octopus/internal/game"
+	"github.com/sessionsdev/blue-octopus/internal/moderation"
+	"github.com/sessionsdev/blue-octopus/internal/prompts"
 	"github.com/sessionsdev/blue-octopus/internal/redis"
 	"github.com/sessionsdev/blue-octopus/internal/router"
 )
 
 func main() {
+	// cancelled on shutdown so in flight AI calls are aborted
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	staticPath := filepath.Join(".", "static")
 	router.Init(staticPath)
 	redis.Init()
+	game.SetBaseContext(ctx)
+
+	if err := aiapi.ReloadModelConfig(); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			log.Printf("No model config at %s, using environment defaults", aiapi.ModelConfigPath())
+		} else {
+			log.Fatalf("Error loading model config: %v", err)
+		}
+	}
+
+	if err := moderation.Configure(); err != nil {
+		log.Fatalf("Error configuring moderation: %v", err)
+	}
+
+	if err := prompts.Configure(); err != nil {
+		log.Fatalf("Error loading prompts: %v", err)
+	}
+
+	// experiments can name prompt versions, so they load after the prompts
+	if err := experiments.Configure(); err != nil {
+		log.Fatalf("Error loading experiments: %v", err)
+	}
 
 	adminPassword := os.Getenv("ADMIN_PASSWORD")
 	adminEmail := os.Getenv("ADMIN_EMAIL")
 	auth.CreateAdminUser(context.TODO(), adminPassword, adminEmail)
 
-	fmt.Println("Server is running at http://localhost:8090")
-	log.Fatal(http.ListenAndServe(":8090", nil))
+	server := &http.Server{
+		Addr:        ":8090",
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	go func() {
+		fmt.Println("Server is running at http://localhost:8090")
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("Shutting down server")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error shutting down server: %v", err)
+	}
 }
@@ -2,22 +2,53 @@ package admin
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"html/template"
 	"log"
 	"net/http"
+	"strconv"
 
+	"github.com/sessionsdev/blue-octopus/internal/aiapi"
 	"github.com/sessionsdev/blue-octopus/internal/auth"
+	"github.com/sessionsdev/blue-octopus/internal/costs"
+	"github.com/sessionsdev/blue-octopus/internal/experiments"
+	"github.com/sessionsdev/blue-octopus/internal/game"
+	"github.com/sessionsdev/blue-octopus/internal/moderation"
+	"github.com/sessionsdev/blue-octopus/internal/prompts"
+	"github.com/sessionsdev/blue-octopus/internal/quota"
 	"github.com/sessionsdev/blue-octopus/internal/redis"
 )
 
+// how many days of costs the admin page reports on
+const costReportDays = 7
+
+// how many flagged turns the admin page lists
+const flaggedTurnsShown = 50
+
 type AdminData struct {
-	Users []AdminUserData
+	Users       []AdminUserData
+	Models      []aiapi.RoleConfigEntry
+	Degraded    map[string]string
+	Breakers    []aiapi.BreakerStatus
+	Cache       []aiapi.CacheStats
+	Costs       *costs.Report
+	Flagged     []moderation.FlaggedTurn
+	Ratings     []moderation.Rating
+	Prompts     []prompts.VersionInfo
+	Experiments []experiments.Report
 }
 
 type AdminUserData struct {
-	Email     string
-	Role      string
-	EmailHash string
+	Email         string
+	Role          string
+	EmailHash     string
+	Usage         quota.Usage
+	ContentRating string
+	PromptVersion string
+	// PromptVersionMissing is set when the game is pinned to a version that
+	// isn't loaded, so it can't be played until it is pinned again.
+	PromptVersionMissing bool
 }
 
 func BuildFromAuthUser(user auth.User) AdminUserData {
@@ -44,7 +75,38 @@ func ServeAdminPage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	data := AdminData{Users: getUsers(r.Context())}
+	data := AdminData{
+		Users:    getUsers(r.Context()),
+		Models:   aiapi.CurrentModelConfig(),
+		Degraded: aiapi.DegradedRoles(),
+		Breakers: aiapi.BreakerStatuses(),
+	}
+
+	cacheStats, err := aiapi.GetCacheStats(r.Context())
+	if err != nil {
+		log.Println("Failed to get response cache stats: ", err)
+	}
+	data.Cache = cacheStats
+
+	report, err := costs.BuildReport(r.Context(), costReportDays)
+	if err != nil {
+		log.Println("Failed to build cost report: ", err)
+	}
+	data.Costs = report
+
+	flagged, err := moderation.RecentFlagged(r.Context(), flaggedTurnsShown)
+	if err != nil {
+		log.Println("Failed to get flagged turns: ", err)
+	}
+	data.Flagged = flagged
+	data.Ratings = moderation.Ratings
+	data.Prompts = prompts.Versions()
+
+	experimentReports, err := experiments.BuildReports(r.Context())
+	if err != nil {
+		log.Println("Failed to build experiment reports: ", err)
+	}
+	data.Experiments = experimentReports
 
 	tmpl, err := template.ParseFiles(
 		"templates/base.html",
@@ -75,7 +137,17 @@ func getUsers(ctx context.Context) []AdminUserData {
 			log.Println("Failed to get user from redis: ", err)
 			continue
 		}
-		users = append(users, BuildFromAuthUser(user))
+		userData := BuildFromAuthUser(user)
+		userData.Usage, err = quota.GetUsage(ctx, user.Email)
+		if err != nil {
+			log.Println("Failed to get token usage from redis: ", err)
+		}
+		if info, err := game.MainSlotInfo(ctx, user.Email); err == nil {
+			userData.ContentRating = info.ContentRating
+			userData.PromptVersion = info.PromptVersion
+			userData.PromptVersionMissing = info.PromptVersion != "" && !prompts.Has(info.PromptVersion)
+		}
+		users = append(users, userData)
 	}
 
 	return users
@@ -154,3 +226,237 @@ func HandleCreateUserForm(w http.ResponseWriter, r *http.Request) {
 
 	http.Redirect(w, r, "/admin", http.StatusSeeOther)
 }
+
+func HandleUserQuotaForm(w http.ResponseWriter, r *http.Request) {
+	// post request only
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !CheckIfUserContextIsAdmin(r.Context()) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	email := r.FormValue("email")
+	if email == "" {
+		http.Error(w, "Missing email", http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	if r.FormValue("action") == "clear" {
+		err = quota.ClearOverride(r.Context(), email)
+	} else {
+		daily, dailyErr := strconv.Atoi(r.FormValue("daily"))
+		monthly, monthlyErr := strconv.Atoi(r.FormValue("monthly"))
+		if dailyErr != nil || monthlyErr != nil || daily < 0 || monthly < 0 {
+			http.Error(w, "Quotas must be whole numbers, 0 for unlimited", http.StatusBadRequest)
+			return
+		}
+		err = quota.SetOverride(r.Context(), email, quota.Limits{Daily: daily, Monthly: monthly})
+	}
+	if err != nil {
+		http.Error(w, "Failed to update quota", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+func HandleContentRatingForm(w http.ResponseWriter, r *http.Request) {
+	// post request only
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !CheckIfUserContextIsAdmin(r.Context()) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	email := r.FormValue("email")
+	rating, ok := moderation.ParseRating(r.FormValue("rating"))
+	if email == "" || !ok {
+		http.Error(w, "Missing email or unknown rating", http.StatusBadRequest)
+		return
+	}
+
+	// the rating applies to every one of the user's saves
+	err := game.UpdateAllSlots(r.Context(), email, func(g *game.Game) {
+		g.SetContentRating(string(rating))
+	})
+	if err != nil {
+		writeUpdateError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// writeUpdateError answers a change to a user's saves that failed.
+func writeUpdateError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, game.ErrSlotNotFound):
+		http.Error(w, "User has no game", http.StatusNotFound)
+	case errors.Is(err, game.ErrSavesBusy):
+		http.Error(w, "The user is playing a turn, try again in a moment", http.StatusConflict)
+	default:
+		log.Println("Error updating user's saves: ", err)
+		http.Error(w, "Failed to update the user's saves", http.StatusInternalServerError)
+	}
+}
+
+// HandlePromptVersionForm pins a user's game to a prompt version, or lets it
+// follow the default version again when the version is empty.
+func HandlePromptVersionForm(w http.ResponseWriter, r *http.Request) {
+	// post request only
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !CheckIfUserContextIsAdmin(r.Context()) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	email := r.FormValue("email")
+	version := r.FormValue("version")
+	if email == "" || (version != "" && !prompts.Has(version)) {
+		http.Error(w, "Missing email or unknown prompt version", http.StatusBadRequest)
+		return
+	}
+
+	err := game.UpdateAllSlots(r.Context(), email, func(g *game.Game) {
+		g.SetPromptVersion(version)
+	})
+	if err != nil {
+		writeUpdateError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+func HandleReloadModelsAction(w http.ResponseWriter, r *http.Request) {
+	// post request only
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !CheckIfUserContextIsAdmin(r.Context()) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	err := aiapi.ReloadModelConfig()
+	if err != nil {
+		log.Println("Failed to reload model config: ", err)
+		http.Error(w, "Failed to reload model config: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// if hx request then redirect to admin page
+	if r.Header.Get("HX-Request") == "true" {
+		w.Header().Add("HX-Redirect", "/admin")
+	} else {
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+	}
+}
+
+func ServeCostReport(w http.ResponseWriter, r *http.Request) {
+	// get request only
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !CheckIfUserContextIsAdmin(r.Context()) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	days := costReportDays
+	if value := r.URL.Query().Get("days"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > 90 {
+			http.Error(w, "days must be between 1 and 90", http.StatusBadRequest)
+			return
+		}
+		days = parsed
+	}
+
+	report, err := costs.BuildReport(r.Context(), days)
+	if err != nil {
+		log.Println("Failed to build cost report: ", err)
+		http.Error(w, "Failed to build cost report", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Println("Failed to write cost report: ", err)
+	}
+}
+
+// GameEventsReport is a save slot's event log, and whether replaying it
+// rebuilds the game as it is saved.
+type GameEventsReport struct {
+	Events         []game.Event `json:"events"`
+	RebuildMatches bool         `json:"rebuild_matches"`
+	RebuildError   string       `json:"rebuild_error,omitempty"`
+}
+
+// ServeGameEvents serves a user's game log for auditing and debugging
+// reconciliations, the main slot unless slot is given.
+func ServeGameEvents(w http.ResponseWriter, r *http.Request) {
+	// get request only
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !CheckIfUserContextIsAdmin(r.Context()) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	email := r.URL.Query().Get("email")
+	slot := r.URL.Query().Get("slot")
+	if email == "" {
+		http.Error(w, "Missing email", http.StatusBadRequest)
+		return
+	}
+
+	saved, err := game.LoadGameFromRedis(r.Context(), email, slot)
+	if err != nil {
+		http.Error(w, "User has no game", http.StatusNotFound)
+		return
+	}
+
+	events, err := game.ReadEvents(r.Context(), email, slot)
+	if err != nil {
+		log.Println("Failed to read game events: ", err)
+		http.Error(w, "Failed to read game events", http.StatusInternalServerError)
+		return
+	}
+
+	report := GameEventsReport{Events: events}
+	rebuilt, err := game.RebuildGame(r.Context(), email, slot)
+	if err != nil {
+		report.RebuildError = err.Error()
+	} else {
+		want, _ := json.Marshal(saved)
+		got, _ := json.Marshal(rebuilt)
+		report.RebuildMatches = string(want) == string(got)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Println("Failed to write game events: ", err)
+	}
+}
@@ -0,0 +1,298 @@
+package aiapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const anthropicVersion = "2023-06-01"
+
+func NewAnthropic(model string, temp float64, responseFormat ResponseFormat) *AnthropicClient {
+	return &AnthropicClient{
+		Client:         newChatHTTPClient(30 * time.Second),
+		ClientName:     "anthropic",
+		BaseURL:        "https://api.anthropic.com",
+		APIKey:         os.Getenv("ANTHROPIC_API_KEY"),
+		Model:          model,
+		Temperature:    temp,
+		MaxTokens:      1024,
+		ResponseFormat: responseFormat,
+		RetryPolicy:    DefaultRetryPolicy,
+	}
+}
+
+// AnthropicMessage is a single turn in a Messages API conversation.  Its
+// content is sent as Blocks when it has any, such as tool calls and their
+// results, and as plain text otherwise.
+type AnthropicMessage struct {
+	Role    string                  `json:"role"`
+	Content string                  `json:"content"`
+	Blocks  []AnthropicContentBlock `json:"-"`
+}
+
+func (m AnthropicMessage) MarshalJSON() ([]byte, error) {
+	if len(m.Blocks) == 0 {
+		type plain AnthropicMessage
+		return json.Marshal(plain(m))
+	}
+	return json.Marshal(struct {
+		Role    string                  `json:"role"`
+		Content []AnthropicContentBlock `json:"content"`
+	}{Role: m.Role, Content: m.Blocks})
+}
+
+// appendBlocks adds content blocks to the message, moving any plain text
+// into a block first.
+func (m *AnthropicMessage) appendBlocks(blocks ...AnthropicContentBlock) {
+	if len(m.Blocks) == 0 && m.Content != "" {
+		m.Blocks = append(m.Blocks, AnthropicContentBlock{Type: "text", Text: m.Content})
+	}
+	m.Content = ""
+	m.Blocks = append(m.Blocks, blocks...)
+}
+
+// AnthropicRequest is the request payload for the Messages API.
+type AnthropicRequest struct {
+	Model       string               `json:"model"`
+	System      string               `json:"system,omitempty"`
+	Messages    []AnthropicMessage   `json:"messages"`
+	MaxTokens   int                  `json:"max_tokens"`
+	Temperature float64              `json:"temperature"`
+	Tools       []AnthropicTool      `json:"tools,omitempty"`
+	ToolChoice  *AnthropicToolChoice `json:"tool_choice,omitempty"`
+}
+
+type AnthropicTool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	InputSchema map[string]interface{} `json:"input_schema"`
+}
+
+type AnthropicToolChoice struct {
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
+}
+
+type AnthropicContentBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+}
+
+type AnthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// AnthropicResponse represents the structure of the response from the Messages API.
+type AnthropicResponse struct {
+	ID         string                  `json:"id"`
+	Type       string                  `json:"type"`
+	Role       string                  `json:"role"`
+	Model      string                  `json:"model"`
+	Content    []AnthropicContentBlock `json:"content"`
+	StopReason string                  `json:"stop_reason"`
+	Usage      AnthropicUsage          `json:"usage"`
+}
+
+func (resp *AnthropicResponse) GetChatCompletion() string {
+	var text strings.Builder
+	for _, block := range resp.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+	return text.String()
+}
+
+func (resp *AnthropicResponse) GetTokenUsage() int {
+	return resp.Usage.InputTokens + resp.Usage.OutputTokens
+}
+
+func (resp *AnthropicResponse) toChatResponse(completion string) *AiChatResponse {
+	return &AiChatResponse{
+		Completion: completion,
+		TokensUsed: resp.GetTokenUsage(),
+		Usage: Usage{
+			PromptTokens:     resp.Usage.InputTokens,
+			CompletionTokens: resp.Usage.OutputTokens,
+			TotalTokens:      resp.GetTokenUsage(),
+		},
+		Model: resp.Model,
+	}
+}
+
+type AnthropicClient struct {
+	Client         *http.Client
+	ClientName     string
+	BaseURL        string
+	APIKey         string
+	Model          string
+	Temperature    float64
+	MaxTokens      int
+	ResponseFormat ResponseFormat
+	RetryPolicy    RetryPolicy
+}
+
+func (c *AnthropicClient) DoRequest(ctx context.Context, userMessages []AiMessage) (ChatResponse, error) {
+	anthropicRequest := c.buildRequest(userMessages, c.jsonMode())
+
+	anthropicResponse, err := c.send(ctx, anthropicRequest)
+	if err != nil {
+		return &AiChatResponse{}, err
+	}
+
+	completion := anthropicResponse.GetChatCompletion()
+	if c.jsonMode() {
+		// the prefilled brace is not repeated in the completion
+		completion = "{" + completion
+	}
+
+	return anthropicResponse.toChatResponse(completion), nil
+}
+
+// DoSchemaRequest emulates structured outputs by forcing the model to call a
+// single tool whose input schema is the response schema.  The tool input is
+// returned as the completion.
+func (c *AnthropicClient) DoSchemaRequest(ctx context.Context, userMessages []AiMessage, schema *JSONSchema) (ChatResponse, error) {
+	anthropicRequest := c.buildRequest(userMessages, false)
+	anthropicRequest.Tools = []AnthropicTool{{
+		Name:        schema.Name,
+		Description: "Record the structured response.",
+		InputSchema: schema.Schema,
+	}}
+	anthropicRequest.ToolChoice = &AnthropicToolChoice{Type: "tool", Name: schema.Name}
+
+	anthropicResponse, err := c.send(ctx, anthropicRequest)
+	if err != nil {
+		return &AiChatResponse{}, err
+	}
+
+	for _, block := range anthropicResponse.Content {
+		if block.Type == "tool_use" && block.Name == schema.Name {
+			return anthropicResponse.toChatResponse(string(block.Input)), nil
+		}
+	}
+
+	return anthropicResponse.toChatResponse(anthropicResponse.GetChatCompletion()), &SchemaToolError{Tool: schema.Name}
+}
+
+func (c *AnthropicClient) send(ctx context.Context, anthropicRequest AnthropicRequest) (*AnthropicResponse, error) {
+	requestBody, err := json.Marshal(anthropicRequest)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling request: %w", err)
+	}
+
+	url := strings.TrimSuffix(c.BaseURL, "/") + "/v1/messages"
+
+	resp, err := doWithRetry(ctx, c.Client, c.ClientName, c.RetryPolicy, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(requestBody))
+		if err != nil {
+			return nil, fmt.Errorf("error creating request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("x-api-key", c.APIKey)
+		req.Header.Set("anthropic-version", anthropicVersion)
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var anthropicResponse AnthropicResponse
+	if err := json.NewDecoder(resp.Body).Decode(&anthropicResponse); err != nil {
+		return nil, fmt.Errorf("error decoding response: %w", err)
+	}
+
+	return &anthropicResponse, nil
+}
+
+func (c *AnthropicClient) jsonMode() bool {
+	return c.ResponseFormat.Type == "json_object"
+}
+
+// buildRequest lifts system prompts out of the message list, merges
+// consecutive turns from the same role and, when prefillJSON is set,
+// prefills the assistant turn with an opening brace.  Tool calls become
+// tool_use blocks and their results tool_result blocks in a user turn.
+func (c *AnthropicClient) buildRequest(messages []AiMessage, prefillJSON bool) AnthropicRequest {
+	var systemPrompts []string
+	var anthropicMessages []AnthropicMessage
+
+	for _, m := range messages {
+		if m.Provider == "system" {
+			systemPrompts = append(systemPrompts, strings.TrimSpace(m.Message))
+			continue
+		}
+
+		message := toAnthropicMessage(m)
+
+		last := len(anthropicMessages) - 1
+		if last >= 0 && anthropicMessages[last].Role == message.Role {
+			if len(message.Blocks) > 0 || len(anthropicMessages[last].Blocks) > 0 {
+				anthropicMessages[last].appendBlocks(message.blocks()...)
+			} else {
+				anthropicMessages[last].Content += "\n\n" + message.Content
+			}
+			continue
+		}
+		anthropicMessages = append(anthropicMessages, message)
+	}
+
+	// the conversation must open with a user turn
+	if len(anthropicMessages) == 0 || anthropicMessages[0].Role != "user" {
+		anthropicMessages = append([]AnthropicMessage{{Role: "user", Content: "(the adventure continues)"}}, anthropicMessages...)
+	}
+
+	if prefillJSON {
+		systemPrompts = append(systemPrompts, "Respond only with a single valid JSON object.")
+		anthropicMessages = append(anthropicMessages, AnthropicMessage{Role: "assistant", Content: "{"})
+	}
+
+	return AnthropicRequest{
+		Model:       c.Model,
+		System:      strings.Join(systemPrompts, "\n\n"),
+		Messages:    anthropicMessages,
+		MaxTokens:   c.MaxTokens,
+		Temperature: c.Temperature,
+	}
+}
+
+func toAnthropicMessage(m AiMessage) AnthropicMessage {
+	if m.Provider == "tool" {
+		return AnthropicMessage{Role: "user", Blocks: []AnthropicContentBlock{{
+			Type:      "tool_result",
+			ToolUseID: m.ToolCallID,
+			Content:   m.Message,
+		}}}
+	}
+
+	message := AnthropicMessage{Role: m.Provider, Content: m.Message}
+	for _, call := range m.ToolCalls {
+		input := json.RawMessage(call.Arguments)
+		if len(input) == 0 {
+			input = json.RawMessage("{}")
+		}
+		message.appendBlocks(AnthropicContentBlock{Type: "tool_use", ID: call.ID, Name: call.Name, Input: input})
+	}
+	return message
+}
+
+// blocks returns the message's content as blocks.
+func (m AnthropicMessage) blocks() []AnthropicContentBlock {
+	if len(m.Blocks) > 0 {
+		return m.Blocks
+	}
+	return []AnthropicContentBlock{{Type: "text", Text: m.Content}}
+}
@@ -0,0 +1,103 @@
+package aiapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAnthropicDoRequest(t *testing.T) {
+	var received AnthropicRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" {
+			t.Errorf("Expected path /v1/messages, but got %s", r.URL.Path)
+		}
+		if r.Header.Get("x-api-key") != "test-key" {
+			t.Errorf("Expected x-api-key header to be 'test-key', but got %s", r.Header.Get("x-api-key"))
+		}
+
+		json.NewDecoder(r.Body).Decode(&received)
+		json.NewEncoder(w).Encode(AnthropicResponse{
+			Content: []AnthropicContentBlock{{Type: "text", Text: `"player_location": "River"}`}},
+			Usage:   AnthropicUsage{InputTokens: 10, OutputTokens: 5},
+		})
+	}))
+	defer server.Close()
+
+	client := NewAnthropic("test-model", 0.5, ResponseFormat{Type: "json_object"})
+	client.BaseURL = server.URL
+	client.APIKey = "test-key"
+
+	response, err := client.DoRequest(context.Background(), []AiMessage{
+		{Provider: "system", Message: "first system prompt"},
+		{Provider: "system", Message: "second system prompt"},
+		{Provider: "assistant", Message: "You stand by the river."},
+		{Provider: "user", Message: "look around"},
+		{Provider: "user", Message: "reconcile the state"},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+
+	if received.System == "" {
+		t.Errorf("Expected system prompts to be lifted into the system field")
+	}
+
+	for _, m := range received.Messages {
+		if m.Role == "system" {
+			t.Errorf("Expected no system messages in the message list")
+		}
+	}
+
+	if len(received.Messages) != 4 || received.Messages[0].Role != "user" {
+		t.Fatalf("Expected 4 alternating messages opening with a user turn, but got %+v", received.Messages)
+	}
+
+	if received.Messages[2].Content != "look around\n\nreconcile the state" {
+		t.Errorf("Expected consecutive user messages to be merged, but got %q", received.Messages[2].Content)
+	}
+
+	if received.Messages[3].Role != "assistant" || received.Messages[3].Content != "{" {
+		t.Errorf("Expected json mode to prefill the assistant turn, but got %+v", received.Messages[3])
+	}
+
+	if response.GetChatCompletion() != `{"player_location": "River"}` {
+		t.Errorf("Expected prefill to be restored in the completion, but got %s", response.GetChatCompletion())
+	}
+
+	if response.GetTokenUsage() != 15 {
+		t.Errorf("Expected 15 tokens used, but got %d", response.GetTokenUsage())
+	}
+}
+
+func TestAnthropicDoSchemaRequestUsesTool(t *testing.T) {
+	var received AnthropicRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+		w.Write([]byte(`{"content": [{"type": "tool_use", "id": "toolu_1", "name": "test_response", "input": {"location": "River", "items": [], "turns": 1}}], "usage": {"input_tokens": 3, "output_tokens": 4}}`))
+	}))
+	defer server.Close()
+
+	client := NewAnthropic("test-model", 0.5, ResponseFormat{Type: "json_object"})
+	client.BaseURL = server.URL
+
+	schema := SchemaFor("test_response", testSchemaResponse{})
+	response, err := client.DoSchemaRequest(context.Background(), []AiMessage{{Provider: "user", Message: "reconcile"}}, schema)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+
+	if received.ToolChoice == nil || received.ToolChoice.Name != "test_response" || len(received.Tools) != 1 {
+		t.Errorf("Expected the schema tool to be forced, but got %+v", received.ToolChoice)
+	}
+
+	if last := received.Messages[len(received.Messages)-1]; last.Role != "user" {
+		t.Errorf("Expected no prefill when using a tool, but got %+v", last)
+	}
+
+	if err := schema.Validate([]byte(response.GetChatCompletion())); err != nil {
+		t.Errorf("Expected the tool input as a valid completion, but got %v", err)
+	}
+}
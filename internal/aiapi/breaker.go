@@ -0,0 +1,175 @@
+package aiapi
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states.
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// BreakerPolicy controls when a provider is taken out of a fallback chain.
+// A call that fails, or succeeds slower than LatencyThreshold, counts as a
+// failure.  FailureThreshold consecutive failures open the breaker, and
+// after OpenDuration a single probe call is let through to close it again.
+type BreakerPolicy struct {
+	FailureThreshold int
+	LatencyThreshold time.Duration
+	OpenDuration     time.Duration
+}
+
+var DefaultBreakerPolicy = BreakerPolicy{
+	FailureThreshold: 3,
+	LatencyThreshold: 45 * time.Second,
+	OpenDuration:     30 * time.Second,
+}
+
+// CircuitBreaker tracks the health of one provider and model.
+type CircuitBreaker struct {
+	Name   string
+	Policy BreakerPolicy
+
+	mu        sync.Mutex
+	state     string
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	lastError string
+}
+
+// Allow reports whether a call may be made.  Once an open breaker has
+// waited out its OpenDuration it turns half-open and allows one probe.
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.Policy.OpenDuration {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return true
+	case BreakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// Record reports the outcome of an allowed call.
+func (b *CircuitBreaker) Record(latency time.Duration, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+
+	slow := b.Policy.LatencyThreshold > 0 && latency > b.Policy.LatencyThreshold
+	if err == nil && !slow {
+		b.state = BreakerClosed
+		b.failures = 0
+		return
+	}
+
+	if err != nil {
+		b.lastError = err.Error()
+	} else {
+		b.lastError = "slow response: " + latency.Round(time.Millisecond).String()
+	}
+
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.Policy.FailureThreshold {
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// Abandon releases an allowed call that ended without saying anything about
+// the provider, such as one the caller cancelled.
+func (b *CircuitBreaker) Abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+// BreakerStatus is a snapshot of a circuit breaker, for display.
+type BreakerStatus struct {
+	Name      string
+	State     string
+	Failures  int
+	OpenedAt  time.Time
+	LastError string
+}
+
+func (b *CircuitBreaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state := b.state
+	if state == "" {
+		state = BreakerClosed
+	}
+	return BreakerStatus{
+		Name:      b.Name,
+		State:     state,
+		Failures:  b.failures,
+		OpenedAt:  b.openedAt,
+		LastError: b.lastError,
+	}
+}
+
+var (
+	breakers   = map[string]*CircuitBreaker{}
+	breakersMu sync.Mutex
+)
+
+// breakerFor returns the shared breaker for a provider and model, so its
+// state survives a model config reload and is shared between roles.
+func breakerFor(config RoleConfig, policy BreakerPolicy) *CircuitBreaker {
+	name := config.Provider + ":" + config.Model
+	if config.BaseURL != "" {
+		name += "@" + config.BaseURL
+	}
+
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+
+	breaker, ok := breakers[name]
+	if !ok {
+		breaker = &CircuitBreaker{Name: name}
+		breakers[name] = breaker
+	}
+
+	breaker.mu.Lock()
+	breaker.Policy = policy
+	breaker.mu.Unlock()
+
+	return breaker
+}
+
+// BreakerStatuses returns the state of every provider in a fallback chain,
+// sorted by name.
+func BreakerStatuses() []BreakerStatus {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+
+	statuses := make([]BreakerStatus, 0, len(breakers))
+	for _, breaker := range breakers {
+		statuses = append(statuses, breaker.Status())
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
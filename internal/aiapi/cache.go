@@ -0,0 +1,204 @@
+package aiapi
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sessionsdev/blue-octopus/internal/redis"
+)
+
+// CachingClient answers repeated requests from a response cache in Redis
+// before calling the client it wraps.  Responses served from the cache use
+// no tokens.
+type CachingClient struct {
+	Client     AIClient
+	Role       string
+	Config     RoleConfig
+	TTLMinutes int
+}
+
+// NewCachingClient wraps a role's client with the response cache when its
+// config opts in.  Sampling at a temperature above zero gives a different
+// answer every time, so those roles are only cached with
+// cache_at_temperature set.
+func NewCachingClient(role string, config RoleConfig, client AIClient) AIClient {
+	if config.CacheTTLMinutes <= 0 {
+		return client
+	}
+	if config.Temperature > 0 && !config.CacheAtTemperature {
+		log.Printf("Not caching responses for %s, its temperature is %v", role, config.Temperature)
+		return client
+	}
+
+	return &CachingClient{
+		Client:     client,
+		Role:       role,
+		Config:     config,
+		TTLMinutes: config.CacheTTLMinutes,
+	}
+}
+
+func (c *CachingClient) DoRequest(ctx context.Context, messages []AiMessage) (ChatResponse, error) {
+	return c.cached(ctx, messages, nil, func() (ChatResponse, error) {
+		return c.Client.DoRequest(ctx, messages)
+	})
+}
+
+func (c *CachingClient) DoSchemaRequest(ctx context.Context, messages []AiMessage, schema *JSONSchema) (ChatResponse, error) {
+	return c.cached(ctx, messages, schema, func() (ChatResponse, error) {
+		if schemaClient, ok := c.Client.(SchemaAIClient); ok {
+			return schemaClient.DoSchemaRequest(ctx, messages, schema)
+		}
+		return c.Client.DoRequest(ctx, messages)
+	})
+}
+
+// DoStreamRequest delivers a cached completion as a single chunk.
+func (c *CachingClient) DoStreamRequest(ctx context.Context, messages []AiMessage, onChunk func(string)) (ChatResponse, error) {
+	streamed := false
+	response, err := c.cached(ctx, messages, nil, func() (ChatResponse, error) {
+		streamingClient, ok := c.Client.(StreamingAIClient)
+		if !ok {
+			return c.Client.DoRequest(ctx, messages)
+		}
+		streamed = true
+		return streamingClient.DoStreamRequest(ctx, messages, onChunk)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !streamed {
+		onChunk(response.GetChatCompletion())
+	}
+	return response, nil
+}
+
+func (c *CachingClient) cached(ctx context.Context, messages []AiMessage, schema *JSONSchema, call func() (ChatResponse, error)) (ChatResponse, error) {
+	key := &redis.AICacheKey{Hash: c.cacheKey(messages, schema)}
+
+	var cachedResponse AiChatResponse
+	_, err := redis.GetObj(ctx, key, &cachedResponse)
+	if err == nil {
+		c.count(ctx, "hits")
+		return &cachedResponse, nil
+	}
+
+	var notFound *redis.NotFoundError
+	if !errors.As(err, &notFound) {
+		log.Println("Error reading the response cache: ", err)
+	}
+	c.count(ctx, "misses")
+
+	response, err := call()
+	if err != nil {
+		return response, err
+	}
+
+	// a cache hit costs nothing, so the copy stored has no usage
+	stored := AiChatResponse{
+		Completion: response.GetChatCompletion(),
+		Model:      response.GetModel(),
+	}
+	if err := redis.SetObj(ctx, key, stored, c.TTLMinutes); err != nil {
+		log.Println("Error writing the response cache: ", err)
+	}
+
+	return response, nil
+}
+
+func (c *CachingClient) count(ctx context.Context, field string) {
+	err := redis.IncrHashFields(ctx, &redis.AICacheStatsKey{}, map[string]int64{c.Role + ":" + field: 1}, nil, 0)
+	if err != nil {
+		log.Println("Error counting response cache "+field+": ", err)
+	}
+}
+
+// cacheKey hashes everything that shapes the response: the provider, model
+// and sampling parameters, the schema and the messages.  The role itself is
+// left out so roles sharing a configuration share their cache.
+func (c *CachingClient) cacheKey(messages []AiMessage, schema *JSONSchema) string {
+	canonical := struct {
+		Provider       string
+		BaseURL        string
+		Model          string
+		Temperature    float64
+		MaxTokens      int
+		ResponseFormat string
+		Schema         *JSONSchema
+		Messages       []AiMessage
+	}{
+		Provider:       c.Config.Provider,
+		BaseURL:        c.Config.BaseURL,
+		Model:          c.Config.Model,
+		Temperature:    c.Config.Temperature,
+		MaxTokens:      c.Config.MaxTokens,
+		ResponseFormat: c.Config.ResponseFormat,
+		Schema:         schema,
+		Messages:       messages,
+	}
+
+	// json orders map keys, so the same schema always hashes the same
+	data, _ := json.Marshal(canonical)
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// CacheStats are the response cache hits and misses of a role.
+type CacheStats struct {
+	Role   string
+	Hits   int64
+	Misses int64
+}
+
+// HitRate returns the percentage of requests answered from the cache.
+func (s CacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return 100 * float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// GetCacheStats returns the response cache counters of every role, sorted
+// by role.
+func GetCacheStats(ctx context.Context) ([]CacheStats, error) {
+	fields, err := redis.GetHash(ctx, &redis.AICacheStatsKey{})
+	if err != nil {
+		return nil, err
+	}
+
+	byRole := map[string]*CacheStats{}
+	for field, value := range fields {
+		role, counter, ok := strings.Cut(field, ":")
+		if !ok {
+			continue
+		}
+		if _, ok := byRole[role]; !ok {
+			byRole[role] = &CacheStats{Role: role}
+		}
+
+		count, _ := strconv.ParseInt(value, 10, 64)
+		switch counter {
+		case "hits":
+			byRole[role].Hits = count
+		case "misses":
+			byRole[role].Misses = count
+		}
+	}
+
+	stats := make([]CacheStats, 0, len(byRole))
+	for _, roleStats := range byRole {
+		stats = append(stats, *roleStats)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Role < stats[j].Role
+	})
+	return stats, nil
+}
@@ -0,0 +1,36 @@
+package aiapi
+
+import "testing"
+
+func TestNewCachingClientIsOptIn(t *testing.T) {
+	client := New("gpt-4o-mini", 0, ResponseFormat{Type: "text"})
+
+	if _, ok := NewCachingClient(RoleStateManager, RoleConfig{Temperature: 0}, client).(*CachingClient); ok {
+		t.Errorf("Expected no cache without a ttl")
+	}
+
+	if _, ok := NewCachingClient(RoleNarrator, RoleConfig{Temperature: 0.7, CacheTTLMinutes: 10}, client).(*CachingClient); ok {
+		t.Errorf("Expected no cache for sampling at a temperature")
+	}
+
+	config := RoleConfig{Temperature: 0.7, CacheTTLMinutes: 10, CacheAtTemperature: true}
+	if _, ok := NewCachingClient(RoleNarrator, config, client).(*CachingClient); !ok {
+		t.Errorf("Expected a cache when configured at a temperature")
+	}
+}
+
+func TestCacheKeyCoversParameters(t *testing.T) {
+	messages := []AiMessage{{Provider: "user", Message: "look around"}}
+	cold := &CachingClient{Config: RoleConfig{Provider: ProviderOpenAI, Model: "gpt-4o-mini", Temperature: 0}}
+	warm := &CachingClient{Config: RoleConfig{Provider: ProviderOpenAI, Model: "gpt-4o-mini", Temperature: 0.5}}
+
+	if cold.cacheKey(messages, nil) != cold.cacheKey(messages, nil) {
+		t.Errorf("Expected the same request to hash the same")
+	}
+	if cold.cacheKey(messages, nil) == warm.cacheKey(messages, nil) {
+		t.Errorf("Expected a different temperature to hash differently")
+	}
+	if cold.cacheKey(messages, nil) == cold.cacheKey(messages, SchemaFor("test", StreamOptions{})) {
+		t.Errorf("Expected a schema to hash differently")
+	}
+}
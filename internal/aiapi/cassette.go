@@ -0,0 +1,243 @@
+package aiapi
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Cassette modes, set with AI_CASSETTE_MODE.
+const (
+	CassetteRecord = "record"
+	CassetteReplay = "replay"
+)
+
+// ErrCassetteMiss is returned when replaying a request that was never
+// recorded.  The cassette needs to be recorded again.
+var ErrCassetteMiss = errors.New("no recorded response for request")
+
+// Interaction is a recorded request and the provider's response to it.
+type Interaction struct {
+	Key         string          `json:"key"`
+	Method      string          `json:"method"`
+	Path        string          `json:"path"`
+	Request     json.RawMessage `json:"request"`
+	Status      int             `json:"status"`
+	ContentType string          `json:"content_type"`
+	Response    string          `json:"response"`
+}
+
+// Cassette is an http.RoundTripper that records provider calls to a file,
+// or replays them from it without touching the network.  Requests are
+// matched on a hash of their body, which holds the model and the messages,
+// so the same prompts always get the same response.  Request headers are
+// never recorded, keeping API keys out of the file.
+type Cassette struct {
+	Path string
+	Mode string
+
+	// Next sends requests while recording, http.DefaultTransport if nil.
+	Next http.RoundTripper
+
+	mu           sync.Mutex
+	Interactions []Interaction
+	played       map[string]int
+}
+
+type cassetteFile struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// LoadCassette opens the cassette at path.  A missing file is an empty
+// cassette when recording.
+func LoadCassette(path string, mode string) (*Cassette, error) {
+	if mode != CassetteRecord && mode != CassetteReplay {
+		return nil, fmt.Errorf("unknown cassette mode: %s", mode)
+	}
+
+	cassette := &Cassette{Path: path, Mode: mode, played: map[string]int{}}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && mode == CassetteRecord {
+		return cassette, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var file cassetteFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("error parsing cassette %s: %w", path, err)
+	}
+	cassette.Interactions = file.Interactions
+	return cassette, nil
+}
+
+func (c *Cassette) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	key := cassetteKey(req.Method, body)
+
+	if c.Mode == CassetteReplay {
+		interaction, ok := c.next(key)
+		if !ok {
+			return nil, fmt.Errorf("%w %s %s (key %s) in %s", ErrCassetteMiss, req.Method, req.URL.Path, key[:12], c.Path)
+		}
+		return interaction.toResponse(req), nil
+	}
+
+	next := c.Next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	req = req.Clone(req.Context())
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	resp, err := next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	interaction := Interaction{
+		Key:         key,
+		Method:      req.Method,
+		Path:        req.URL.Path,
+		Status:      resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+		Response:    string(responseBody),
+	}
+	if json.Valid(body) {
+		interaction.Request = body
+	}
+
+	if err := c.record(interaction); err != nil {
+		log.Printf("Error saving cassette %s: %v", c.Path, err)
+	}
+
+	return interaction.toResponse(req), nil
+}
+
+// next returns the recorded interaction for a key.  A request made several
+// times replays its recordings in order, repeating the last one once they
+// run out.
+func (c *Cassette) next(key string) (Interaction, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var matches []Interaction
+	for _, interaction := range c.Interactions {
+		if interaction.Key == key {
+			matches = append(matches, interaction)
+		}
+	}
+	if len(matches) == 0 {
+		return Interaction{}, false
+	}
+
+	index := c.played[key]
+	if index >= len(matches) {
+		index = len(matches) - 1
+	}
+	c.played[key]++
+	return matches[index], true
+}
+
+func (c *Cassette) record(interaction Interaction) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Interactions = append(c.Interactions, interaction)
+
+	data, err := json.MarshalIndent(cassetteFile{Interactions: c.Interactions}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.Path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(c.Path, data, 0644)
+}
+
+func (i Interaction) toResponse(req *http.Request) *http.Response {
+	header := http.Header{}
+	if i.ContentType != "" {
+		header.Set("Content-Type", i.ContentType)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", i.Status, http.StatusText(i.Status)),
+		StatusCode:    i.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewBufferString(i.Response)),
+		ContentLength: int64(len(i.Response)),
+		Request:       req,
+	}
+}
+
+func cassetteKey(method string, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(method))
+	hash.Write([]byte{0})
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+var (
+	cassettes   = map[string]*Cassette{}
+	cassettesMu sync.Mutex
+)
+
+// CassetteTransport returns the cassette configured with AI_CASSETTE_MODE
+// and AI_CASSETTE, or nil to use the network directly.  Clients sharing a
+// cassette file share one Cassette.
+func CassetteTransport() http.RoundTripper {
+	mode := os.Getenv("AI_CASSETTE_MODE")
+	if mode == "" {
+		return nil
+	}
+
+	path := os.Getenv("AI_CASSETTE")
+	if path == "" {
+		path = "testdata/cassettes/default.json"
+	}
+
+	cassettesMu.Lock()
+	defer cassettesMu.Unlock()
+
+	if cassette, ok := cassettes[path]; ok && cassette.Mode == mode {
+		return cassette
+	}
+
+	cassette, err := LoadCassette(path, mode)
+	if err != nil {
+		// never fall back to the live api, the calls could cost money
+		log.Printf("Unable to load cassette %s, every call will fail: %v", path, err)
+		cassette = &Cassette{Path: path, Mode: CassetteReplay, played: map[string]int{}}
+	}
+	cassettes[path] = cassette
+	return cassette
+}
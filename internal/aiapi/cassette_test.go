@@ -0,0 +1,53 @@
+package aiapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestCassetteRecordsAndReplays(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"model": "gpt-4o-mini", "choices": [{"message": {"role": "assistant", "content": "recorded"}}], "usage": {"total_tokens": 3}}`))
+	}))
+
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	messages := []AiMessage{{Provider: "user", Message: "hello"}}
+
+	recorder, err := LoadCassette(path, CassetteRecord)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	client := New("gpt-4o-mini", 0, ResponseFormat{Type: "text"})
+	client.BaseURL = server.URL
+	client.Client.Transport = recorder
+	if _, err := client.DoRequest(context.Background(), messages); err != nil {
+		t.Fatalf("Expected no error recording, but got %v", err)
+	}
+	server.Close()
+
+	player, err := LoadCassette(path, CassetteReplay)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	client.Client.Transport = player
+
+	response, err := client.DoRequest(context.Background(), messages)
+	if err != nil {
+		t.Fatalf("Expected no error replaying, but got %v", err)
+	}
+	if response.GetChatCompletion() != "recorded" || calls != 1 {
+		t.Errorf("Expected the recorded completion without a second call, but got %q after %d calls", response.GetChatCompletion(), calls)
+	}
+
+	_, err = client.DoRequest(context.Background(), []AiMessage{{Provider: "user", Message: "goodbye"}})
+	if !errors.Is(err, ErrCassetteMiss) {
+		t.Errorf("Expected a cassette miss for an unrecorded request, but got %v", err)
+	}
+}
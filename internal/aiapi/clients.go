@@ -0,0 +1,171 @@
+package aiapi
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Providers that can back a game role.
+const (
+	ProviderOpenAI    = "openai"
+	ProviderAnthropic = "anthropic"
+	ProviderLocal     = "local"
+)
+
+// Roles the game asks the models to play.
+const (
+	RoleNarrator              = "narrator"
+	RoleStateManager          = "state-manager"
+	RoleStorySummarizer       = "story-summarizer"
+	RoleProgressiveSummarizer = "progressive-summarizer"
+)
+
+var ModelMap = map[string]string{
+	"gpt3":   "gpt-3.5-turbo-0125",
+	"gpt4":   "gpt-4-0125-preview",
+	"haiku":  "claude-3-5-haiku-latest",
+	"sonnet": "claude-3-5-sonnet-latest",
+}
+
+// roleDefaults holds the model used for a role on each provider and the
+// environment variable that picks the provider.  They apply when no model
+// config file is present.
+var roleDefaults = map[string]struct {
+	ProviderEnv    string
+	Models         map[string]string
+	ResponseFormat string
+}{
+	RoleNarrator: {
+		ProviderEnv:    "NARRATOR_PROVIDER",
+		Models:         map[string]string{ProviderOpenAI: ModelMap["gpt3"], ProviderAnthropic: ModelMap["haiku"], ProviderLocal: ""},
+		ResponseFormat: "text",
+	},
+	RoleStateManager: {
+		ProviderEnv:    "STATE_MANAGER_PROVIDER",
+		Models:         map[string]string{ProviderOpenAI: ModelMap["gpt4"], ProviderAnthropic: ModelMap["sonnet"], ProviderLocal: ""},
+		ResponseFormat: "json_object",
+	},
+	RoleStorySummarizer: {
+		ProviderEnv:    "STORY_SUMMARIZER_PROVIDER",
+		Models:         map[string]string{ProviderOpenAI: ModelMap["gpt4"], ProviderAnthropic: ModelMap["sonnet"], ProviderLocal: ""},
+		ResponseFormat: "json_object",
+	},
+	RoleProgressiveSummarizer: {
+		ProviderEnv:    "STORY_SUMMARIZER_PROVIDER",
+		Models:         map[string]string{ProviderOpenAI: ModelMap["gpt4"], ProviderAnthropic: ModelMap["sonnet"], ProviderLocal: ""},
+		ResponseFormat: "json_object",
+	},
+}
+
+var (
+	roleClients   = map[string]AIClient{}
+	roleClientsMu sync.RWMutex
+)
+
+func init() {
+	if err := ApplyModelConfig(defaultModelConfig()); err != nil {
+		log.Printf("Unable to apply default model config: %v", err)
+	}
+}
+
+// defaultModelConfig builds the role config from environment variables.
+func defaultModelConfig() *ModelConfig {
+	config := &ModelConfig{Roles: map[string]RoleConfig{}}
+	for role, defaults := range roleDefaults {
+		provider := os.Getenv(defaults.ProviderEnv)
+		if provider == "" {
+			provider = os.Getenv("AI_PROVIDER")
+		}
+		if _, ok := defaults.Models[provider]; !ok {
+			if provider != "" {
+				log.Printf("Unknown ai provider %s for role %s, falling back to openai", provider, role)
+			}
+			provider = ProviderOpenAI
+		}
+
+		roleConfig := RoleConfig{
+			Provider:       provider,
+			Model:          defaults.Models[provider],
+			Temperature:    0.7,
+			ResponseFormat: defaults.ResponseFormat,
+		}
+
+		// AI_FALLBACK_PROVIDERS lists providers to fail over to, such as "local"
+		for _, fallback := range strings.Split(os.Getenv("AI_FALLBACK_PROVIDERS"), ",") {
+			fallback = strings.TrimSpace(fallback)
+			if fallback == "" || fallback == provider {
+				continue
+			}
+			if _, ok := defaults.Models[fallback]; !ok {
+				log.Printf("Unknown fallback ai provider %s for role %s, ignoring it", fallback, role)
+				continue
+			}
+			roleConfig.Fallbacks = append(roleConfig.Fallbacks, RoleConfig{
+				Provider:       fallback,
+				Model:          defaults.Models[fallback],
+				Temperature:    0.7,
+				ResponseFormat: defaults.ResponseFormat,
+			})
+		}
+
+		config.Roles[role] = roleConfig
+	}
+	return config
+}
+
+// NewClient builds a client for a role's provider and model settings.
+func NewClient(config RoleConfig) (AIClient, error) {
+	responseFormat := ResponseFormat{Type: config.ResponseFormat}
+	if responseFormat.Type == "" {
+		responseFormat.Type = "text"
+	}
+
+	switch config.Provider {
+	case ProviderOpenAI, ProviderLocal:
+		var client *OpenAIClient
+		if config.Provider == ProviderLocal {
+			client = NewLocal(config.Model, config.Temperature, responseFormat)
+		} else {
+			client = New(config.Model, config.Temperature, responseFormat)
+		}
+		if config.BaseURL != "" {
+			client.BaseURL = config.BaseURL
+		}
+		client.MaxTokens = config.MaxTokens
+		return client, nil
+	case ProviderAnthropic:
+		client := NewAnthropic(config.Model, config.Temperature, responseFormat)
+		if config.BaseURL != "" {
+			client.BaseURL = config.BaseURL
+		}
+		if config.MaxTokens > 0 {
+			client.MaxTokens = config.MaxTokens
+		}
+		return client, nil
+	default:
+		return nil, fmt.Errorf("unknown ai provider: %s", config.Provider)
+	}
+}
+
+// RegisterClient sets the client used for a game role.
+func RegisterClient(role string, client AIClient) {
+	roleClientsMu.Lock()
+	defer roleClientsMu.Unlock()
+
+	roleClients[role] = client
+}
+
+// GetClient returns the client registered for a game role.
+func GetClient(role string) (AIClient, error) {
+	roleClientsMu.RLock()
+	defer roleClientsMu.RUnlock()
+
+	client, ok := roleClients[role]
+	if !ok {
+		return nil, fmt.Errorf("no ai client registered for role: %s", role)
+	}
+	return client, nil
+}
@@ -0,0 +1,238 @@
+package aiapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const defaultModelConfigPath = "config/models.json"
+
+// RoleConfig selects the provider and model settings for a game role.
+type RoleConfig struct {
+	Provider       string  `json:"provider"`
+	Model          string  `json:"model"`
+	Temperature    float64 `json:"temperature"`
+	MaxTokens      int     `json:"max_tokens"`
+	ResponseFormat string  `json:"response_format"`
+	BaseURL        string  `json:"base_url,omitempty"`
+	ContextBudget  int     `json:"context_budget,omitempty"`
+
+	// CacheTTLMinutes turns on the response cache for the role.
+	CacheTTLMinutes    int  `json:"cache_ttl_minutes,omitempty"`
+	CacheAtTemperature bool `json:"cache_at_temperature,omitempty"`
+
+	// Fallbacks are tried in order when the provider above is failing.
+	Fallbacks []RoleConfig `json:"fallbacks,omitempty"`
+}
+
+// ModelConfig maps each game role to its model settings and optionally
+// overrides the price of models and the circuit breaker policy.
+type ModelConfig struct {
+	Roles   map[string]RoleConfig `json:"roles"`
+	Pricing map[string]ModelPrice `json:"pricing,omitempty"`
+	Breaker *BreakerConfig        `json:"breaker,omitempty"`
+}
+
+// BreakerConfig overrides the default circuit breaker policy.  Zero values
+// keep the default.
+type BreakerConfig struct {
+	FailureThreshold int     `json:"failure_threshold"`
+	LatencySeconds   float64 `json:"latency_seconds"`
+	OpenSeconds      float64 `json:"open_seconds"`
+}
+
+func (b *BreakerConfig) policy() BreakerPolicy {
+	policy := DefaultBreakerPolicy
+	if b == nil {
+		return policy
+	}
+	if b.FailureThreshold > 0 {
+		policy.FailureThreshold = b.FailureThreshold
+	}
+	if b.LatencySeconds > 0 {
+		policy.LatencyThreshold = time.Duration(b.LatencySeconds * float64(time.Second))
+	}
+	if b.OpenSeconds > 0 {
+		policy.OpenDuration = time.Duration(b.OpenSeconds * float64(time.Second))
+	}
+	return policy
+}
+
+var (
+	currentModelConfig   *ModelConfig
+	currentModelConfigMu sync.RWMutex
+)
+
+// ModelConfigPath returns the model config file location, set with
+// AI_MODEL_CONFIG.
+func ModelConfigPath() string {
+	path := os.Getenv("AI_MODEL_CONFIG")
+	if path == "" {
+		path = defaultModelConfigPath
+	}
+	return path
+}
+
+// LoadModelConfig reads a model config file.
+func LoadModelConfig(path string) (*ModelConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config ModelConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("error parsing model config %s: %w", path, err)
+	}
+	return &config, nil
+}
+
+// ApplyModelConfig builds a client for every role in the config and swaps
+// them in.  If any role fails to build, no clients are changed.  Roles
+// missing from the config keep their current client.
+func ApplyModelConfig(config *ModelConfig) error {
+	clients := map[string]AIClient{}
+	for role, roleConfig := range config.Roles {
+		client, err := newRoleClient(role, roleConfig, config.Breaker.policy())
+		if err != nil {
+			return fmt.Errorf("role %s: %w", role, err)
+		}
+		clients[role] = client
+	}
+
+	currentModelConfigMu.Lock()
+	defer currentModelConfigMu.Unlock()
+
+	merged := &ModelConfig{Roles: map[string]RoleConfig{}}
+	if currentModelConfig != nil {
+		for role, roleConfig := range currentModelConfig.Roles {
+			merged.Roles[role] = roleConfig
+		}
+	}
+
+	for role, client := range clients {
+		RegisterClient(role, client)
+		merged.Roles[role] = config.Roles[role]
+	}
+	currentModelConfig = merged
+
+	return nil
+}
+
+// newRoleClient builds the client for a role, with its response cache and,
+// when fallbacks are configured, a fallback chain guarded by circuit
+// breakers.
+func newRoleClient(role string, config RoleConfig, policy BreakerPolicy) (AIClient, error) {
+	client, err := NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+	client = NewCachingClient(role, config, client)
+
+	if len(config.Fallbacks) == 0 {
+		return client, nil
+	}
+
+	fallbackClient := &FallbackClient{Role: role}
+	chain := append([]RoleConfig{config}, config.Fallbacks...)
+	for i, entryConfig := range chain {
+		entryClient := client
+		if i > 0 {
+			entryClient, err = NewClient(entryConfig)
+			if err != nil {
+				return nil, fmt.Errorf("fallback %d: %w", i, err)
+			}
+			entryClient = NewCachingClient(role, entryConfig, entryClient)
+		}
+
+		breaker := breakerFor(entryConfig, policy)
+		fallbackClient.Entries = append(fallbackClient.Entries, FallbackEntry{
+			Name:    breaker.Name,
+			Client:  entryClient,
+			Breaker: breaker,
+		})
+	}
+	return fallbackClient, nil
+}
+
+// NewRoleClient builds a client for a role outside the model config, with
+// the default circuit breaker policy.
+func NewRoleClient(role string, config RoleConfig) (AIClient, error) {
+	return newRoleClient(role, config, DefaultBreakerPolicy)
+}
+
+// ReloadModelConfig loads and applies the model config file.  A missing
+// file leaves the current clients in place.
+func ReloadModelConfig() error {
+	path := ModelConfigPath()
+
+	config, err := LoadModelConfig(path)
+	if err != nil {
+		return err
+	}
+
+	if err := ApplyModelConfig(config); err != nil {
+		return err
+	}
+	SetPricing(config.Pricing)
+
+	log.Printf("Loaded model config from %s", path)
+	return nil
+}
+
+// defaultReplyTokens is held back from the context window for the reply
+// when a role has no max tokens set.
+const defaultReplyTokens = 1024
+
+// ContextBudget returns the prompt tokens a role may use and the model they
+// are counted for.  It is the role's context_budget when set, capped to what
+// the model's context window leaves for the reply.
+func ContextBudget(role string) (int, string) {
+	var roleConfig RoleConfig
+	currentModelConfigMu.RLock()
+	if currentModelConfig != nil {
+		roleConfig = currentModelConfig.Roles[role]
+	}
+	currentModelConfigMu.RUnlock()
+
+	replyTokens := roleConfig.MaxTokens
+	if replyTokens <= 0 {
+		replyTokens = defaultReplyTokens
+	}
+
+	budget := ContextWindowFor(roleConfig.Model) - replyTokens
+	if roleConfig.ContextBudget > 0 && roleConfig.ContextBudget < budget {
+		budget = roleConfig.ContextBudget
+	}
+	return budget, roleConfig.Model
+}
+
+// RoleConfigEntry is a role and its settings, for display.
+type RoleConfigEntry struct {
+	Role string
+	RoleConfig
+}
+
+// CurrentModelConfig returns the settings in use for each role, sorted by role.
+func CurrentModelConfig() []RoleConfigEntry {
+	currentModelConfigMu.RLock()
+	defer currentModelConfigMu.RUnlock()
+
+	entries := []RoleConfigEntry{}
+	if currentModelConfig == nil {
+		return entries
+	}
+
+	for role, roleConfig := range currentModelConfig.Roles {
+		entries = append(entries, RoleConfigEntry{Role: role, RoleConfig: roleConfig})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Role < entries[j].Role
+	})
+	return entries
+}
@@ -0,0 +1,78 @@
+package aiapi
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReloadModelConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "models.json")
+	os.WriteFile(path, []byte(`{
+		"roles": {
+			"narrator": {"provider": "anthropic", "model": "test-narrator", "temperature": 0.2, "max_tokens": 300, "response_format": "text"}
+		}
+	}`), 0644)
+	t.Setenv("AI_MODEL_CONFIG", path)
+
+	if err := ReloadModelConfig(); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+
+	client, err := GetClient(RoleNarrator)
+	if err != nil {
+		t.Fatalf("Expected a narrator client, but got %v", err)
+	}
+
+	anthropicClient, ok := client.(*AnthropicClient)
+	if !ok || anthropicClient.Model != "test-narrator" || anthropicClient.MaxTokens != 300 {
+		t.Errorf("Expected the configured anthropic narrator, but got %+v", client)
+	}
+
+	if _, err := GetClient(RoleStateManager); err != nil {
+		t.Errorf("Expected roles missing from the config to keep their client, but got %v", err)
+	}
+}
+
+func TestApplyModelConfigRejectsUnknownProvider(t *testing.T) {
+	before, _ := GetClient(RoleStateManager)
+
+	err := ApplyModelConfig(&ModelConfig{Roles: map[string]RoleConfig{
+		RoleStateManager: {Provider: "openai", Model: "test-model"},
+		RoleNarrator:     {Provider: "not-a-provider"},
+	}})
+	if err == nil {
+		t.Fatalf("Expected an error for an unknown provider")
+	}
+
+	after, _ := GetClient(RoleStateManager)
+	if before != after {
+		t.Errorf("Expected no clients to change when the config is invalid")
+	}
+}
+
+func TestPriceForDatedModel(t *testing.T) {
+	SetPricing(nil)
+
+	price, ok := PriceFor("gpt-4o-mini-2024-07-18")
+	if !ok {
+		t.Fatalf("Expected a price for gpt-4o-mini-2024-07-18")
+	}
+	if price != defaultPricing["gpt-4o-mini"] {
+		t.Errorf("Expected the gpt-4o-mini price, but got %+v", price)
+	}
+
+	if _, ok := PriceFor("llama3:8b"); ok {
+		t.Errorf("Expected no price for a local model")
+	}
+}
+
+func TestCostWithPricingOverride(t *testing.T) {
+	SetPricing(map[string]ModelPrice{"llama3": {Prompt: 1, Completion: 2}})
+	defer SetPricing(nil)
+
+	cost := Cost("llama3:8b", Usage{PromptTokens: 1_000_000, CompletionTokens: 500_000})
+	if cost != 2 {
+		t.Errorf("Expected a cost of 2, but got %v", cost)
+	}
+}
@@ -0,0 +1,246 @@
+package aiapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// embeddingDimensions is the length of the vectors asked of OpenAI, small
+// enough to keep a few hundred memories per game in redis.
+const embeddingDimensions = 256
+
+// hashDimensions is the length of HashEmbedder vectors.  Word hashes collide
+// often in fewer buckets.  Vectors of different lengths never match, so the
+// memories embedded before switching embedders are simply not recalled.
+const hashDimensions = 1024
+
+// Embedder turns texts into vectors whose cosine similarity reflects how
+// related the texts are.
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// HashEmbedder is a local embedder that hashes the words of a text into a
+// fixed number of buckets.  It only captures
+// shared words, not meaning, but needs no network and gives the same vector
+// every time, which makes it the embedder for offline play and tests.
+type HashEmbedder struct{}
+
+var embeddingWordPattern = regexp.MustCompile(`[a-z0-9]+`)
+
+// words too common to say anything about what a text is about
+var embeddingStopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "by": true, "for": true,
+	"from": true, "has": true, "he": true, "her": true, "his": true, "i": true, "in": true, "is": true, "it": true,
+	"its": true, "of": true, "on": true, "or": true, "she": true, "that": true, "the": true, "their": true,
+	"they": true, "this": true, "to": true, "was": true, "with": true, "you": true, "your": true,
+}
+
+func (HashEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vector := make([]float32, hashDimensions)
+
+		for _, word := range embeddingWordPattern.FindAllString(strings.ToLower(text), -1) {
+			if !embeddingStopWords[word] {
+				addFeature(vector, strings.TrimSuffix(word, "s"))
+			}
+		}
+
+		vectors[i] = normalize(vector)
+	}
+	return vectors, nil
+}
+
+func addFeature(vector []float32, feature string) {
+	hash := fnv.New64a()
+	hash.Write([]byte(feature))
+	sum := mix(hash.Sum64())
+
+	// the sign bit spreads collisions so they cancel rather than pile up
+	if sum>>63 == 0 {
+		vector[sum%hashDimensions]--
+	} else {
+		vector[sum%hashDimensions]++
+	}
+}
+
+// mix scrambles the bits of a hash, fnv alone puts short words with the
+// same ending into the same bucket.
+func mix(k uint64) uint64 {
+	k ^= k >> 33
+	k *= 0xff51afd7ed558ccd
+	k ^= k >> 33
+	k *= 0xc4ceb9fe1a85ec53
+	k ^= k >> 33
+	return k
+}
+
+func normalize(vector []float32) []float32 {
+	var norm float64
+	for _, value := range vector {
+		norm += float64(value) * float64(value)
+	}
+	if norm == 0 {
+		return vector
+	}
+
+	scale := float32(1 / math.Sqrt(norm))
+	for i := range vector {
+		vector[i] *= scale
+	}
+	return vector
+}
+
+// CosineSimilarity compares two vectors, 1 meaning they point the same way.
+func CosineSimilarity(a []float32, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+const defaultEmbeddingModel = "text-embedding-3-small"
+
+// OpenAIEmbedder calls OpenAI's embeddings endpoint.
+type OpenAIEmbedder struct {
+	Client      *http.Client
+	BaseURL     string
+	APIKey      string
+	Model       string
+	RetryPolicy RetryPolicy
+}
+
+func NewOpenAIEmbedder() *OpenAIEmbedder {
+	baseURL := os.Getenv("OPENAI_BASE_URL")
+	if baseURL == "" {
+		baseURL = defaultOpenAIBaseURL
+	}
+
+	model := os.Getenv("EMBEDDING_MODEL")
+	if model == "" {
+		model = defaultEmbeddingModel
+	}
+
+	return &OpenAIEmbedder{
+		Client: &http.Client{
+			Transport: CassetteTransport(),
+			Timeout:   15 * time.Second,
+		},
+		BaseURL:     baseURL,
+		APIKey:      os.Getenv("OPENAI_API_KEY"),
+		Model:       model,
+		RetryPolicy: RetryPolicy{MaxAttempts: 2, BaseDelay: 250 * time.Millisecond, MaxDelay: time.Second, CallTimeout: 20 * time.Second},
+	}
+}
+
+type embeddingRequest struct {
+	Model      string   `json:"model"`
+	Input      []string `json:"input"`
+	Dimensions int      `json:"dimensions"`
+}
+
+type embeddingResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+}
+
+func (e *OpenAIEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	requestBody, err := json.Marshal(embeddingRequest{Model: e.Model, Input: texts, Dimensions: embeddingDimensions})
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling request: %w", err)
+	}
+
+	url := strings.TrimSuffix(e.BaseURL, "/") + "/embeddings"
+
+	resp, err := doWithRetry(ctx, e.Client, "openai", e.RetryPolicy, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(requestBody))
+		if err != nil {
+			return nil, fmt.Errorf("error creating request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if e.APIKey != "" {
+			req.Header.Set("Authorization", "Bearer "+e.APIKey)
+		}
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var response embeddingResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("error decoding response: %w", err)
+	}
+
+	vectors := make([][]float32, len(texts))
+	for _, data := range response.Data {
+		if data.Index < 0 || data.Index >= len(texts) {
+			return nil, fmt.Errorf("embedding response index %d out of range", data.Index)
+		}
+		vectors[data.Index] = data.Embedding
+	}
+	for i, vector := range vectors {
+		if vector == nil {
+			return nil, fmt.Errorf("embedding response is missing input %d", i)
+		}
+	}
+	return vectors, nil
+}
+
+var (
+	embedder   Embedder
+	embedderMu sync.Mutex
+)
+
+// GetEmbedder returns the embedder picked with EMBEDDING_PROVIDER, "local"
+// by default or "openai".
+func GetEmbedder() Embedder {
+	embedderMu.Lock()
+	defer embedderMu.Unlock()
+
+	if embedder == nil {
+		switch provider := os.Getenv("EMBEDDING_PROVIDER"); provider {
+		case ProviderOpenAI:
+			embedder = NewOpenAIEmbedder()
+		default:
+			if provider != "" && provider != ProviderLocal {
+				log.Printf("Unknown embedding provider %s, falling back to local", provider)
+			}
+			embedder = HashEmbedder{}
+		}
+	}
+	return embedder
+}
+
+// SetEmbedder replaces the embedder.
+func SetEmbedder(e Embedder) {
+	embedderMu.Lock()
+	defer embedderMu.Unlock()
+
+	embedder = e
+}
@@ -0,0 +1,162 @@
+package aiapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrorKind classifies why a provider call failed.
+type ErrorKind string
+
+const (
+	ErrorRateLimited ErrorKind = "rate_limited"
+	ErrorOverloaded  ErrorKind = "overloaded"
+	ErrorAuth        ErrorKind = "auth"
+	ErrorBadRequest  ErrorKind = "bad_request"
+	ErrorTimeout     ErrorKind = "timeout"
+	ErrorServer      ErrorKind = "server"
+	ErrorNetwork     ErrorKind = "network"
+	ErrorCanceled    ErrorKind = "canceled"
+)
+
+// APIError is returned by the clients when a provider call fails.
+type APIError struct {
+	Provider   string
+	Kind       ErrorKind
+	StatusCode int
+	RetryAfter time.Duration
+	Message    string
+	Err        error
+}
+
+func (e *APIError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("%s %s error (status %d): %s", e.Provider, e.Kind, e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("%s %s error: %s", e.Provider, e.Kind, e.Message)
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable reports whether the same request may succeed if sent again.
+func (e *APIError) Retryable() bool {
+	switch e.Kind {
+	case ErrorRateLimited, ErrorOverloaded, ErrorTimeout, ErrorServer, ErrorNetwork:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsBusy reports whether err means the provider is temporarily unable to
+// serve requests.
+func IsBusy(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.Retryable()
+}
+
+type providerErrorBody struct {
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// classifyResponse builds an APIError from a non-OK response, reading and
+// closing its body.
+func classifyResponse(provider string, resp *http.Response) *APIError {
+	defer resp.Body.Close()
+
+	buf := new(bytes.Buffer)
+	buf.ReadFrom(resp.Body)
+
+	message := resp.Status
+	var body providerErrorBody
+	if json.Unmarshal(buf.Bytes(), &body) == nil && body.Error.Message != "" {
+		message = body.Error.Message
+	}
+
+	apiErr := &APIError{
+		Provider:   provider,
+		StatusCode: resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header),
+		Message:    message,
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		apiErr.Kind = ErrorRateLimited
+	case resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == 529:
+		// 529 is Anthropic's overloaded status
+		apiErr.Kind = ErrorOverloaded
+	case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusGatewayTimeout:
+		apiErr.Kind = ErrorTimeout
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		apiErr.Kind = ErrorAuth
+	case resp.StatusCode >= 500:
+		apiErr.Kind = ErrorServer
+	default:
+		apiErr.Kind = ErrorBadRequest
+	}
+
+	return apiErr
+}
+
+// classifyTransportError builds an APIError from an error returned by the
+// http client.
+func classifyTransportError(provider string, err error) *APIError {
+	apiErr := &APIError{
+		Provider: provider,
+		Kind:     ErrorNetwork,
+		Message:  err.Error(),
+		Err:      err,
+	}
+
+	var netErr net.Error
+	if errors.Is(err, ErrCassetteMiss) {
+		// retrying won't make a recording appear
+		apiErr.Kind = ErrorBadRequest
+	} else if errors.Is(err, context.Canceled) {
+		apiErr.Kind = ErrorCanceled
+	} else if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		apiErr.Kind = ErrorTimeout
+	}
+
+	return apiErr
+}
+
+// parseRetryAfter reads the wait requested by the provider, if any.
+func parseRetryAfter(header http.Header) time.Duration {
+	// OpenAI sends a millisecond precision hint alongside the standard header
+	if ms := header.Get("retry-after-ms"); ms != "" {
+		if value, err := strconv.ParseFloat(ms, 64); err == nil && value > 0 {
+			return time.Duration(value * float64(time.Millisecond))
+		}
+	}
+
+	retryAfter := header.Get("Retry-After")
+	if retryAfter == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.ParseFloat(retryAfter, 64); err == nil && seconds > 0 {
+		return time.Duration(seconds * float64(time.Second))
+	}
+
+	if date, err := http.ParseTime(retryAfter); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,155 @@
+package aiapi
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// FallbackEntry is one provider in a role's fallback chain.
+type FallbackEntry struct {
+	Name    string
+	Client  AIClient
+	Breaker *CircuitBreaker
+}
+
+// FallbackClient sends each request to the first provider in its chain
+// whose circuit breaker allows it, failing over to the next provider when a
+// call fails because of the provider.
+type FallbackClient struct {
+	Role    string
+	Entries []FallbackEntry
+}
+
+func (c *FallbackClient) DoRequest(ctx context.Context, messages []AiMessage) (ChatResponse, error) {
+	return c.try(ctx, func(client AIClient) (ChatResponse, error) {
+		return client.DoRequest(ctx, messages)
+	}, nil)
+}
+
+func (c *FallbackClient) DoSchemaRequest(ctx context.Context, messages []AiMessage, schema *JSONSchema) (ChatResponse, error) {
+	return c.try(ctx, func(client AIClient) (ChatResponse, error) {
+		if schemaClient, ok := client.(SchemaAIClient); ok {
+			return schemaClient.DoSchemaRequest(ctx, messages, schema)
+		}
+		return client.DoRequest(ctx, messages)
+	}, nil)
+}
+
+// DoStreamRequest only fails over before the first chunk, so the player
+// never sees two providers' narratives spliced together.
+func (c *FallbackClient) DoStreamRequest(ctx context.Context, messages []AiMessage, onChunk func(string)) (ChatResponse, error) {
+	started := false
+	return c.try(ctx, func(client AIClient) (ChatResponse, error) {
+		streamingClient, ok := client.(StreamingAIClient)
+		if !ok {
+			response, err := client.DoRequest(ctx, messages)
+			if err != nil {
+				return nil, err
+			}
+			started = true
+			onChunk(response.GetChatCompletion())
+			return response, nil
+		}
+
+		return streamingClient.DoStreamRequest(ctx, messages, func(chunk string) {
+			started = true
+			onChunk(chunk)
+		})
+	}, func() bool { return !started })
+}
+
+// try calls each allowed provider in turn until one succeeds.  canFailover,
+// if set, can veto moving on to the next provider.
+func (c *FallbackClient) try(ctx context.Context, call func(client AIClient) (ChatResponse, error), canFailover func() bool) (ChatResponse, error) {
+	var lastErr error
+	for i, entry := range c.Entries {
+		if !entry.Breaker.Allow() {
+			continue
+		}
+
+		start := time.Now()
+		response, err := call(entry.Client)
+		if ctx.Err() != nil {
+			// the caller gave up, that says nothing about the provider
+			entry.Breaker.Abandon()
+			return response, err
+		}
+		if err != nil && !providerAtFault(err) {
+			// the provider answered, it just didn't like the request
+			entry.Breaker.Record(time.Since(start), nil)
+		} else {
+			entry.Breaker.Record(time.Since(start), err)
+		}
+
+		if err == nil {
+			setServing(c.Role, entry.Name, i > 0)
+			return response, nil
+		}
+
+		lastErr = err
+		if !providerAtFault(err) || (canFailover != nil && !canFailover()) {
+			return response, err
+		}
+		log.Printf("Provider %s failed for %s, trying the next one: %v", entry.Name, c.Role, err)
+	}
+
+	if lastErr != nil {
+		return nil, lastErr
+	}
+	return nil, &APIError{
+		Provider: c.Role,
+		Kind:     ErrorOverloaded,
+		Message:  "every provider is unavailable",
+	}
+}
+
+// providerAtFault reports whether another provider might succeed where err
+// failed.  A malformed request or a cancelled call would fail anywhere.
+func providerAtFault(err error) bool {
+	// the model answered, it just didn't follow the schema
+	var toolErr *SchemaToolError
+	if errors.As(err, &toolErr) {
+		return false
+	}
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return true
+	}
+	return apiErr.Kind != ErrorBadRequest && apiErr.Kind != ErrorCanceled
+}
+
+var (
+	degradedRoles   = map[string]string{}
+	degradedRolesMu sync.RWMutex
+)
+
+func setServing(role string, name string, degraded bool) {
+	degradedRolesMu.Lock()
+	defer degradedRolesMu.Unlock()
+
+	if degraded {
+		if degradedRoles[role] != name {
+			log.Printf("Role %s is now served by fallback provider %s", role, name)
+		}
+		degradedRoles[role] = name
+	} else {
+		delete(degradedRoles, role)
+	}
+}
+
+// DegradedRoles returns the roles whose last request was answered by a
+// fallback provider, and the provider that answered it.
+func DegradedRoles() map[string]string {
+	degradedRolesMu.RLock()
+	defer degradedRolesMu.RUnlock()
+
+	roles := make(map[string]string, len(degradedRoles))
+	for role, name := range degradedRoles {
+		roles[role] = name
+	}
+	return roles
+}
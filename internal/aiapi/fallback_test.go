@@ -0,0 +1,88 @@
+package aiapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerOpensAndProbes(t *testing.T) {
+	breaker := &CircuitBreaker{Name: "test", Policy: BreakerPolicy{FailureThreshold: 2, OpenDuration: 20 * time.Millisecond}}
+	failure := errors.New("overloaded")
+
+	breaker.Record(time.Millisecond, failure)
+	if !breaker.Allow() {
+		t.Fatalf("Expected the breaker to stay closed after one failure")
+	}
+	breaker.Record(time.Millisecond, failure)
+	if breaker.Allow() {
+		t.Fatalf("Expected the breaker to open after two failures")
+	}
+
+	time.Sleep(25 * time.Millisecond)
+	if !breaker.Allow() {
+		t.Fatalf("Expected a probe once the breaker has been open long enough")
+	}
+	if breaker.Allow() {
+		t.Errorf("Expected only one probe while half-open")
+	}
+
+	breaker.Record(time.Millisecond, nil)
+	if state := breaker.Status().State; state != BreakerClosed {
+		t.Errorf("Expected a successful probe to close the breaker, but got %s", state)
+	}
+}
+
+func TestCircuitBreakerCountsSlowCalls(t *testing.T) {
+	breaker := &CircuitBreaker{Name: "test", Policy: BreakerPolicy{FailureThreshold: 1, LatencyThreshold: time.Second, OpenDuration: time.Minute}}
+
+	breaker.Record(2*time.Second, nil)
+	if breaker.Allow() {
+		t.Errorf("Expected a slow call to open the breaker")
+	}
+}
+
+func TestFallbackClientFailsOver(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"model": "local-model", "choices": [{"message": {"role": "assistant", "content": "from the fallback"}}]}`))
+	}))
+	defer fallback.Close()
+
+	primaryClient := New("primary-model", 0.7, ResponseFormat{Type: "text"})
+	primaryClient.BaseURL = primary.URL
+	primaryClient.RetryPolicy = testRetryPolicy()
+
+	fallbackClient := New("local-model", 0.7, ResponseFormat{Type: "text"})
+	fallbackClient.BaseURL = fallback.URL
+
+	policy := BreakerPolicy{FailureThreshold: 1, OpenDuration: time.Minute}
+	client := &FallbackClient{
+		Role: "fallback-test",
+		Entries: []FallbackEntry{
+			{Name: "primary", Client: primaryClient, Breaker: &CircuitBreaker{Name: "primary", Policy: policy}},
+			{Name: "fallback", Client: fallbackClient, Breaker: &CircuitBreaker{Name: "fallback", Policy: policy}},
+		},
+	}
+
+	response, err := client.DoRequest(context.Background(), []AiMessage{{Provider: "user", Message: "look around"}})
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if response.GetChatCompletion() != "from the fallback" {
+		t.Errorf("Expected the fallback completion, but got %s", response.GetChatCompletion())
+	}
+	if DegradedRoles()["fallback-test"] != "fallback" {
+		t.Errorf("Expected the role to be reported as degraded, but got %v", DegradedRoles())
+	}
+	if client.Entries[0].Breaker.Status().State != BreakerOpen {
+		t.Errorf("Expected the primary breaker to open")
+	}
+}
@@ -0,0 +1,131 @@
+package aiapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const defaultLocalBaseURL = "http://localhost:11434/v1"
+
+// NewLocal builds a client for an OpenAI compatible server running on the
+// local machine, such as Ollama or the llama.cpp server. When no model is
+// given the first model the server reports is used.
+func NewLocal(model string, temp float64, responseFormat ResponseFormat) *OpenAIClient {
+	baseURL := os.Getenv("LOCAL_AI_BASE_URL")
+	if baseURL == "" {
+		baseURL = defaultLocalBaseURL
+	}
+
+	if model == "" {
+		model = os.Getenv("LOCAL_AI_MODEL")
+	}
+
+	return &OpenAIClient{
+		// local models on a laptop can be slow to answer
+		Client:         newChatHTTPClient(120 * time.Second),
+		ClientName:     "local",
+		BaseURL:        baseURL,
+		APIKey:         os.Getenv("LOCAL_AI_API_KEY"),
+		Model:          model,
+		Temperature:    temp,
+		ResponseFormat: responseFormat,
+		RetryPolicy:    DefaultRetryPolicy,
+	}
+}
+
+// resolveModel returns the configured model, discovering one from the
+// server the first time if none was set.
+func (c *OpenAIClient) resolveModel(ctx context.Context) (string, error) {
+	c.modelMu.Lock()
+	defer c.modelMu.Unlock()
+
+	if c.Model != "" {
+		return c.Model, nil
+	}
+
+	models, err := ListModels(ctx, c.Client, c.BaseURL, c.APIKey)
+	if err != nil {
+		return "", fmt.Errorf("error discovering models: %w", err)
+	}
+	if len(models) == 0 {
+		return "", fmt.Errorf("no models available at %s", c.BaseURL)
+	}
+
+	log.Printf("Using discovered model %s from %s", models[0], c.BaseURL)
+	c.Model = models[0]
+	return c.Model, nil
+}
+
+type openAiModelList struct {
+	Data []struct {
+		ID string `json:"id"`
+	} `json:"data"`
+}
+
+type ollamaTagList struct {
+	Models []struct {
+		Name string `json:"name"`
+	} `json:"models"`
+}
+
+// ListModels returns the models served at baseURL.  The OpenAI compatible
+// /models endpoint is tried first, then Ollama's native /api/tags.
+func ListModels(ctx context.Context, client *http.Client, baseURL string, apiKey string) ([]string, error) {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+
+	var modelList openAiModelList
+	err := getJSON(ctx, client, baseURL+"/models", apiKey, &modelList)
+	if err == nil && len(modelList.Data) > 0 {
+		models := make([]string, 0, len(modelList.Data))
+		for _, m := range modelList.Data {
+			models = append(models, m.ID)
+		}
+		return models, nil
+	}
+
+	var tagList ollamaTagList
+	tagsErr := getJSON(ctx, client, strings.TrimSuffix(baseURL, "/v1")+"/api/tags", apiKey, &tagList)
+	if tagsErr != nil {
+		if err != nil {
+			return nil, err
+		}
+		return nil, tagsErr
+	}
+
+	models := make([]string, 0, len(tagList.Models))
+	for _, m := range tagList.Models {
+		models = append(models, m.Name)
+	}
+	return models, nil
+}
+
+func getJSON(ctx context.Context, client *http.Client, url string, apiKey string, target interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error making request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("received non-OK response status: %s", resp.Status)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(target); err != nil {
+		return fmt.Errorf("error decoding response: %w", err)
+	}
+	return nil
+}
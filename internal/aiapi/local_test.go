@@ -0,0 +1,67 @@
+package aiapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestListModelsFallsBackToOllamaTags(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/tags" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"models": [{"name": "llama3:8b"}, {"name": "mistral:7b"}]}`))
+	}))
+	defer server.Close()
+
+	models, err := ListModels(context.Background(), server.Client(), server.URL+"/v1", "")
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+
+	if len(models) != 2 || models[0] != "llama3:8b" {
+		t.Errorf("Expected the ollama models to be listed, but got %v", models)
+	}
+}
+
+func TestLocalClientDiscoversModelWithoutAuth(t *testing.T) {
+	var received ChatRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			t.Errorf("Expected no Authorization header, but got %s", r.Header.Get("Authorization"))
+		}
+
+		switch r.URL.Path {
+		case "/v1/models":
+			w.Write([]byte(`{"data": [{"id": "local-model"}]}`))
+		case "/v1/chat/completions":
+			json.NewDecoder(r.Body).Decode(&received)
+			w.Write([]byte(`{"choices": [{"message": {"role": "assistant", "content": "You are in a field."}}], "usage": {"total_tokens": 7}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := NewLocal("", 0.7, ResponseFormat{Type: "text"})
+	client.BaseURL = server.URL + "/v1"
+	client.APIKey = ""
+	client.Model = ""
+
+	response, err := client.DoRequest(context.Background(), []AiMessage{{Provider: "user", Message: "look around"}})
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+
+	if received.Model != "local-model" {
+		t.Errorf("Expected the discovered model to be used, but got %s", received.Model)
+	}
+
+	if response.GetChatCompletion() != "You are in a field." {
+		t.Errorf("Expected the completion to be returned, but got %s", response.GetChatCompletion())
+	}
+}
@@ -0,0 +1,90 @@
+package aiapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const defaultModerationModel = "omni-moderation-latest"
+
+// ModerationResult is the moderation endpoint's verdict on a piece of text.
+type ModerationResult struct {
+	Flagged        bool               `json:"flagged"`
+	Categories     map[string]bool    `json:"categories"`
+	CategoryScores map[string]float64 `json:"category_scores"`
+}
+
+type ModerationResponse struct {
+	ID      string             `json:"id"`
+	Model   string             `json:"model"`
+	Results []ModerationResult `json:"results"`
+}
+
+// ModerationClient calls OpenAI's moderation endpoint.
+type ModerationClient struct {
+	Client      *http.Client
+	BaseURL     string
+	APIKey      string
+	Model       string
+	RetryPolicy RetryPolicy
+}
+
+func NewModerationClient() *ModerationClient {
+	baseURL := os.Getenv("OPENAI_BASE_URL")
+	if baseURL == "" {
+		baseURL = defaultOpenAIBaseURL
+	}
+
+	return &ModerationClient{
+		Client: &http.Client{
+			Transport: CassetteTransport(),
+			Timeout:   10 * time.Second,
+		},
+		BaseURL:     baseURL,
+		APIKey:      os.Getenv("OPENAI_API_KEY"),
+		Model:       defaultModerationModel,
+		RetryPolicy: RetryPolicy{MaxAttempts: 2, BaseDelay: 250 * time.Millisecond, MaxDelay: time.Second, CallTimeout: 15 * time.Second},
+	}
+}
+
+// Moderate classifies a piece of text.
+func (c *ModerationClient) Moderate(ctx context.Context, input string) (*ModerationResult, error) {
+	requestBody, err := json.Marshal(map[string]string{"model": c.Model, "input": input})
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling request: %w", err)
+	}
+
+	url := strings.TrimSuffix(c.BaseURL, "/") + "/moderations"
+
+	resp, err := doWithRetry(ctx, c.Client, "openai", c.RetryPolicy, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(requestBody))
+		if err != nil {
+			return nil, fmt.Errorf("error creating request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if c.APIKey != "" {
+			req.Header.Set("Authorization", "Bearer "+c.APIKey)
+		}
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var moderationResponse ModerationResponse
+	if err := json.NewDecoder(resp.Body).Decode(&moderationResponse); err != nil {
+		return nil, fmt.Errorf("error decoding response: %w", err)
+	}
+
+	if len(moderationResponse.Results) == 0 {
+		return nil, fmt.Errorf("moderation response contained no results")
+	}
+	return &moderationResponse.Results[0], nil
+}
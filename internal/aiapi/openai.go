@@ -2,44 +2,42 @@ package aiapi
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
+	"strings"
+	"sync"
 	"time"
 )
 
-var OpenAiClient *OpenAIClient
-var OpenAiJsonClient *OpenAIClient
-
-var ModelMap = map[string]string{
-	"gpt3": "gpt-3.5-turbo-0125",
-	"gpt4": "gpt-4-0125-preview",
-}
-
-func init() {
-	OpenAiClient = New(ModelMap["gpt3"], 0.7, ResponseFormat{Type: "text"})
-	OpenAiJsonClient = New(ModelMap["gpt4"], 0.7, ResponseFormat{Type: "json_object"})
-}
+const defaultOpenAIBaseURL = "https://api.openai.com/v1"
 
 func New(model string, temp float64, responseFormat ResponseFormat) *OpenAIClient {
+	baseURL := os.Getenv("OPENAI_BASE_URL")
+	if baseURL == "" {
+		baseURL = defaultOpenAIBaseURL
+	}
+
 	return &OpenAIClient{
-		Client: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+		Client:         newChatHTTPClient(30 * time.Second),
 		ClientName:     "openai",
+		BaseURL:        baseURL,
 		APIKey:         os.Getenv("OPENAI_API_KEY"),
 		Model:          model,
 		Temperature:    temp,
 		ResponseFormat: responseFormat,
+		RetryPolicy:    DefaultRetryPolicy,
 	}
 }
 
 // OpenAiMessage represents a single message in the conversation.
 type OpenAiMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role       string           `json:"role"`
+	Content    string           `json:"content"`
+	ToolCalls  []OpenAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
 }
 
 func (m *OpenAiMessage) NewMessage(provider string, message string) *OpenAiMessage {
@@ -51,24 +49,28 @@ type ChatRequest struct {
 	Model          string          `json:"model"`
 	Messages       []OpenAiMessage `json:"messages"`
 	Temperature    float64         `json:"temperature"`
+	MaxTokens      int             `json:"max_tokens,omitempty"`
 	ResponseFormat ResponseFormat  `json:"response_format"`
+	Stream         bool            `json:"stream,omitempty"`
+	StreamOptions  *StreamOptions  `json:"stream_options,omitempty"`
+	Tools          []OpenAITool    `json:"tools,omitempty"`
+	ToolChoice     string          `json:"tool_choice,omitempty"`
 }
 
-type OpenAiChatResponse struct {
-	Completion string `json:"completion"`
-	TokensUsed int    `json:"tokens_used"`
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
 }
 
-func (resp *OpenAiChatResponse) GetChatCompletion() string {
-	return resp.Completion
-}
-
-func (resp *OpenAiChatResponse) GetTokenUsage() int {
-	return resp.TokensUsed
+type ResponseFormat struct {
+	Type       string          `json:"type"`
+	JSONSchema *JSONSchemaSpec `json:"json_schema,omitempty"`
 }
 
-type ResponseFormat struct {
-	Type string `json:"type"`
+// JSONSchemaSpec is the json_schema member of a structured output response format.
+type JSONSchemaSpec struct {
+	Name   string                 `json:"name"`
+	Schema map[string]interface{} `json:"schema"`
+	Strict bool                   `json:"strict"`
 }
 
 // Choice represents a single choice in the OpenAI response.
@@ -108,67 +110,130 @@ func (resp *OpenAIResponse) GetTokenUsage() int {
 type OpenAIClient struct {
 	Client         *http.Client
 	ClientName     string
+	BaseURL        string
 	APIKey         string
 	Model          string
 	Temperature    float64
+	MaxTokens      int
 	ResponseFormat ResponseFormat
-}
-
-func (c *OpenAIClient) DoRequest(userMessages []AiMessage) (*OpenAiChatResponse, error) {
-	openAiMessage := convertMessageType(userMessages)
+	RetryPolicy    RetryPolicy
 
-	chatRequest := ChatRequest{
-		Model:          c.Model,
-		Messages:       openAiMessage,
-		Temperature:    c.Temperature,
-		ResponseFormat: c.ResponseFormat,
-	}
+	modelMu sync.Mutex
+}
 
-	requestBody, err := json.Marshal(chatRequest)
+func (c *OpenAIClient) DoRequest(ctx context.Context, userMessages []AiMessage) (ChatResponse, error) {
+	chatRequest, err := c.buildRequest(ctx, userMessages)
 	if err != nil {
-		log.Panicf("HERE I AM!")
-		return &OpenAiChatResponse{}, fmt.Errorf("error marshaling request: %w", err)
+		return &AiChatResponse{}, err
 	}
 
-	url := "https://api.openai.com/v1/chat/completions"
+	return c.complete(ctx, chatRequest)
+}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(requestBody))
-	if err != nil {
-		return &OpenAiChatResponse{}, fmt.Errorf("error creating request: %w", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+c.APIKey)
+// complete sends a non streaming chat request and decodes the completion.
+func (c *OpenAIClient) complete(ctx context.Context, chatRequest ChatRequest) (ChatResponse, error) {
+	response, _, err := c.completeMessage(ctx, chatRequest)
+	return response, err
+}
 
-	// Log request attempt
-	resp, err := c.Client.Do(req)
+// completeMessage sends a non streaming chat request and returns the
+// completion along with the message it came in, which holds any tool calls.
+func (c *OpenAIClient) completeMessage(ctx context.Context, chatRequest ChatRequest) (*AiChatResponse, OpenAiMessage, error) {
+	resp, err := c.post(ctx, chatRequest)
 	if err != nil {
-		return &OpenAiChatResponse{}, fmt.Errorf("error making request: %w", err)
+		return &AiChatResponse{}, OpenAiMessage{}, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		// print response body
-		buf := new(bytes.Buffer)
-		buf.ReadFrom(resp.Body)
-		log.Printf("response body: %s", buf.String())
-		return &OpenAiChatResponse{}, fmt.Errorf("received non-OK response status: %s", resp.Status)
-	}
-
 	var openAiResponse OpenAIResponse
 	if err := json.NewDecoder(resp.Body).Decode(&openAiResponse); err != nil {
-		return &OpenAiChatResponse{}, fmt.Errorf("error decoding response: %w", err)
+		return &AiChatResponse{}, OpenAiMessage{}, fmt.Errorf("error decoding response: %w", err)
+	}
+
+	if len(openAiResponse.Choices) == 0 {
+		return &AiChatResponse{}, OpenAiMessage{}, fmt.Errorf("response contained no choices")
+	}
+
+	// some local servers don't echo the model
+	if openAiResponse.Model == "" {
+		openAiResponse.Model = chatRequest.Model
 	}
 
-	return &OpenAiChatResponse{
+	return &AiChatResponse{
 		Completion: openAiResponse.GetChatCompletion(),
 		TokensUsed: openAiResponse.GetTokenUsage(),
+		Usage:      openAiResponse.Usage,
+		Model:      openAiResponse.Model,
+	}, openAiResponse.Choices[0].Message, nil
+}
+
+// DoSchemaRequest asks for a completion constrained to the schema using
+// structured outputs.
+func (c *OpenAIClient) DoSchemaRequest(ctx context.Context, userMessages []AiMessage, schema *JSONSchema) (ChatResponse, error) {
+	chatRequest, err := c.buildRequest(ctx, userMessages)
+	if err != nil {
+		return &AiChatResponse{}, err
+	}
+	chatRequest.ResponseFormat = ResponseFormat{
+		Type: "json_schema",
+		JSONSchema: &JSONSchemaSpec{
+			Name:   schema.Name,
+			Schema: schema.Schema,
+			Strict: true,
+		},
+	}
+
+	return c.complete(ctx, chatRequest)
+}
+
+func (c *OpenAIClient) buildRequest(ctx context.Context, userMessages []AiMessage) (ChatRequest, error) {
+	model, err := c.resolveModel(ctx)
+	if err != nil {
+		return ChatRequest{}, err
+	}
+
+	return ChatRequest{
+		Model:          model,
+		Messages:       convertMessageType(userMessages),
+		Temperature:    c.Temperature,
+		MaxTokens:      c.MaxTokens,
+		ResponseFormat: c.ResponseFormat,
 	}, nil
 }
 
+// post sends the chat request, retrying according to the client's policy, and
+// returns the response once a 200 status has been received.  The caller must
+// close the response body.
+func (c *OpenAIClient) post(ctx context.Context, chatRequest ChatRequest) (*http.Response, error) {
+	requestBody, err := json.Marshal(chatRequest)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling request: %w", err)
+	}
+
+	url := strings.TrimSuffix(c.BaseURL, "/") + "/chat/completions"
+
+	return doWithRetry(ctx, c.Client, c.ClientName, c.RetryPolicy, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(requestBody))
+		if err != nil {
+			return nil, fmt.Errorf("error creating request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if c.APIKey != "" {
+			req.Header.Set("Authorization", "Bearer "+c.APIKey)
+		}
+		return req, nil
+	})
+}
+
 func convertMessageType(messages []AiMessage) []OpenAiMessage {
 	var openAiMessages []OpenAiMessage
 	for _, m := range messages {
-		openAiMessages = append(openAiMessages, OpenAiMessage{Role: m.Provider, Content: m.Message})
+		openAiMessages = append(openAiMessages, OpenAiMessage{
+			Role:       m.Provider,
+			Content:    m.Message,
+			ToolCalls:  toOpenAIToolCalls(m.ToolCalls),
+			ToolCallID: m.ToolCallID,
+		})
 	}
 	return openAiMessages
 }
@@ -0,0 +1,85 @@
+package aiapi
+
+import (
+	"strings"
+	"sync"
+)
+
+// ModelPrice is the cost in US dollars per million prompt and completion tokens.
+type ModelPrice struct {
+	Prompt     float64 `json:"prompt"`
+	Completion float64 `json:"completion"`
+}
+
+// defaultPricing lists the published prices of the models the game uses.
+// Entries in the model config's pricing section add to or replace these.
+var defaultPricing = map[string]ModelPrice{
+	"gpt-3.5-turbo":      {Prompt: 0.50, Completion: 1.50},
+	"gpt-4-0125-preview": {Prompt: 10.00, Completion: 30.00},
+	"gpt-4-turbo":        {Prompt: 10.00, Completion: 30.00},
+	"gpt-4o-mini":        {Prompt: 0.15, Completion: 0.60},
+	"gpt-4o":             {Prompt: 2.50, Completion: 10.00},
+	"claude-3-5-haiku":   {Prompt: 0.80, Completion: 4.00},
+	"claude-3-5-sonnet":  {Prompt: 3.00, Completion: 15.00},
+	"claude-3-haiku":     {Prompt: 0.25, Completion: 1.25},
+	"claude-3-opus":      {Prompt: 15.00, Completion: 75.00},
+}
+
+var (
+	pricing   = copyPricing(defaultPricing)
+	pricingMu sync.RWMutex
+)
+
+func copyPricing(source map[string]ModelPrice) map[string]ModelPrice {
+	prices := make(map[string]ModelPrice, len(source))
+	for model, price := range source {
+		prices[model] = price
+	}
+	return prices
+}
+
+// SetPricing replaces the configured prices, keeping the defaults for
+// models that are not listed.
+func SetPricing(overrides map[string]ModelPrice) {
+	prices := copyPricing(defaultPricing)
+	for model, price := range overrides {
+		prices[model] = price
+	}
+
+	pricingMu.Lock()
+	defer pricingMu.Unlock()
+	pricing = prices
+}
+
+// PriceFor returns the price of a model.  Providers report dated model names
+// such as gpt-3.5-turbo-0125 or claude-3-5-haiku-20241022, so the longest
+// listed name the model starts with is used when there is no exact match.
+func PriceFor(model string) (ModelPrice, bool) {
+	pricingMu.RLock()
+	defer pricingMu.RUnlock()
+
+	if price, ok := pricing[model]; ok {
+		return price, true
+	}
+
+	var best string
+	for name := range pricing {
+		if strings.HasPrefix(model, name) && len(name) > len(best) {
+			best = name
+		}
+	}
+	if best == "" {
+		return ModelPrice{}, false
+	}
+	return pricing[best], true
+}
+
+// Cost returns the dollar cost of a call.  Unknown models, such as local
+// ones, cost nothing.
+func Cost(model string, usage Usage) float64 {
+	price, ok := PriceFor(model)
+	if !ok {
+		return 0
+	}
+	return (float64(usage.PromptTokens)*price.Prompt + float64(usage.CompletionTokens)*price.Completion) / 1_000_000
+}
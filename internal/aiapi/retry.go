@@ -0,0 +1,138 @@
+package aiapi
+
+import (
+	"context"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy controls how failed provider calls are retried.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	// CallTimeout bounds the whole call, including every retry and wait.
+	CallTimeout time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    20 * time.Second,
+	CallTimeout: 90 * time.Second,
+}
+
+// newChatHTTPClient returns the client chat completions are sent with.  It
+// gives up on a provider that takes longer than headerTimeout to start
+// answering.  Reading the answer is only bounded by the call's CallTimeout,
+// a client Timeout would also cut off a long narrative mid stream.
+func newChatHTTPClient(headerTimeout time.Duration) *http.Client {
+	if transport := CassetteTransport(); transport != nil {
+		return &http.Client{Transport: transport}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = headerTimeout
+	return &http.Client{Transport: transport}
+}
+
+// backoff returns the wait before the given retry attempt using full jitter,
+// or the provider's Retry-After when it asked for one.
+func (p RetryPolicy) backoff(attempt int, apiErr *APIError) time.Duration {
+	if apiErr.RetryAfter > 0 {
+		return apiErr.RetryAfter
+	}
+
+	ceiling := p.BaseDelay << attempt
+	if ceiling <= 0 || ceiling > p.MaxDelay {
+		ceiling = p.MaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// RetryNotifier is told about each retry before its wait begins.
+type RetryNotifier func(attempt int, err *APIError, wait time.Duration)
+
+type retryNotifierKey struct{}
+
+// WithRetryNotifier returns a context that reports retries of calls made
+// with it to notify.
+func WithRetryNotifier(ctx context.Context, notify RetryNotifier) context.Context {
+	return context.WithValue(ctx, retryNotifierKey{}, notify)
+}
+
+// doWithRetry sends the request built by newRequest, retrying retryable
+// failures until the policy's attempts or deadline run out.  A successful
+// response is returned unread and the caller must close its body.
+func doWithRetry(ctx context.Context, client *http.Client, provider string, policy RetryPolicy, newRequest func(ctx context.Context) (*http.Request, error)) (*http.Response, error) {
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	if policy.CallTimeout <= 0 {
+		policy.CallTimeout = DefaultRetryPolicy.CallTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, policy.CallTimeout)
+
+	var lastErr *APIError
+	for attempt := 0; attempt < policy.MaxAttempts; attempt++ {
+		if attempt > 0 {
+			wait := policy.backoff(attempt-1, lastErr)
+			deadline, _ := ctx.Deadline()
+			if time.Until(deadline) < wait {
+				log.Printf("Giving up on %s after %d attempts, retry wait of %s exceeds the call deadline", provider, attempt, wait)
+				break
+			}
+
+			log.Printf("Retrying %s in %s after %s (attempt %d of %d)", provider, wait, lastErr.Kind, attempt+1, policy.MaxAttempts)
+			if notify, ok := ctx.Value(retryNotifierKey{}).(RetryNotifier); ok {
+				notify(attempt+1, lastErr, wait)
+			}
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				cancel()
+				return nil, classifyTransportError(provider, ctx.Err())
+			}
+		}
+
+		req, err := newRequest(ctx)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			lastErr = classifyTransportError(provider, err)
+		} else if resp.StatusCode != http.StatusOK {
+			lastErr = classifyResponse(provider, resp)
+		} else {
+			// the deadline keeps covering the body until the caller closes it
+			resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+			return resp, nil
+		}
+
+		log.Printf("%s request failed: %v", provider, lastErr)
+		if !lastErr.Retryable() {
+			break
+		}
+	}
+
+	cancel()
+	return nil, lastErr
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,113 @@
+package aiapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func testRetryPolicy() RetryPolicy {
+	return RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond, CallTimeout: 5 * time.Second}
+}
+
+func TestDoRequestRetriesRateLimit(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.Header().Set("retry-after-ms", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"error": {"message": "Rate limit reached"}}`))
+			return
+		}
+		w.Write([]byte(`{"choices": [{"message": {"role": "assistant", "content": "ok"}}]}`))
+	}))
+	defer server.Close()
+
+	client := New("test-model", 0.7, ResponseFormat{Type: "text"})
+	client.BaseURL = server.URL
+	client.RetryPolicy = testRetryPolicy()
+
+	response, err := client.DoRequest(context.Background(), []AiMessage{{Provider: "user", Message: "look around"}})
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+
+	if attempts != 2 || response.GetChatCompletion() != "ok" {
+		t.Errorf("Expected success on the second attempt, but got %d attempts", attempts)
+	}
+}
+
+func TestDoRequestDoesNotRetryAuthErrors(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error": {"message": "Incorrect API key provided"}}`))
+	}))
+	defer server.Close()
+
+	client := New("test-model", 0.7, ResponseFormat{Type: "text"})
+	client.BaseURL = server.URL
+	client.RetryPolicy = testRetryPolicy()
+
+	_, err := client.DoRequest(context.Background(), []AiMessage{{Provider: "user", Message: "look around"}})
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Expected an APIError, but got %v", err)
+	}
+
+	if apiErr.Kind != ErrorAuth || apiErr.Message != "Incorrect API key provided" {
+		t.Errorf("Expected an auth error with the provider message, but got %v", apiErr)
+	}
+
+	if attempts != 1 {
+		t.Errorf("Expected a single attempt, but got %d", attempts)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	header := http.Header{}
+	header.Set("Retry-After", "3")
+	if wait := parseRetryAfter(header); wait != 3*time.Second {
+		t.Errorf("Expected a 3s wait, but got %s", wait)
+	}
+
+	header.Set("Retry-After", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	if wait := parseRetryAfter(header); wait <= 0 || wait > time.Minute {
+		t.Errorf("Expected a wait of up to a minute, but got %s", wait)
+	}
+}
+
+func TestDoRequestStopsWhenContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		cancel()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := New("test-model", 0.7, ResponseFormat{Type: "text"})
+	client.BaseURL = server.URL
+	client.RetryPolicy = testRetryPolicy()
+	client.RetryPolicy.BaseDelay = time.Second
+	client.RetryPolicy.MaxDelay = time.Second
+
+	_, err := client.DoRequest(ctx, []AiMessage{{Provider: "user", Message: "look around"}})
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Kind != ErrorCanceled {
+		t.Errorf("Expected a canceled error, but got %v", err)
+	}
+
+	if attempts != 1 {
+		t.Errorf("Expected no retries after cancellation, but got %d attempts", attempts)
+	}
+}
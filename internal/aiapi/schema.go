@@ -0,0 +1,207 @@
+package aiapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// JSONSchema is a named JSON schema describing the response expected from a
+// structured output call.
+type JSONSchema struct {
+	Name   string
+	Schema map[string]interface{}
+}
+
+// SchemaAIClient is implemented by clients that can constrain a completion
+// to a JSON schema.
+type SchemaAIClient interface {
+	AIClient
+	DoSchemaRequest(ctx context.Context, messages []AiMessage, schema *JSONSchema) (ChatResponse, error)
+}
+
+// SchemaToolError is returned by a schema request the model answered
+// without calling the tool that records the response.  The response is
+// returned along with it, holding whatever text the model wrote instead,
+// so the caller can ask for a repair.
+type SchemaToolError struct {
+	Tool string
+}
+
+func (e *SchemaToolError) Error() string {
+	return fmt.Sprintf("response did not call the %s tool", e.Tool)
+}
+
+// SchemaFor generates a strict JSON schema from the json tags of a struct.
+// Every field is required and no additional properties are allowed, as
+// OpenAI's strict structured outputs demand.
+func SchemaFor(name string, v interface{}) *JSONSchema {
+	return &JSONSchema{
+		Name:   name,
+		Schema: schemaForType(reflect.TypeOf(v)),
+	}
+}
+
+func schemaForType(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaForType(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaForType(t.Elem())}
+	case reflect.Struct:
+		properties := map[string]interface{}{}
+		required := []string{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+
+			properties[name] = schemaForType(field.Type)
+			required = append(required, name)
+		}
+
+		return map[string]interface{}{
+			"type":                 "object",
+			"properties":           properties,
+			"required":             required,
+			"additionalProperties": false,
+		}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// Validate checks that data is a JSON document matching the schema.
+func (s *JSONSchema) Validate(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("invalid json: %w", err)
+	}
+	return validateValue("$", s.Schema, value)
+}
+
+func validateValue(path string, schema map[string]interface{}, value interface{}) error {
+	switch schema["type"] {
+	case "string":
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("%s: expected a string, got %s", path, jsonTypeName(value))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: expected a boolean, got %s", path, jsonTypeName(value))
+		}
+	case "integer":
+		number, ok := value.(float64)
+		if !ok || number != float64(int64(number)) {
+			return fmt.Errorf("%s: expected an integer, got %s", path, jsonTypeName(value))
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return fmt.Errorf("%s: expected a number, got %s", path, jsonTypeName(value))
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected an array, got %s", path, jsonTypeName(value))
+		}
+		itemSchema, _ := schema["items"].(map[string]interface{})
+		for i, item := range items {
+			if err := validateValue(fmt.Sprintf("%s[%d]", path, i), itemSchema, item); err != nil {
+				return err
+			}
+		}
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected an object, got %s", path, jsonTypeName(value))
+		}
+		return validateObject(path, schema, object)
+	}
+
+	return nil
+}
+
+func validateObject(path string, schema map[string]interface{}, object map[string]interface{}) error {
+	properties, _ := schema["properties"].(map[string]interface{})
+
+	required, _ := schema["required"].([]string)
+	for _, name := range required {
+		if _, ok := object[name]; !ok {
+			return fmt.Errorf("%s: missing required property %q", path, name)
+		}
+	}
+
+	// check properties in a stable order so repeated failures read the same
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		propertySchema, ok := properties[name].(map[string]interface{})
+		if !ok {
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					return fmt.Errorf("%s: unexpected property %q", path, name)
+				}
+				continue
+			case map[string]interface{}:
+				propertySchema = additional
+			default:
+				continue
+			}
+		}
+
+		if err := validateValue(path+"."+name, propertySchema, object[name]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "a string"
+	case bool:
+		return "a boolean"
+	case float64:
+		return "a number"
+	case []interface{}:
+		return "an array"
+	case map[string]interface{}:
+		return "an object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
@@ -0,0 +1,56 @@
+package aiapi
+
+import (
+	"strings"
+	"testing"
+)
+
+type testSchemaResponse struct {
+	Location string   `json:"location"`
+	Items    []string `json:"items"`
+	Turns    int      `json:"turns"`
+	internal string
+}
+
+func TestSchemaFor(t *testing.T) {
+	schema := SchemaFor("test_response", testSchemaResponse{})
+
+	if schema.Schema["type"] != "object" || schema.Schema["additionalProperties"] != false {
+		t.Fatalf("Expected a closed object schema, but got %v", schema.Schema)
+	}
+
+	required := schema.Schema["required"].([]string)
+	if strings.Join(required, ",") != "location,items,turns" {
+		t.Errorf("Expected every exported field to be required, but got %v", required)
+	}
+
+	items := schema.Schema["properties"].(map[string]interface{})["items"].(map[string]interface{})
+	if items["type"] != "array" || items["items"].(map[string]interface{})["type"] != "string" {
+		t.Errorf("Expected items to be an array of strings, but got %v", items)
+	}
+}
+
+func TestSchemaValidate(t *testing.T) {
+	schema := SchemaFor("test_response", testSchemaResponse{})
+
+	valid := `{"location": "River", "items": ["rope"], "turns": 3}`
+	if err := schema.Validate([]byte(valid)); err != nil {
+		t.Errorf("Expected a valid document, but got %v", err)
+	}
+
+	invalid := map[string]string{
+		`{"location": "River", "items": ["rope"]}`:                         `missing required property "turns"`,
+		`{"location": "River", "items": "rope", "turns": 3}`:               `$.items: expected an array`,
+		`{"location": "River", "items": [1], "turns": 3}`:                  `$.items[0]: expected a string`,
+		`{"location": "River", "items": [], "turns": 1.5}`:                 `$.turns: expected an integer`,
+		`{"location": "River", "items": [], "turns": 1, "mood": "grumpy"}`: `unexpected property "mood"`,
+		`{"location": "River", "items": [], "turns": 1`:                    `invalid json`,
+	}
+
+	for document, expected := range invalid {
+		err := schema.Validate([]byte(document))
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error containing %q for %s, but got %v", expected, document, err)
+		}
+	}
+}
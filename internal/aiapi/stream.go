@@ -0,0 +1,134 @@
+package aiapi
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// StreamingAIClient is implemented by clients that can deliver a completion
+// incrementally.  onChunk is called with each piece of text as it arrives and
+// the returned response holds the full completion.
+type StreamingAIClient interface {
+	AIClient
+	DoStreamRequest(ctx context.Context, messages []AiMessage, onChunk func(string)) (ChatResponse, error)
+}
+
+// StreamChoice is a single choice in a streamed chat completion chunk.
+type StreamChoice struct {
+	Index        int           `json:"index"`
+	Delta        OpenAiMessage `json:"delta"`
+	FinishReason *string       `json:"finish_reason"`
+}
+
+// OpenAIStreamChunk is one server sent event of a streamed chat completion.
+type OpenAIStreamChunk struct {
+	ID      string         `json:"id"`
+	Model   string         `json:"model"`
+	Choices []StreamChoice `json:"choices"`
+	Usage   *Usage         `json:"usage"`
+}
+
+func (c *OpenAIClient) DoStreamRequest(ctx context.Context, userMessages []AiMessage, onChunk func(string)) (ChatResponse, error) {
+	chatRequest, err := c.buildRequest(ctx, userMessages)
+	if err != nil {
+		return &AiChatResponse{}, err
+	}
+
+	response, _, err := c.stream(ctx, chatRequest, onChunk)
+	return response, err
+}
+
+// stream sends the chat request as a stream, passing text to onChunk as it
+// arrives.  Tool calls come in fragments and are returned once complete.
+func (c *OpenAIClient) stream(ctx context.Context, chatRequest ChatRequest, onChunk func(string)) (ChatResponse, []ToolCall, error) {
+	chatRequest.Stream = true
+	chatRequest.StreamOptions = &StreamOptions{IncludeUsage: true}
+
+	resp, err := c.post(ctx, chatRequest)
+	if err != nil {
+		return &AiChatResponse{}, nil, err
+	}
+	defer resp.Body.Close()
+
+	var completion strings.Builder
+	var usage Usage
+	var toolCalls []ToolCall
+	model := chatRequest.Model
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data:") {
+			// blank separators, comments and event names carry no content
+			continue
+		}
+
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk OpenAIStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return &AiChatResponse{}, nil, fmt.Errorf("error decoding stream chunk: %w", err)
+		}
+
+		if chunk.Usage != nil {
+			usage = *chunk.Usage
+		}
+		if chunk.Model != "" {
+			model = chunk.Model
+		}
+
+		for _, choice := range chunk.Choices {
+			toolCalls = addToolCallFragments(toolCalls, choice.Delta.ToolCalls)
+			if choice.Delta.Content == "" {
+				continue
+			}
+			completion.WriteString(choice.Delta.Content)
+			onChunk(choice.Delta.Content)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		if ctx.Err() != nil {
+			return &AiChatResponse{}, nil, classifyTransportError(c.ClientName, ctx.Err())
+		}
+		return &AiChatResponse{}, nil, fmt.Errorf("error reading stream: %w", err)
+	}
+
+	return &AiChatResponse{
+		Completion: completion.String(),
+		TokensUsed: usage.TotalTokens,
+		Usage:      usage,
+		Model:      model,
+	}, toolCalls, nil
+}
+
+// addToolCallFragments adds streamed tool call fragments to the calls.  The
+// first fragment of a call has its id and name, later ones with the same
+// index carry more of the arguments.
+func addToolCallFragments(calls []ToolCall, fragments []OpenAIToolCall) []ToolCall {
+	for _, fragment := range fragments {
+		index := len(calls)
+		if fragment.Index != nil && *fragment.Index >= 0 && *fragment.Index <= len(calls) {
+			index = *fragment.Index
+		}
+		for len(calls) <= index {
+			calls = append(calls, ToolCall{})
+		}
+
+		if fragment.ID != "" {
+			calls[index].ID = fragment.ID
+		}
+		if fragment.Function.Name != "" {
+			calls[index].Name = fragment.Function.Name
+		}
+		calls[index].Arguments += fragment.Function.Arguments
+	}
+	return calls
+}
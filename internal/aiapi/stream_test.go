@@ -0,0 +1,113 @@
+package aiapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOpenAIDoStreamRequest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var received ChatRequest
+		json.NewDecoder(r.Body).Decode(&received)
+		if !received.Stream {
+			t.Errorf("Expected stream to be requested")
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"choices\": [{\"delta\": {\"role\": \"assistant\"}}]}\n\n"))
+		w.Write([]byte("data: {\"choices\": [{\"delta\": {\"content\": \"You see \"}}]}\n\n"))
+		w.Write([]byte(": keep-alive\n\n"))
+		w.Write([]byte("data: {\"choices\": [{\"delta\": {\"content\": \"a river.\"}}]}\n\n"))
+		w.Write([]byte("data: {\"choices\": [], \"usage\": {\"total_tokens\": 42}}\n\n"))
+		w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	defer server.Close()
+
+	client := New("test-model", 0.7, ResponseFormat{Type: "text"})
+	client.BaseURL = server.URL
+
+	var chunks []string
+	response, err := client.DoStreamRequest(context.Background(), []AiMessage{{Provider: "user", Message: "look around"}}, func(chunk string) {
+		chunks = append(chunks, chunk)
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+
+	if strings.Join(chunks, "|") != "You see |a river." {
+		t.Errorf("Expected two content chunks, but got %q", chunks)
+	}
+
+	if response.GetChatCompletion() != "You see a river." {
+		t.Errorf("Expected the full completion, but got %s", response.GetChatCompletion())
+	}
+
+	if response.GetTokenUsage() != 42 {
+		t.Errorf("Expected 42 tokens used, but got %d", response.GetTokenUsage())
+	}
+}
+
+func TestStreamOutlastsTheHeaderTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		for _, chunk := range []string{"The tide ", "comes in ", "slowly."} {
+			w.Write([]byte("data: {\"choices\": [{\"delta\": {\"content\": \"" + chunk + "\"}}]}\n\n"))
+			w.(http.Flusher).Flush()
+			time.Sleep(100 * time.Millisecond)
+		}
+		w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	defer server.Close()
+
+	t.Setenv("AI_CASSETTE_MODE", "")
+	client := New("test-model", 0.7, ResponseFormat{Type: "text"})
+	client.BaseURL = server.URL
+	client.Client = newChatHTTPClient(50 * time.Millisecond)
+
+	response, err := client.DoStreamRequest(context.Background(), []AiMessage{{Provider: "user", Message: "wait"}}, func(string) {})
+	if err != nil {
+		t.Fatalf("Expected the stream to finish, but got %v", err)
+	}
+	if response.GetChatCompletion() != "The tide comes in slowly." {
+		t.Errorf("Expected the full completion, but got %s", response.GetChatCompletion())
+	}
+}
+
+func TestOpenAIStreamedToolCalls(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var received ChatRequest
+		json.NewDecoder(r.Body).Decode(&received)
+		if len(received.Tools) != 1 || received.Tools[0].Function.Name != "roll_dice" {
+			t.Errorf("Expected the roll_dice tool to be offered, but got %+v", received.Tools)
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"choices\": [{\"delta\": {\"content\": \"You roll.\"}}]}\n\n"))
+		w.Write([]byte("data: {\"choices\": [{\"delta\": {\"tool_calls\": [{\"index\": 0, \"id\": \"call_1\", \"function\": {\"name\": \"roll_dice\", \"arguments\": \"{\\\"count\\\":\"}}]}}]}\n\n"))
+		w.Write([]byte("data: {\"choices\": [{\"delta\": {\"tool_calls\": [{\"index\": 0, \"function\": {\"arguments\": \"2}\"}}]}}]}\n\n"))
+		w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	defer server.Close()
+
+	client := New("test-model", 0.7, ResponseFormat{Type: "text"})
+	client.BaseURL = server.URL
+
+	tools := []Tool{{Name: "roll_dice", Parameters: map[string]interface{}{"type": "object"}}}
+	response, calls, err := client.DoToolRequest(context.Background(), []AiMessage{{Provider: "user", Message: "attack"}}, tools, func(string) {})
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+
+	if response.GetChatCompletion() != "You roll." {
+		t.Errorf("Expected the text alongside the call, but got %s", response.GetChatCompletion())
+	}
+	if len(calls) != 1 || calls[0].ID != "call_1" || calls[0].Name != "roll_dice" || calls[0].Arguments != `{"count":2}` {
+		t.Errorf("Expected the fragments to make one roll_dice call, but got %+v", calls)
+	}
+}
@@ -1,13 +1,21 @@
 package aiapi
 
+import "context"
+
 type ChatResponse interface {
 	GetChatCompletion() string
 	GetTokenUsage() int
+	GetUsage() Usage
+	GetModel() string
 }
 
 type AiMessage struct {
 	Provider string `json:"provider"`
 	Message  string `json:"message"`
+	// ToolCalls are the tools an assistant message asked to call, and
+	// ToolCallID the call a "tool" message reports the result of.
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
 }
 
 func (m *AiMessage) NewMessage(provider string, message string) *AiMessage {
@@ -15,5 +23,31 @@ func (m *AiMessage) NewMessage(provider string, message string) *AiMessage {
 }
 
 type AIClient interface {
-	DoRequest(messages []AiMessage) (*ChatResponse, error)
+	DoRequest(ctx context.Context, messages []AiMessage) (ChatResponse, error)
+}
+
+// AiChatResponse is the provider independent result of a chat completion.
+type AiChatResponse struct {
+	Completion string `json:"completion"`
+	TokensUsed int    `json:"tokens_used"`
+	Usage      Usage  `json:"usage"`
+	Model      string `json:"model"`
+}
+
+func (resp *AiChatResponse) GetChatCompletion() string {
+	return resp.Completion
+}
+
+func (resp *AiChatResponse) GetTokenUsage() int {
+	return resp.TokensUsed
+}
+
+func (resp *AiChatResponse) GetUsage() Usage {
+	return resp.Usage
+}
+
+// GetModel returns the model that produced the completion, as reported by
+// the provider.
+func (resp *AiChatResponse) GetModel() string {
+	return resp.Model
 }
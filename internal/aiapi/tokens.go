@@ -0,0 +1,144 @@
+package aiapi
+
+import (
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+func init() {
+	// use the encodings built into the binary rather than downloading them
+	tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
+}
+
+// Tokenizer counts the tokens a model sees for some text.
+type Tokenizer interface {
+	CountTokens(text string) int
+}
+
+// BPETokenizer counts tokens exactly with the byte pair encoding of an
+// OpenAI model.
+type BPETokenizer struct {
+	encoding *tiktoken.Tiktoken
+}
+
+func (t *BPETokenizer) CountTokens(text string) int {
+	// special tokens in player text are sent to the model as plain text
+	return len(t.encoding.EncodeOrdinary(text))
+}
+
+// EstimatingTokenizer approximates byte pair tokenizers for the models
+// without a known encoding, such as Anthropic's and local ones.  Words up
+// to six letters are one token, longer words are split about every four
+// characters, punctuation is a token of its own and characters outside
+// ASCII are counted one each.  It tends to slightly overcount, which is the
+// safe side when packing a context window.
+type EstimatingTokenizer struct{}
+
+func (EstimatingTokenizer) CountTokens(text string) int {
+	tokens := 0
+	wordLength := 0
+	endWord := func() {
+		switch {
+		case wordLength == 0:
+		case wordLength <= 6:
+			tokens++
+		default:
+			tokens += (wordLength + 3) / 4
+		}
+		wordLength = 0
+	}
+
+	for _, r := range text {
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			wordLength++
+		case unicode.IsSpace(r):
+			// leading spaces are merged into the next word's token
+			endWord()
+		default:
+			endWord()
+			tokens++
+		}
+	}
+	endWord()
+
+	return tokens
+}
+
+// tokensPerMessage is the overhead of the role and separators around each
+// chat message, and tokensPerReply primes the assistant's answer.
+const (
+	tokensPerMessage = 4
+	tokensPerReply   = 3
+)
+
+var (
+	tokenizers   = map[string]Tokenizer{}
+	tokenizersMu sync.Mutex
+)
+
+// TokenizerFor returns the tokenizer for a model, the exact encoding for
+// OpenAI models and the estimate for the others.  Encodings take a moment
+// to build, so each model's is built once.
+func TokenizerFor(model string) Tokenizer {
+	tokenizersMu.Lock()
+	defer tokenizersMu.Unlock()
+
+	if tokenizer, ok := tokenizers[model]; ok {
+		return tokenizer
+	}
+
+	var tokenizer Tokenizer = EstimatingTokenizer{}
+	if encoding, err := tiktoken.EncodingForModel(model); err == nil {
+		tokenizer = &BPETokenizer{encoding: encoding}
+	}
+	tokenizers[model] = tokenizer
+	return tokenizer
+}
+
+// CountMessageTokens counts the prompt tokens of a conversation, including
+// the per message overhead.
+func CountMessageTokens(tokenizer Tokenizer, messages []AiMessage) int {
+	tokens := tokensPerReply
+	for _, message := range messages {
+		tokens += MessageTokens(tokenizer, message)
+	}
+	return tokens
+}
+
+// MessageTokens counts the tokens of a single chat message.
+func MessageTokens(tokenizer Tokenizer, message AiMessage) int {
+	return tokensPerMessage + tokenizer.CountTokens(message.Message)
+}
+
+// contextWindows lists the context window of the models the game uses.
+var contextWindows = map[string]int{
+	"gpt-3.5-turbo":      16385,
+	"gpt-4-0125-preview": 128000,
+	"gpt-4-turbo":        128000,
+	"gpt-4o":             128000,
+	"gpt-4":              8192,
+	"claude-3":           200000,
+}
+
+// defaultContextWindow is assumed for unknown models, such as local ones.
+const defaultContextWindow = 8192
+
+// ContextWindowFor returns the context window of a model in tokens, using
+// the longest listed name the model starts with.
+func ContextWindowFor(model string) int {
+	var best string
+	for name := range contextWindows {
+		if strings.HasPrefix(model, name) && len(name) > len(best) {
+			best = name
+		}
+	}
+	if best == "" {
+		return defaultContextWindow
+	}
+	return contextWindows[best]
+}
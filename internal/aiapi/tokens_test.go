@@ -0,0 +1,48 @@
+package aiapi
+
+import "testing"
+
+func TestEstimatingTokenizer(t *testing.T) {
+	tokenizer := EstimatingTokenizer{}
+
+	if tokens := tokenizer.CountTokens("Hello, world!"); tokens != 4 {
+		t.Errorf("Expected 4 tokens, but got %d", tokens)
+	}
+
+	// long words are split into several tokens
+	if tokens := tokenizer.CountTokens("unbelievably"); tokens != 3 {
+		t.Errorf("Expected 3 tokens, but got %d", tokens)
+	}
+}
+
+func TestContextBudgetCapsToWindow(t *testing.T) {
+	err := ApplyModelConfig(&ModelConfig{Roles: map[string]RoleConfig{
+		"budget-test": {Provider: ProviderOpenAI, Model: "gpt-3.5-turbo-0125", ContextBudget: 100000},
+	}})
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+
+	budget, model := ContextBudget("budget-test")
+	if model != "gpt-3.5-turbo-0125" || budget != 16385-defaultReplyTokens {
+		t.Errorf("Expected the budget to be capped to the model window, but got %d for %s", budget, model)
+	}
+}
+
+func TestTokenizerFor(t *testing.T) {
+	tokenizer, ok := TokenizerFor("gpt-4o-mini").(*BPETokenizer)
+	if !ok {
+		t.Fatalf("Expected the exact tokenizer for gpt-4o-mini")
+	}
+	if tokens := tokenizer.CountTokens("Hello, world!"); tokens != 4 {
+		t.Errorf("Expected 4 tokens, but got %d", tokens)
+	}
+	// special tokens in the text are counted as plain text
+	if tokens := tokenizer.CountTokens("<|endoftext|>"); tokens < 2 {
+		t.Errorf("Expected the special token to be split, but got %d tokens", tokens)
+	}
+
+	if _, ok := TokenizerFor("claude-3-haiku-20240307").(EstimatingTokenizer); !ok {
+		t.Errorf("Expected the estimate for models without a known encoding")
+	}
+}
@@ -0,0 +1,215 @@
+package aiapi
+
+import "context"
+
+// Tool is a function the model can ask to call.  Parameters is the JSON
+// schema of the arguments, usually made with SchemaFor so it is strict.
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  map[string]interface{}
+}
+
+// ToolCall is a model's request to call a tool.  Arguments is the JSON
+// object of arguments as the model wrote it.
+type ToolCall struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// ToolAIClient is implemented by clients that can offer the model tools.
+// The tool calls the model made are returned along with the response, whose
+// completion holds any text written alongside them.  The caller runs the
+// tools and sends the results back as "tool" messages, one per call, after
+// an assistant message carrying the calls.  When onChunk is not nil the text
+// is passed to it as it arrives, or in one piece by clients that can't
+// stream.
+type ToolAIClient interface {
+	AIClient
+	DoToolRequest(ctx context.Context, messages []AiMessage, tools []Tool, onChunk func(string)) (ChatResponse, []ToolCall, error)
+}
+
+type noToolCallsKey struct{}
+
+// WithoutToolCalls returns a context that makes DoToolRequest calls made
+// with it offer the tools but forbid calling them, so the model has to
+// answer in text.  The tools are still sent, a conversation holding earlier
+// tool calls needs them.
+func WithoutToolCalls(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noToolCallsKey{}, true)
+}
+
+func toolCallsForbidden(ctx context.Context) bool {
+	forbidden, _ := ctx.Value(noToolCallsKey{}).(bool)
+	return forbidden
+}
+
+// ToolResultMessage is the message reporting the result of a tool call.
+func ToolResultMessage(call ToolCall, result string) AiMessage {
+	return AiMessage{Provider: "tool", Message: result, ToolCallID: call.ID}
+}
+
+// OpenAITool is a function tool in a chat request.
+type OpenAITool struct {
+	Type     string             `json:"type"`
+	Function OpenAIToolFunction `json:"function"`
+}
+
+type OpenAIToolFunction struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Parameters  map[string]interface{} `json:"parameters"`
+	Strict      bool                   `json:"strict,omitempty"`
+}
+
+// OpenAIToolCall is a tool call in an assistant message.  Index is only
+// set in streamed chunks, where it says which call a fragment belongs to.
+type OpenAIToolCall struct {
+	Index    *int                   `json:"index,omitempty"`
+	ID       string                 `json:"id,omitempty"`
+	Type     string                 `json:"type,omitempty"`
+	Function OpenAIToolCallFunction `json:"function"`
+}
+
+type OpenAIToolCallFunction struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
+}
+
+func (c *OpenAIClient) DoToolRequest(ctx context.Context, userMessages []AiMessage, tools []Tool, onChunk func(string)) (ChatResponse, []ToolCall, error) {
+	chatRequest, err := c.buildRequest(ctx, userMessages)
+	if err != nil {
+		return &AiChatResponse{}, nil, err
+	}
+	for _, tool := range tools {
+		chatRequest.Tools = append(chatRequest.Tools, OpenAITool{
+			Type: "function",
+			Function: OpenAIToolFunction{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+				Strict:      true,
+			},
+		})
+	}
+	if toolCallsForbidden(ctx) {
+		chatRequest.ToolChoice = "none"
+	}
+
+	if onChunk != nil {
+		return c.stream(ctx, chatRequest, onChunk)
+	}
+
+	response, message, err := c.completeMessage(ctx, chatRequest)
+	if err != nil {
+		return response, nil, err
+	}
+	return response, fromOpenAIToolCalls(message.ToolCalls), nil
+}
+
+func toOpenAIToolCalls(calls []ToolCall) []OpenAIToolCall {
+	var openAiCalls []OpenAIToolCall
+	for _, call := range calls {
+		openAiCalls = append(openAiCalls, OpenAIToolCall{
+			ID:       call.ID,
+			Type:     "function",
+			Function: OpenAIToolCallFunction{Name: call.Name, Arguments: call.Arguments},
+		})
+	}
+	return openAiCalls
+}
+
+func fromOpenAIToolCalls(openAiCalls []OpenAIToolCall) []ToolCall {
+	var calls []ToolCall
+	for _, call := range openAiCalls {
+		calls = append(calls, ToolCall{ID: call.ID, Name: call.Function.Name, Arguments: call.Function.Arguments})
+	}
+	return calls
+}
+
+// DoToolRequest offers the tools to Claude.  The Messages API isn't
+// streamed, so any text is passed to onChunk once the response is complete.
+func (c *AnthropicClient) DoToolRequest(ctx context.Context, userMessages []AiMessage, tools []Tool, onChunk func(string)) (ChatResponse, []ToolCall, error) {
+	anthropicRequest := c.buildRequest(userMessages, false)
+	for _, tool := range tools {
+		anthropicRequest.Tools = append(anthropicRequest.Tools, AnthropicTool{
+			Name:        tool.Name,
+			Description: tool.Description,
+			InputSchema: tool.Parameters,
+		})
+	}
+	if toolCallsForbidden(ctx) {
+		anthropicRequest.ToolChoice = &AnthropicToolChoice{Type: "none"}
+	}
+
+	anthropicResponse, err := c.send(ctx, anthropicRequest)
+	if err != nil {
+		return &AiChatResponse{}, nil, err
+	}
+
+	var calls []ToolCall
+	for _, block := range anthropicResponse.Content {
+		if block.Type == "tool_use" {
+			calls = append(calls, ToolCall{ID: block.ID, Name: block.Name, Arguments: string(block.Input)})
+		}
+	}
+
+	completion := anthropicResponse.GetChatCompletion()
+	if onChunk != nil && completion != "" {
+		onChunk(completion)
+	}
+	return anthropicResponse.toChatResponse(completion), calls, nil
+}
+
+// DoToolRequest fails over like DoStreamRequest, and only to providers that
+// support tools.
+func (c *FallbackClient) DoToolRequest(ctx context.Context, messages []AiMessage, tools []Tool, onChunk func(string)) (ChatResponse, []ToolCall, error) {
+	started := false
+	var calls []ToolCall
+	response, err := c.try(ctx, func(client AIClient) (ChatResponse, error) {
+		toolClient, ok := client.(ToolAIClient)
+		if !ok {
+			return nil, &APIError{Provider: c.Role, Kind: ErrorBadRequest, Message: "provider doesn't support tools"}
+		}
+
+		var chunked func(string)
+		if onChunk != nil {
+			chunked = func(chunk string) {
+				started = true
+				onChunk(chunk)
+			}
+		}
+		response, toolCalls, err := toolClient.DoToolRequest(ctx, messages, tools, chunked)
+		calls = toolCalls
+		return response, err
+	}, func() bool { return !started })
+	return response, calls, err
+}
+
+// DoToolRequest is never cached, running the tools changes the game.
+func (c *CachingClient) DoToolRequest(ctx context.Context, messages []AiMessage, tools []Tool, onChunk func(string)) (ChatResponse, []ToolCall, error) {
+	toolClient, ok := c.Client.(ToolAIClient)
+	if !ok {
+		return nil, nil, &APIError{Provider: c.Role, Kind: ErrorBadRequest, Message: "client doesn't support tools"}
+	}
+	return toolClient.DoToolRequest(ctx, messages, tools, onChunk)
+}
+
+// SupportsTools reports whether a client, and every client it wraps, can
+// offer the model tools.
+func SupportsTools(client AIClient) bool {
+	switch c := client.(type) {
+	case *CachingClient:
+		return SupportsTools(c.Client)
+	case *FallbackClient:
+		for _, entry := range c.Entries {
+			if !SupportsTools(entry.Client) {
+				return false
+			}
+		}
+		return len(c.Entries) > 0
+	}
+	_, ok := client.(ToolAIClient)
+	return ok
+}
@@ -40,10 +40,38 @@ func SaveSession(ctx context.Context, email string) (string, error) {
 }
 
 func DeleteSession(ctx context.Context, sessionId string) error {
+	if err := redis.DeleteKey(ctx, &redis.UserSessionSlotKey{SessionID: sessionId}); err != nil {
+		return err
+	}
 	key := &redis.UserSessionKey{SessionID: sessionId}
 	return redis.DeleteKey(ctx, key)
 }
 
+// GetSessionSlot returns the save slot the session is playing, empty if it
+// hasn't picked one.
+func GetSessionSlot(r *http.Request) string {
+	cookie, err := r.Cookie("SESSION_ID")
+	if err != nil {
+		return ""
+	}
+
+	slot, err := redis.GetValue(r.Context(), &redis.UserSessionSlotKey{SessionID: cookie.Value})
+	if err != nil {
+		return ""
+	}
+	return slot
+}
+
+// SetSessionSlot switches the session to a save slot.
+func SetSessionSlot(r *http.Request, slot string) error {
+	cookie, err := r.Cookie("SESSION_ID")
+	if err != nil {
+		return fmt.Errorf("session cookie not found")
+	}
+
+	return redis.SetValue(r.Context(), &redis.UserSessionSlotKey{SessionID: cookie.Value}, slot, 24*60)
+}
+
 func BuildSessionCookie(sessionId string) *http.Cookie {
 	return &http.Cookie{
 		Name:    "SESSION_ID",
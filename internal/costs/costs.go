@@ -0,0 +1,230 @@
+package costs
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sessionsdev/blue-octopus/internal/redis"
+)
+
+// how long call records and daily aggregates are kept
+const retentionMinutes = 90 * 24 * 60
+
+// the most call records kept per day
+const maxCallsPerDay = 10000
+
+// CallRecord describes a single AI call.
+type CallRecord struct {
+	Time             time.Time `json:"time"`
+	User             string    `json:"user"`
+	Role             string    `json:"role"`
+	Model            string    `json:"model"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	Cost             float64   `json:"cost"`
+	LatencyMs        int64     `json:"latency_ms"`
+	// Prompt is the id of the prompt version the call was made with.
+	Prompt string `json:"prompt,omitempty"`
+	// Variant is the experiment and variant the call was made for.
+	Variant string `json:"variant,omitempty"`
+}
+
+// Totals aggregates a set of calls.
+type Totals struct {
+	Calls            int64   `json:"calls"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	LatencyMs        int64   `json:"latency_ms"`
+	Cost             float64 `json:"cost"`
+}
+
+// AverageLatencyMs returns the mean latency of the calls.
+func (t Totals) AverageLatencyMs() int64 {
+	if t.Calls == 0 {
+		return 0
+	}
+	return t.LatencyMs / t.Calls
+}
+
+// NamedTotals are the totals for one user, role, model or day.
+type NamedTotals struct {
+	Name string `json:"name"`
+	Totals
+}
+
+// Report aggregates calls over a range of days.
+type Report struct {
+	From    string        `json:"from"`
+	To      string        `json:"to"`
+	Total   Totals        `json:"total"`
+	Days    []NamedTotals `json:"days"`
+	Users   []NamedTotals `json:"users"`
+	Roles   []NamedTotals `json:"roles"`
+	Models  []NamedTotals `json:"models"`
+	Prompts []NamedTotals `json:"prompts"`
+}
+
+// Record stores a call and adds it to the day's aggregates.
+func Record(ctx context.Context, record CallRecord) error {
+	date := record.Time.UTC().Format("2006-01-02")
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	err = redis.PushValue(ctx, &redis.CostCallsKey{Date: date}, string(data), maxCallsPerDay, retentionMinutes)
+	if err != nil {
+		return err
+	}
+
+	ints := map[string]int64{}
+	floats := map[string]float64{}
+	dimensions := []string{"total", "user:" + record.User, "role:" + record.Role, "model:" + record.Model}
+	if record.Prompt != "" {
+		dimensions = append(dimensions, "prompt:"+record.Prompt)
+	}
+	for _, dimension := range dimensions {
+		// model names may contain colons, so fields are split on a bar
+		ints[dimension+"|calls"] = 1
+		ints[dimension+"|prompt_tokens"] = int64(record.PromptTokens)
+		ints[dimension+"|completion_tokens"] = int64(record.CompletionTokens)
+		ints[dimension+"|latency_ms"] = record.LatencyMs
+		floats[dimension+"|cost"] = record.Cost
+	}
+
+	return redis.IncrHashFields(ctx, &redis.CostDailyKey{Date: date}, ints, floats, retentionMinutes)
+}
+
+// RecentCalls returns up to limit of today's most recent calls, newest first.
+func RecentCalls(ctx context.Context, limit int64) ([]CallRecord, error) {
+	date := time.Now().UTC().Format("2006-01-02")
+
+	values, err := redis.GetValues(ctx, &redis.CostCallsKey{Date: date}, -limit, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]CallRecord, 0, len(values))
+	for i := len(values) - 1; i >= 0; i-- {
+		var record CallRecord
+		if err := json.Unmarshal([]byte(values[i]), &record); err != nil {
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// BuildReport aggregates the calls of the last number of days, including today.
+func BuildReport(ctx context.Context, days int) (*Report, error) {
+	if days < 1 {
+		days = 1
+	}
+
+	now := time.Now().UTC()
+	report := &Report{
+		From: now.AddDate(0, 0, -(days - 1)).Format("2006-01-02"),
+		To:   now.Format("2006-01-02"),
+	}
+
+	users := map[string]*Totals{}
+	roles := map[string]*Totals{}
+	models := map[string]*Totals{}
+	prompts := map[string]*Totals{}
+
+	for i := days - 1; i >= 0; i-- {
+		date := now.AddDate(0, 0, -i).Format("2006-01-02")
+
+		fields, err := redis.GetHash(ctx, &redis.CostDailyKey{Date: date})
+		if err != nil {
+			return nil, err
+		}
+
+		day := map[string]*Totals{}
+		for field, value := range fields {
+			dimension, metric, ok := strings.Cut(field, "|")
+			if !ok {
+				continue
+			}
+			addMetric(totalsFor(day, dimension), metric, value)
+		}
+
+		dayTotals := totalsFor(day, "total")
+		report.Days = append(report.Days, NamedTotals{Name: date, Totals: *dayTotals})
+		report.Total.add(*dayTotals)
+
+		for dimension, totals := range day {
+			kind, name, _ := strings.Cut(dimension, ":")
+			switch kind {
+			case "user":
+				totalsFor(users, name).add(*totals)
+			case "role":
+				totalsFor(roles, name).add(*totals)
+			case "model":
+				totalsFor(models, name).add(*totals)
+			case "prompt":
+				totalsFor(prompts, name).add(*totals)
+			}
+		}
+	}
+
+	report.Users = sortedByCost(users)
+	report.Roles = sortedByCost(roles)
+	report.Models = sortedByCost(models)
+	report.Prompts = sortedByCost(prompts)
+	return report, nil
+}
+
+func (t *Totals) add(other Totals) {
+	t.Calls += other.Calls
+	t.PromptTokens += other.PromptTokens
+	t.CompletionTokens += other.CompletionTokens
+	t.LatencyMs += other.LatencyMs
+	t.Cost += other.Cost
+}
+
+func totalsFor(totals map[string]*Totals, name string) *Totals {
+	if _, ok := totals[name]; !ok {
+		totals[name] = &Totals{}
+	}
+	return totals[name]
+}
+
+func addMetric(totals *Totals, metric string, value string) {
+	if metric == "cost" {
+		cost, _ := strconv.ParseFloat(value, 64)
+		totals.Cost += cost
+		return
+	}
+
+	count, _ := strconv.ParseInt(value, 10, 64)
+	switch metric {
+	case "calls":
+		totals.Calls += count
+	case "prompt_tokens":
+		totals.PromptTokens += count
+	case "completion_tokens":
+		totals.CompletionTokens += count
+	case "latency_ms":
+		totals.LatencyMs += count
+	}
+}
+
+func sortedByCost(totals map[string]*Totals) []NamedTotals {
+	named := make([]NamedTotals, 0, len(totals))
+	for name, t := range totals {
+		named = append(named, NamedTotals{Name: name, Totals: *t})
+	}
+	sort.Slice(named, func(i, j int) bool {
+		if named[i].Cost != named[j].Cost {
+			return named[i].Cost > named[j].Cost
+		}
+		return named[i].Name < named[j].Name
+	})
+	return named
+}
@@ -0,0 +1,398 @@
+package experiments
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sessionsdev/blue-octopus/internal/aiapi"
+	"github.com/sessionsdev/blue-octopus/internal/prompts"
+	"github.com/sessionsdev/blue-octopus/internal/redis"
+)
+
+const defaultConfigPath = "config/experiments.json"
+
+// Units an experiment can assign variants to.
+const (
+	UnitUser = "user"
+	UnitGame = "game"
+)
+
+// Variant is one arm of an experiment.  A variant without a prompt version
+// or model plays the role as it is configured, which makes it the control.
+type Variant struct {
+	Name          string            `json:"name"`
+	Weight        int               `json:"weight"`
+	PromptVersion string            `json:"prompt_version,omitempty"`
+	Model         *aiapi.RoleConfig `json:"model,omitempty"`
+
+	client aiapi.AIClient
+}
+
+// Experiment splits the turns of a role between variants.
+type Experiment struct {
+	Name     string    `json:"name"`
+	Role     string    `json:"role"`
+	Unit     string    `json:"unit"`
+	Variants []Variant `json:"variants"`
+}
+
+func (e *Experiment) totalWeight() int {
+	total := 0
+	for _, variant := range e.Variants {
+		total += variant.Weight
+	}
+	return total
+}
+
+type Config struct {
+	Experiments []Experiment `json:"experiments"`
+}
+
+// ConfigPath returns the experiments file location, set with
+// AI_EXPERIMENTS.
+func ConfigPath() string {
+	path := os.Getenv("AI_EXPERIMENTS")
+	if path == "" {
+		path = defaultConfigPath
+	}
+	return path
+}
+
+// Load reads and validates an experiments file and builds the clients of
+// the variants that change the model.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("error parsing experiments %s: %w", path, err)
+	}
+
+	names := map[string]bool{}
+	roles := map[string]string{}
+	for i := range config.Experiments {
+		experiment := &config.Experiments[i]
+		if experiment.Name == "" || strings.ContainsAny(experiment.Name, "/|") {
+			return nil, fmt.Errorf("experiment %d: names can't be empty or contain / or |", i)
+		}
+		if names[experiment.Name] {
+			return nil, fmt.Errorf("experiment %s is defined twice", experiment.Name)
+		}
+		names[experiment.Name] = true
+
+		if other, ok := roles[experiment.Role]; ok {
+			return nil, fmt.Errorf("experiments %s and %s both test role %s", other, experiment.Name, experiment.Role)
+		}
+		roles[experiment.Role] = experiment.Name
+
+		if experiment.Unit == "" {
+			experiment.Unit = UnitUser
+		}
+		if experiment.Unit != UnitUser && experiment.Unit != UnitGame {
+			return nil, fmt.Errorf("experiment %s: unknown unit %s", experiment.Name, experiment.Unit)
+		}
+
+		if err := experiment.buildVariants(); err != nil {
+			return nil, fmt.Errorf("experiment %s: %w", experiment.Name, err)
+		}
+	}
+	return &config, nil
+}
+
+func (e *Experiment) buildVariants() error {
+	if len(e.Variants) == 0 {
+		return fmt.Errorf("no variants")
+	}
+
+	variants := map[string]bool{}
+	for i := range e.Variants {
+		variant := &e.Variants[i]
+		if variant.Name == "" || strings.ContainsAny(variant.Name, "/|") {
+			return fmt.Errorf("variant %d: names can't be empty or contain / or |", i)
+		}
+		if variants[variant.Name] {
+			return fmt.Errorf("variant %s is defined twice", variant.Name)
+		}
+		variants[variant.Name] = true
+
+		if variant.PromptVersion != "" && !prompts.Has(variant.PromptVersion) {
+			return fmt.Errorf("variant %s: prompt version %s is not loaded", variant.Name, variant.PromptVersion)
+		}
+
+		if variant.Weight < 0 {
+			return fmt.Errorf("variant %s: weight can't be negative", variant.Name)
+		}
+
+		if variant.Model != nil {
+			client, err := aiapi.NewRoleClient(e.Role, *variant.Model)
+			if err != nil {
+				return fmt.Errorf("variant %s: %w", variant.Name, err)
+			}
+			variant.client = client
+		}
+	}
+
+	if e.totalWeight() == 0 {
+		return fmt.Errorf("every variant has a weight of zero")
+	}
+	return nil
+}
+
+var (
+	current   = &Config{}
+	currentMu sync.RWMutex
+)
+
+// Configure loads the experiments file.  Without one no experiments run.
+func Configure() error {
+	path := ConfigPath()
+
+	config, err := Load(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		log.Printf("No experiments at %s", path)
+		config = &Config{}
+	} else if err != nil {
+		return err
+	}
+
+	Set(config)
+	if len(config.Experiments) > 0 {
+		log.Printf("Loaded %d experiments from %s", len(config.Experiments), path)
+	}
+	return nil
+}
+
+// Set replaces the running experiments.
+func Set(config *Config) {
+	currentMu.Lock()
+	defer currentMu.Unlock()
+
+	current = config
+}
+
+// Experiments returns the running experiments.
+func Experiments() []Experiment {
+	currentMu.RLock()
+	defer currentMu.RUnlock()
+
+	return append([]Experiment(nil), current.Experiments...)
+}
+
+// Assignment is the variant of an experiment a turn is played with.
+type Assignment struct {
+	Experiment    string
+	Role          string
+	Variant       string
+	PromptVersion string
+	// Client replaces the role's client, nil to keep it.
+	Client aiapi.AIClient `json:"-"`
+}
+
+// Label names the experiment and variant, as recorded with each call.
+func (a Assignment) Label() string {
+	return a.Experiment + "/" + a.Variant
+}
+
+// Assign picks a variant of every running experiment for a user's game,
+// keyed by the role the experiment tests.  The same user, or the same game
+// for experiments assigned per game, always gets the same variant while the
+// experiment is unchanged.  Games without an id are assigned by user.
+func Assign(user string, gameID string) map[string]Assignment {
+	assignments := map[string]Assignment{}
+	for _, experiment := range Experiments() {
+		unit := user
+		if experiment.Unit == UnitGame && gameID != "" {
+			unit = gameID
+		}
+
+		variant := experiment.pick(unit)
+		assignments[experiment.Role] = Assignment{
+			Experiment:    experiment.Name,
+			Role:          experiment.Role,
+			Variant:       variant.Name,
+			PromptVersion: variant.PromptVersion,
+			Client:        variant.client,
+		}
+	}
+	return assignments
+}
+
+// pick hashes the unit into the variants' weights.
+func (e *Experiment) pick(unit string) Variant {
+	sum := sha256.Sum256([]byte(e.Name + ":" + unit))
+	bucket := int(binary.BigEndian.Uint64(sum[:8]) % uint64(e.totalWeight()))
+
+	for _, variant := range e.Variants {
+		if bucket < variant.Weight {
+			return variant
+		}
+		bucket -= variant.Weight
+	}
+	return e.Variants[len(e.Variants)-1]
+}
+
+// Outcomes recorded for each variant.
+const (
+	MetricTurns         = "turns"
+	MetricParseFailures = "parse_failures"
+	MetricSchemaRepairs = "schema_repairs"
+	MetricFeedbackUp    = "feedback_up"
+	MetricFeedbackDown  = "feedback_down"
+)
+
+// RecordCall adds an AI call made under an assignment to its variant.
+func RecordCall(ctx context.Context, a Assignment, tokens int, cost float64, latencyMs int64) error {
+	ints := map[string]int64{
+		a.Variant + "|calls":      1,
+		a.Variant + "|tokens":     int64(tokens),
+		a.Variant + "|latency_ms": latencyMs,
+	}
+	floats := map[string]float64{a.Variant + "|cost": cost}
+	return redis.IncrHashFields(ctx, &redis.ExperimentKey{Name: a.Experiment}, ints, floats, 0)
+}
+
+// RecordOutcome counts an outcome, one of the Metric constants, against
+// the variant of an assignment.
+func RecordOutcome(ctx context.Context, a Assignment, metric string) error {
+	return redis.IncrHashFields(ctx, &redis.ExperimentKey{Name: a.Experiment}, map[string]int64{a.Variant + "|" + metric: 1}, nil, 0)
+}
+
+// VariantReport is the outcomes of a variant so far.
+type VariantReport struct {
+	Name          string
+	Weight        int
+	Turns         int64
+	Calls         int64
+	Tokens        int64
+	LatencyMs     int64
+	Cost          float64
+	ParseFailures int64
+	SchemaRepairs int64
+	FeedbackUp    int64
+	FeedbackDown  int64
+}
+
+func (v VariantReport) TokensPerTurn() int64 {
+	if v.Turns == 0 {
+		return 0
+	}
+	return v.Tokens / v.Turns
+}
+
+func (v VariantReport) CostPerTurn() float64 {
+	if v.Turns == 0 {
+		return 0
+	}
+	return v.Cost / float64(v.Turns)
+}
+
+func (v VariantReport) AverageLatencyMs() int64 {
+	if v.Calls == 0 {
+		return 0
+	}
+	return v.LatencyMs / v.Calls
+}
+
+// ParseFailureRate is the percentage of turns whose JSON couldn't be used.
+func (v VariantReport) ParseFailureRate() float64 {
+	if v.Turns == 0 {
+		return 0
+	}
+	return float64(v.ParseFailures) / float64(v.Turns) * 100
+}
+
+// Approval is the percentage of feedback that was positive.
+func (v VariantReport) Approval() float64 {
+	votes := v.FeedbackUp + v.FeedbackDown
+	if votes == 0 {
+		return 0
+	}
+	return float64(v.FeedbackUp) / float64(votes) * 100
+}
+
+// Report compares the variants of an experiment.
+type Report struct {
+	Name     string
+	Role     string
+	Unit     string
+	Variants []VariantReport
+}
+
+// BuildReports reports on every running experiment.  Variants that have
+// been removed from the config but have outcomes are listed with no weight.
+func BuildReports(ctx context.Context) ([]Report, error) {
+	reports := []Report{}
+	for _, experiment := range Experiments() {
+		fields, err := redis.GetHash(ctx, &redis.ExperimentKey{Name: experiment.Name})
+		if err != nil {
+			return nil, err
+		}
+
+		variants := map[string]*VariantReport{}
+		for _, variant := range experiment.Variants {
+			variants[variant.Name] = &VariantReport{Name: variant.Name, Weight: variant.Weight}
+		}
+		for field, value := range fields {
+			name, metric, ok := strings.Cut(field, "|")
+			if !ok {
+				continue
+			}
+			if _, ok := variants[name]; !ok {
+				variants[name] = &VariantReport{Name: name}
+			}
+			addMetric(variants[name], metric, value)
+		}
+
+		report := Report{Name: experiment.Name, Role: experiment.Role, Unit: experiment.Unit}
+		for _, variant := range variants {
+			report.Variants = append(report.Variants, *variant)
+		}
+		sort.Slice(report.Variants, func(i, j int) bool {
+			return report.Variants[i].Name < report.Variants[j].Name
+		})
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+func addMetric(variant *VariantReport, metric string, value string) {
+	if metric == "cost" {
+		cost, _ := strconv.ParseFloat(value, 64)
+		variant.Cost += cost
+		return
+	}
+
+	count, _ := strconv.ParseInt(value, 10, 64)
+	switch metric {
+	case "calls":
+		variant.Calls += count
+	case "tokens":
+		variant.Tokens += count
+	case "latency_ms":
+		variant.LatencyMs += count
+	case MetricTurns:
+		variant.Turns += count
+	case MetricParseFailures:
+		variant.ParseFailures += count
+	case MetricSchemaRepairs:
+		variant.SchemaRepairs += count
+	case MetricFeedbackUp:
+		variant.FeedbackUp += count
+	case MetricFeedbackDown:
+		variant.FeedbackDown += count
+	}
+}
@@ -0,0 +1,55 @@
+package experiments
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, config string) string {
+	path := filepath.Join(t.TempDir(), "experiments.json")
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestAssignIsStableAndWeighted(t *testing.T) {
+	config, err := Load(writeConfig(t, `{"experiments": [
+		{"name": "narrator-test", "role": "narrator", "variants": [
+			{"name": "control", "weight": 3},
+			{"name": "treatment", "weight": 1}
+		]}
+	]}`))
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	Set(config)
+	defer Set(&Config{})
+
+	counts := map[string]int{}
+	for i := 0; i < 2000; i++ {
+		user := fmt.Sprintf("player%d@example.com", i)
+		variant := Assign(user, "")["narrator"].Variant
+		if again := Assign(user, "")["narrator"].Variant; again != variant {
+			t.Fatalf("Expected %s to keep variant %s, but got %s", user, variant, again)
+		}
+		counts[variant]++
+	}
+
+	if counts["treatment"] < 400 || counts["treatment"] > 600 {
+		t.Errorf("Expected about a quarter of users in treatment, but got %d of 2000", counts["treatment"])
+	}
+}
+
+func TestLoadRejectsTwoExperimentsOnOneRole(t *testing.T) {
+	_, err := Load(writeConfig(t, `{"experiments": [
+		{"name": "first", "role": "narrator", "variants": [{"name": "a", "weight": 1}]},
+		{"name": "second", "role": "narrator", "variants": [{"name": "b", "weight": 1}]}
+	]}`))
+	if err == nil || !strings.Contains(err.Error(), "both test role narrator") {
+		t.Errorf("Expected an error about the shared role, but got %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package game
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/sessionsdev/blue-octopus/internal/aiapi"
+	"github.com/sessionsdev/blue-octopus/internal/costs"
+	"github.com/sessionsdev/blue-octopus/internal/experiments"
+)
+
+type playerContextKey struct{}
+
+// withPlayer records the player a turn is played for, so the calls made
+// during it can be billed to them.
+func withPlayer(ctx context.Context, username string) context.Context {
+	return context.WithValue(ctx, playerContextKey{}, username)
+}
+
+func playerFromContext(ctx context.Context) string {
+	username, _ := ctx.Value(playerContextKey{}).(string)
+	return username
+}
+
+// recordCall stores the cost of an AI call made for a role.  It is recorded
+// even if the turn is later cancelled, since the tokens were still spent.
+func recordCall(ctx context.Context, role string, start time.Time, response aiapi.ChatResponse) {
+	usage := response.GetUsage()
+	record := costs.CallRecord{
+		Time:             start,
+		User:             playerFromContext(ctx),
+		Role:             role,
+		Model:            response.GetModel(),
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		Cost:             aiapi.Cost(response.GetModel(), usage),
+		LatencyMs:        time.Since(start).Milliseconds(),
+	}
+	if set, err := promptsFor(ctx, role); err == nil {
+		record.Prompt = set.ID
+	}
+
+	variant, experimenting := variantFor(ctx, role)
+	if experimenting {
+		record.Variant = variant.Label()
+	}
+
+	if err := costs.Record(context.WithoutCancel(ctx), record); err != nil {
+		log.Println("Error recording ai call cost: ", err)
+	}
+
+	if experimenting {
+		err := experiments.RecordCall(context.WithoutCancel(ctx), variant, usage.PromptTokens+usage.CompletionTokens, record.Cost, record.LatencyMs)
+		if err != nil {
+			log.Println("Error recording experiment call: ", err)
+		}
+	}
+}
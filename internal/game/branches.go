@@ -0,0 +1,155 @@
+package game
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/sessionsdev/blue-octopus/internal/memory"
+)
+
+// BranchSlot forks a save slot at one of its turns into a new slot, leaving
+// the original as it is.  The branch keeps the snapshots and memories up to
+// that turn, so it can be rewound and branched again.
+func BranchSlot(ctx context.Context, email string, slot string, turn int) (SlotInfo, error) {
+	// the limit is checked under the lock so another new slot can't slip in
+	if err := lockUser(ctx, email); err != nil {
+		return SlotInfo{}, err
+	}
+	defer releaseUser(email)
+
+	if err := checkSlotLimit(ctx, email); err != nil {
+		return SlotInfo{}, err
+	}
+
+	parent, err := getSlotInfo(ctx, email, normalizeSlot(slot))
+	if err != nil {
+		return SlotInfo{}, err
+	}
+
+	// a turn resolving in the slot could add the snapshot being copied
+	if err := lockTurn(ctx, email, slot); err != nil {
+		return SlotInfo{}, err
+	}
+	defer releaseTurn(email, slot)
+
+	current, err := LoadGameFromRedis(ctx, email, slot)
+	if err != nil {
+		return SlotInfo{}, ErrSlotNotFound
+	}
+
+	snapshots, err := ListSnapshots(ctx, email, slot)
+	if err != nil {
+		return SlotInfo{}, err
+	}
+	index := findSnapshot(snapshots, turn)
+	if index < 0 {
+		return SlotInfo{}, fmt.Errorf("turn %d is too far back to branch from", turn)
+	}
+
+	g := snapshots[index].Game
+	g.slot = newID()
+	g.ID = newID()
+	g.ContentRating = current.ContentRating
+	g.PromptVersion = current.PromptVersion
+	g.recordBaseline(SourceEngine, EventGameForked, parent.ID)
+	if err := g.save(ctx, email, branchName(parent.Name, turn)); err != nil {
+		return SlotInfo{}, err
+	}
+
+	info, err := getSlotInfo(ctx, email, g.slot)
+	if err != nil {
+		return SlotInfo{}, err
+	}
+	info.Parent = parent.ID
+	info.ParentTurn = turn
+	if err := setSlotInfo(ctx, email, info); err != nil {
+		return SlotInfo{}, err
+	}
+
+	copySnapshots(ctx, email, slot, g.slot, index)
+	if err := memory.Copy(ctx, memoryOwner(email, slot), memoryOwner(email, g.slot)); err != nil {
+		log.Println("Error copying memories: ", err)
+	} else if err := memory.ForgetAfter(ctx, memoryOwner(email, g.slot), turn); err != nil {
+		log.Println("Error forgetting memories: ", err)
+	}
+	return info, nil
+}
+
+// branchName names a branch after its parent and the turn it was forked at.
+func branchName(parent string, turn int) string {
+	suffix := []rune(fmt.Sprintf(", turn %d", turn))
+	name := []rune(parent)
+	if len(name)+len(suffix) > maxSlotNameLength {
+		name = name[:maxSlotNameLength-len(suffix)]
+	}
+	return string(name) + string(suffix)
+}
+
+// copySnapshots copies a slot's snapshots up to and including index to
+// another slot.
+func copySnapshots(ctx context.Context, email string, from string, to string, index int) {
+	store := currentSnapshotStore()
+	values, err := store.All(ctx, email, from)
+	if err != nil {
+		log.Println("Error copying snapshots: ", err)
+		return
+	}
+	for _, value := range values[:min(index+1, len(values))] {
+		if err := store.Push(ctx, email, to, value); err != nil {
+			log.Println("Error copying snapshots: ", err)
+			return
+		}
+	}
+}
+
+// SlotNode is a save slot and the branches forked from it.
+type SlotNode struct {
+	SlotInfo
+	// Playing is set on the slot the session is playing, Open on it and the
+	// slots it was branched from so the picker shows it.
+	Playing  bool
+	Open     bool
+	Branches []*SlotNode
+}
+
+// slotTree arranges save slots by the slot they were branched from.  Saves
+// that aren't branches, and branches whose parent was deleted, are roots.
+// Siblings are kept in the order of slots.
+func slotTree(slots []SlotInfo, playing string) []*SlotNode {
+	nodes := map[string]*SlotNode{}
+	for _, slot := range slots {
+		nodes[slot.ID] = &SlotNode{SlotInfo: slot, Playing: slot.ID == playing}
+	}
+
+	roots := []*SlotNode{}
+	for _, slot := range slots {
+		node := nodes[slot.ID]
+		if parent, ok := nodes[slot.Parent]; ok && slot.Parent != slot.ID {
+			parent.Branches = append(parent.Branches, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+
+	for _, root := range roots {
+		root.open()
+	}
+
+	// the main campaign is always listed first
+	sort.SliceStable(roots, func(i, j int) bool {
+		return roots[i].ID == MainSlot && roots[j].ID != MainSlot
+	})
+	return roots
+}
+
+func (n *SlotNode) open() bool {
+	n.Open = n.Playing
+	for _, branch := range n.Branches {
+		if branch.open() {
+			n.Open = true
+		}
+	}
+	return n.Open
+}
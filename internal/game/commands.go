@@ -3,187 +3,579 @@ package game
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/sessionsdev/blue-octopus/internal/aiapi"
+	"github.com/sessionsdev/blue-octopus/internal/experiments"
+	"github.com/sessionsdev/blue-octopus/internal/memory"
+	"github.com/sessionsdev/blue-octopus/internal/prompts"
+	"github.com/sessionsdev/blue-octopus/internal/quota"
 )
 
-var gameCommandProcessing bool
+// turnInProgressMessage answers commands sent while the previous turn is
+// still resolving.
+const turnInProgressMessage = "Your previous turn is still resolving. Give the Game Master a moment and try again."
 
 func ProcessGameCommand(ctx context.Context, command string, username string) (string, error) {
+	return ProcessGameCommandStream(ctx, command, username, nil)
+}
+
+// ProcessGameCommandStream processes a command like ProcessGameCommand, passing
+// the narrative to onChunk as it is generated when onChunk is not nil.
+// Commands that change the game hold the lock on the save they play while
+// they run.
+func ProcessGameCommandStream(ctx context.Context, command string, username string, onChunk func(string)) (string, error) {
+	ctx = withPlayer(ctx, username)
+	slot := slotFromContext(ctx)
+
 	switch command {
+	case "CANCEL TURN":
+		if CancelTurn(username, slot) {
+			return "The Game Master sets down their pen. Your last turn has been cancelled.", nil
+		}
+		return "There is no turn in progress to cancel.", nil
 	case "RESET GAME":
-		g := InitializeNewGame()
+		if err := lockTurn(ctx, username, slot); err != nil {
+			return turnInProgressMessage, nil
+		}
+		defer releaseTurn(username, slot)
+
+		// only the save slot being played is reset
+		g := newGameFor(ctx, username, slot)
 		SaveGameToRedis(ctx, g, username)
+		clearSnapshots(ctx, username, g.slot)
+		if err := memory.Forget(ctx, memoryOwner(username, g.slot)); err != nil {
+			log.Println("Error forgetting memories: ", err)
+		}
 		return fmt.Sprintf("RESET GAME: New game created!"), nil
+	case "UNDO":
+		if err := lockTurn(ctx, username, slot); err != nil {
+			return turnInProgressMessage, nil
+		}
+		defer releaseTurn(username, slot)
+
+		g, err := undo(ctx, username, slot)
+		if errors.Is(err, errNothingToUndo) {
+			return "There is no turn to undo.", nil
+		} else if err != nil {
+			log.Println("Error undoing turn: ", err)
+			return "The last turn can't be undone: " + err.Error(), nil
+		}
+		return fmt.Sprintf("UNDO: The last turn has been undone, you are back at turn %d.", g.currentTurn()), nil
 	default:
-		g, err := LoadGameFromRedis(ctx, username)
-		if err != nil {
-			log.Println("Error loading game from redis: ", err)
-			return `No game found. Try using the "RESET GAME" command`, nil
+		// the game is loaded under the lock, so it can't be read while the
+		// previous turn is still saving
+		if err := lockTurn(ctx, username, slot); err != nil {
+			return turnInProgressMessage, nil
 		}
 
-		narrativeResponse, err := g.processPlayerPrompt(ctx, command, username)
+		g, message, ok := prepareTurn(ctx, command, username)
+		if !ok {
+			releaseTurn(username, slot)
+			return message, nil
+		}
+
+		narrativeResponse, err := g.processPlayerPromptStream(ctx, command, username, onChunk)
 		if err != nil {
-			return fmt.Sprintf("An error occured processing the command: %s", command), err
+			return playerErrorMessage(command, err), err
 		}
 
 		return narrativeResponse, nil
 	}
 }
 
+// prepareTurn loads the player's game and checks the command can be played.
+// If it can't, the message to show the player instead is returned.
+func prepareTurn(ctx context.Context, command string, username string) (*Game, string, bool) {
+	g, err := LoadGameFromRedis(ctx, username, slotFromContext(ctx))
+	if err != nil {
+		log.Println("Error loading game from redis: ", err)
+		return nil, `No game found. Try using the "RESET GAME" command`, false
+	}
+
+	if message, ok := checkQuota(ctx, username); !ok {
+		return nil, message, false
+	}
+
+	if message, ok := g.moderate(ctx, username, "command", command); !ok {
+		return nil, message, false
+	}
+
+	if message, ok := rejectInjection(ctx, g, username, command); !ok {
+		return nil, message, false
+	}
+
+	g.ensureSnapshot(ctx, username)
+	g.ensureEventLog(ctx, username)
+	return g, "", true
+}
+
+// checkQuota reports whether the user has tokens left to play a turn, and
+// if not the message to show them instead.
+func checkQuota(ctx context.Context, username string) (string, bool) {
+	err := quota.Check(ctx, username)
+	if err == nil {
+		return "", true
+	}
+
+	var exceeded *quota.ExceededError
+	if !errors.As(err, &exceeded) {
+		// don't lock players out because the usage counters are unavailable
+		log.Println("Error checking token quota: ", err)
+		return "", true
+	}
+
+	log.Printf("Token quota exceeded for %s: %v", username, exceeded)
+	if exceeded.Period == "monthly" {
+		return "You are utterly out of energy. Your adventurer needs a long rest, come back next month.", false
+	}
+	return "You are out of energy for today. Rest by the fire and come back tomorrow.", false
+}
+
+// recordTokens adds a turn's token usage to the user's quota.
+func recordTokens(ctx context.Context, username string, tokens int) {
+	if err := quota.Record(ctx, username, tokens); err != nil {
+		log.Println("Error recording token usage: ", err)
+	}
+}
+
+// playerErrorMessage explains a failed turn to the player.
+func playerErrorMessage(command string, err error) string {
+	if errors.Is(err, prompts.ErrVersionNotLoaded) {
+		return "This game is pinned to prompts that are no longer available. Please let the administrator know."
+	}
+
+	var apiErr *aiapi.APIError
+	if !errors.As(err, &apiErr) {
+		return fmt.Sprintf("An error occured processing the command: %s", command)
+	}
+
+	switch {
+	case apiErr.Kind == aiapi.ErrorCanceled:
+		return fmt.Sprintf("The command was cancelled: %s", command)
+	case apiErr.Retryable():
+		return "The Game Master is busy and didn't answer after several retries. Please try your command again in a moment."
+	case apiErr.Kind == aiapi.ErrorAuth:
+		return "The Game Master can't be reached right now. Please let the administrator know."
+	default:
+		return fmt.Sprintf("The Game Master couldn't make sense of the command: %s", command)
+	}
+}
+
 func (g *Game) processPlayerPrompt(ctx context.Context, command string, username string) (string, error) {
-	if gameCommandProcessing {
-		return "", fmt.Errorf("game command processing is already in progress. Please wait a moment and try again.")
+	return g.processPlayerPromptStream(ctx, command, username, nil)
+}
+
+// processPlayerPromptStream plays a turn, passing narrative text to onChunk as
+// it arrives when onChunk is not nil.  Cancelling ctx, or the turn through
+// CancelTurn, aborts the narrator call.  The turn is released, along with
+// the lock on its save, when it fails or its background work is done.
+func (g *Game) processPlayerPromptStream(ctx context.Context, command string, username string, onChunk func(string)) (string, error) {
+	set, err := g.prompts()
+	if err != nil {
+		releaseTurn(username, g.slot)
+		return "", err
 	}
+	variants := g.variants(username)
+	ctx = withVariants(withPrompts(ctx, set), variants)
 
-	gameCommandProcessing = true
+	narratorCtx, cancel := context.WithCancel(ctx)
+	trackTurn(username, g.slot, cancel)
 
-	messages := []GameMessage{
-		{Provider: "system", Message: GAME_MASTER_RESPONSABILITY_PROMPT},
-		{Provider: "system", Message: BuildGameMasterStatePrompt(g)},
+	// the narrator changes the game through tools when its client can
+	var tools *turnTools
+	if narratorToolsEnabled() {
+		if client, err := clientFor(narratorCtx, aiapi.RoleNarrator); err == nil && aiapi.SupportsTools(client) {
+			tools = newTurnTools(g)
+		}
 	}
 
-	history := g.GetRecentHistory(20)
-	messages = append(messages, history...)
-	messages = append(messages, GameMessage{Provider: "user", Message: command})
+	messages, err := g.buildNarratorMessages(narratorCtx, command, g.recallMemories(narratorCtx, username, command), tools != nil)
+	if err != nil {
+		releaseTurn(username, g.slot)
+		return "", err
+	}
+
+	// streamed narrative is moderated before the player sees it
+	var stream *moderatedStream
+	if onChunk != nil {
+		stream = newModeratedStream(ctx, g, username, onChunk, cancel)
+		onChunk = stream.write
+	}
 
-	// Call the OpenAI Chat API using the client
-	response, err := callClient("openai", messages)
+	// Call the narrator using the client
+	var response aiapi.ChatResponse
+	if tools != nil {
+		response, err = callClientWithTools(narratorCtx, aiapi.RoleNarrator, messages, tools, onChunk)
+	} else if onChunk != nil {
+		response, err = callClientStream(narratorCtx, aiapi.RoleNarrator, messages, onChunk)
+	} else {
+		response, err = callClient(narratorCtx, aiapi.RoleNarrator, messages)
+	}
 	if err != nil {
-		return "", fmt.Errorf("error calling OpenAI Chat API: %w", err)
+		releaseTurn(username, g.slot)
+		if stream != nil && stream.blocked {
+			return stream.refusal, nil
+		}
+		return "", fmt.Errorf("error calling narrator: %w", err)
 	}
 
+	recordTurnOutcome(ctx, experiments.MetricTurns)
+
 	// update tokens used
-	g.TotalTokensUsed += response.GetTokenUsage()
+	g.record(SourceEngine, Event{Type: EventTokensUsed, Tokens: response.GetTokenUsage()})
+	recordTokens(baseContext, username, response.GetTokenUsage())
 
 	// get the raw response message
 	responseMessage := response.GetChatCompletion()
 
-	userMessage := GameMessage{Provider: "user", Message: command}
-	assistantMessage := GameMessage{Provider: "assistant", Message: responseMessage}
+	// a blocked narrative is dropped and kept out of the history
+	message, ok := "", true
+	if stream != nil {
+		message, ok = stream.finish()
+	} else {
+		message, ok = g.moderate(ctx, username, "narrative", responseMessage)
+	}
+	if !ok {
+		releaseTurn(username, g.slot)
+		return message, nil
+	}
+
+	if tools != nil {
+		tools.validate(ctx, username, command, responseMessage)
+	}
+
+	// votes on the turn are counted against the variants it was played with
+	turn := g.currentTurn() + 1
+	saveTurnVariants(ctx, username, g.slot, turn, variants)
 
-	g.UpdateGameHistory(userMessage, assistantMessage)
+	g.finishTurn(set, variants, command, responseMessage, username, tools)
+	notifyTurn(ctx, turn)
+
+	return responseMessage, nil
+}
+
+func (g *Game) buildNarratorMessages(ctx context.Context, command string, memories []string, tools bool) ([]GameMessage, error) {
+	set, err := promptsFor(ctx, aiapi.RoleNarrator)
+	if err != nil {
+		return nil, err
+	}
+
+	responsibilities, err := set.Render(prompts.GameMaster, GameMasterData{Tools: tools})
+	if err != nil {
+		return nil, err
+	}
+	state, err := BuildGameMasterStatePrompt(set, g, memories)
+	if err != nil {
+		return nil, err
+	}
+
+	system := []GameMessage{
+		{Provider: "system", Message: responsibilities},
+		{Provider: "system", Message: state},
+	}
+
+	return packContext(aiapi.RoleNarrator, system, delimitHistory(g.GetRecentHistory(narratorHistoryMessages)), GameMessage{Provider: "user", Message: wrapCommand(command)}), nil
+}
+
+// finishTurn records the completed narrative in the history and reconciles
+// the game state in the background.  The background work outlives the
+// request, so it runs under the server's base context and can only be
+// stopped by a shutdown or CancelTurn.  Results are applied and saved only
+// if the turn was not cancelled, so a cancelled turn is discarded whole.
+// A turn the narrator played with tools has already changed the game and
+// is only reconciled when RECONCILE_STATE is always.
+func (g *Game) finishTurn(set *prompts.Set, variants map[string]experiments.Assignment, command string, responseMessage string, username string, tools *turnTools) {
+	g.record(SourceNarrator, Event{Type: EventTurnPlayed, Command: command, Narrative: responseMessage})
+
+	ctx, cancel := context.WithCancel(withVariants(withPrompts(withPlayer(baseContext, username), set), variants))
+	trackTurn(username, g.slot, cancel)
+
+	var stateUpdate *GameStateUpdateResponse
+	var storyThreads []string
+	var stateTokens, threadTokens int
+	reconcile := tools == nil || alwaysReconcile()
 
 	// Reconcile the game state
 	done := make(chan bool)
 	go func() {
-		g.ReconcileGameState()
+		if reconcile {
+			stateUpdate, stateTokens = g.ReconcileGameState(ctx)
+		}
 		done <- true
 	}()
 
 	go func() {
-		g.progressStoryThreads()
+		storyThreads, threadTokens = g.progressStoryThreads(ctx)
 		done <- true
 	}()
 
 	go func() {
+		defer releaseTurn(username, g.slot)
+
 		<-done
 		<-done
+
+		// the tokens were spent even if the turn is discarded
+		recordTokens(baseContext, username, stateTokens+threadTokens)
+
+		if ctx.Err() != nil {
+			log.Printf("Turn for %s was cancelled, discarding it: %v", username, ctx.Err())
+			return
+		}
+
+		previousLocation := g.World.CurrentLocation.LocationName
+		if tools != nil && tools.startLocation != nil {
+			previousLocation = tools.startLocation.LocationName
+		}
+		if tokens := stateTokens + threadTokens; tokens > 0 {
+			g.record(SourceEngine, Event{Type: EventTokensUsed, Tokens: tokens})
+		}
+		if stateUpdate != nil {
+			validated := g.validateStateUpdate(ctx, username, command, responseMessage, *stateUpdate)
+			stateUpdate = &validated
+			g.UpdateGameState(validated)
+		}
+		if tools != nil {
+			stateUpdate = tools.merge(stateUpdate)
+		}
+		if storyThreads != nil {
+			g.record(SourceStorySummarizer, Event{Type: EventStoryThreadsUpdated, Names: storyThreads})
+		}
+
 		SaveGameToRedis(ctx, g, username)
-		g.populatePreparedStatsCache()
-		gameCommandProcessing = false
-	}()
+		g.snapshot(ctx, username, command)
 
-	return responseMessage, nil
+		g.rememberTurn(ctx, username, command, responseMessage, previousLocation, stateUpdate)
+	}()
 }
 
-func (g *Game) ReconcileGameState() {
-	messages := []GameMessage{
-		{Provider: "system", Message: STATE_MANAGER_RESPONSE_PROTOCOL_PROMPT},
-		{Provider: "system", Message: BuildStateManagerPrompt(g)},
+// ReconcileGameState asks the state manager how the latest narrative changed
+// the game.  It returns nil if no usable update was produced, along with the
+// tokens used.
+func (g *Game) ReconcileGameState(ctx context.Context) (*GameStateUpdateResponse, int) {
+	messages, err := g.buildStateManagerMessages(ctx)
+	if err != nil {
+		log.Print("Error reconciling game state: ", err)
+		return nil, 0
 	}
 
-	messages = append(messages, g.GetRecentHistory(5)...)
+	// Call the state manager using the client
+	var gameStateResponse GameStateUpdateResponse
+	tokensUsed, err := callClientJSON(ctx, aiapi.RoleStateManager, messages, gameStateUpdateSchema, &gameStateResponse)
+	if err != nil {
+		log.Print("Error reconciling game state: ", err)
+		return nil, tokensUsed
+	}
 
-	reconcileStatePrompt := `Reconcile the game state with the previous messages and respond with a structured JSON object.`
-	messages = append(messages, GameMessage{Provider: "user", Message: reconcileStatePrompt})
+	return &gameStateResponse, tokensUsed
+}
 
-	// Call the OpenAI Chat API using the client
-	response, err := callClient("openai-json", messages)
+func (g *Game) buildStateManagerMessages(ctx context.Context) ([]GameMessage, error) {
+	set, err := promptsFor(ctx, aiapi.RoleStateManager)
 	if err != nil {
-		log.Print("Error calling OpenAI Chat API: ", err)
-		return
+		return nil, err
 	}
 
-	// update tokens used
-	g.TotalTokensUsed += response.GetTokenUsage()
-
-	// marshal the response message
-	responseMessage := response.GetChatCompletion()
-	var gameStateResponse GameStateUpdateResponse
-	err = json.Unmarshal([]byte(responseMessage), &gameStateResponse)
+	protocol, err := set.Render(prompts.StateManager, nil)
+	if err != nil {
+		return nil, err
+	}
+	state, err := BuildStateManagerPrompt(set, g)
+	if err != nil {
+		return nil, err
+	}
+	reconcileStatePrompt, err := set.Render(prompts.StateManagerRequest, nil)
 	if err != nil {
-		log.Print("Error unmarshaling response: ", err)
-		return
+		return nil, err
+	}
+
+	system := []GameMessage{
+		{Provider: "system", Message: protocol},
+		{Provider: "system", Message: state},
 	}
 
-	g.UpdateGameState(gameStateResponse)
+	return packContext(aiapi.RoleStateManager, system, delimitHistory(g.GetRecentHistory(5)), GameMessage{Provider: "user", Message: reconcileStatePrompt}), nil
 }
 
 type StoryThreadsResponse struct {
 	StoryThreads []string `json:"story_threads"`
 }
 
-func (g *Game) progressStoryThreads() {
-	mostRecentAssistantMessage := g.GetRecentHistory(1)[0]
-	userMsg := g.GetRecentHistory(2)[1]
+var (
+	gameStateUpdateSchema = aiapi.SchemaFor("game_state_update", GameStateUpdateResponse{})
+	storyThreadsSchema    = aiapi.SchemaFor("story_threads", StoryThreadsResponse{})
+)
 
-	var userMessage string
+// maxSchemaRepairs is how many times a model is asked to correct a response
+// that does not match its schema before the turn's update is dropped.
+const maxSchemaRepairs = 2
+
+// progressStoryThreads asks the summary manager for the updated story
+// threads.  It returns nil if none were produced, along with the tokens used.
+func (g *Game) progressStoryThreads(ctx context.Context) ([]string, int) {
+	lastTurn := g.GetRecentHistory(2)
+	userMsg := lastTurn[0]
+	mostRecentAssistantMessage := lastTurn[1]
+
+	role := aiapi.RoleStorySummarizer
 	if len(g.StoryThreads) > 10 {
-		userMessage = BuildProgressiveSummaryPrompt(g.StoryThreads)
+		role = aiapi.RoleProgressiveSummarizer
+	}
+
+	set, err := promptsFor(ctx, role)
+	if err != nil {
+		log.Print("Error progressing story threads: ", err)
+		return nil, 0
+	}
+
+	var userMessage string
+	if role == aiapi.RoleProgressiveSummarizer {
+		userMessage, err = BuildProgressiveSummaryPrompt(set, g.StoryThreads)
 	} else {
-		userMessage = BuildGameSummaryCurrentStatePrompt(g.StoryThreads, userMsg.Message, mostRecentAssistantMessage.Message)
+		userMessage, err = BuildGameSummaryCurrentStatePrompt(set, g.StoryThreads, userMsg.Message, mostRecentAssistantMessage.Message)
+	}
+	if err != nil {
+		log.Print("Error progressing story threads: ", err)
+		return nil, 0
+	}
+
+	protocol, err := set.Render(prompts.StorySummarizer, nil)
+	if err != nil {
+		log.Print("Error progressing story threads: ", err)
+		return nil, 0
 	}
 
 	messages := []GameMessage{
-		{Provider: "system", Message: GAME_SUMMARY_MANAGER_PROMPT},
+		{Provider: "system", Message: protocol},
 		{Provider: "user", Message: userMessage},
 	}
 
-	response, err := callClient("openai-json", messages)
+	var storyThreadsResponse StoryThreadsResponse
+	tokensUsed, err := callClientJSON(ctx, role, messages, storyThreadsSchema, &storyThreadsResponse)
 	if err != nil {
-		log.Print("Error calling OpenAI Chat API: ", err)
-		return
+		log.Print("Error progressing story threads: ", err)
+		return nil, tokensUsed
 	}
 
-	g.TotalTokensUsed += response.GetTokenUsage()
+	return storyThreadsResponse.StoryThreads, tokensUsed
+}
 
-	responseMessage := response.GetChatCompletion()
-	var storyThreadsResponse StoryThreadsResponse
-	err = json.Unmarshal([]byte(responseMessage), &storyThreadsResponse)
+func callClient(ctx context.Context, role string, messages []GameMessage) (aiapi.ChatResponse, error) {
+	client, err := clientFor(ctx, role)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	response, err := client.DoRequest(ctx, toAiMessages(messages))
 	if err != nil {
-		log.Print("Error unmarshaling response: ", err)
-		return
+		return nil, err
 	}
+	recordCall(ctx, role, start, response)
 
-	g.StoryThreads = storyThreadsResponse.StoryThreads
+	return response, nil
 }
 
-func callClient(clientName string, messages []GameMessage) (aiapi.ChatResponse, error) {
-	switch clientName {
-	case "openai":
-		aiMessages := []aiapi.AiMessage{}
-		for _, message := range messages {
-			aiMessages = append(aiMessages, aiapi.AiMessage{
-				Provider: message.Provider,
-				Message:  message.Message,
-			})
+// callClientJSON asks for a response matching schema, using structured
+// outputs when the role's client supports them, and decodes it into target.
+// A response that fails validation, or that skipped the tool recording it,
+// is sent back to the model along with the error so it can repair it.  The
+// tokens used by every attempt are returned.
+func callClientJSON(ctx context.Context, role string, messages []GameMessage, schema *aiapi.JSONSchema, target interface{}) (int, error) {
+	client, err := clientFor(ctx, role)
+	if err != nil {
+		return 0, err
+	}
+
+	tokensUsed := 0
+	for attempt := 0; ; attempt++ {
+		var response aiapi.ChatResponse
+		start := time.Now()
+		if schemaClient, ok := client.(aiapi.SchemaAIClient); ok {
+			response, err = schemaClient.DoSchemaRequest(ctx, toAiMessages(messages), schema)
+		} else {
+			response, err = client.DoRequest(ctx, toAiMessages(messages))
 		}
+		var toolErr *aiapi.SchemaToolError
+		if err != nil && !errors.As(err, &toolErr) {
+			return tokensUsed, err
+		}
+		recordCall(ctx, role, start, response)
+		tokensUsed += response.GetTokenUsage()
 
-		return aiapi.OpenAiClient.DoRequest(aiMessages)
-	case "openai-json":
-		aiMessages := []aiapi.AiMessage{}
-		for _, message := range messages {
-			aiMessages = append(aiMessages, aiapi.AiMessage{
-				Provider: message.Provider,
-				Message:  message.Message,
-			})
+		completion := response.GetChatCompletion()
+		if err == nil {
+			err = schema.Validate([]byte(completion))
+		}
+		if err == nil {
+			err = json.Unmarshal([]byte(completion), target)
+		}
+		if err == nil {
+			return tokensUsed, nil
 		}
 
-		return aiapi.OpenAiJsonClient.DoRequest(aiMessages)
-	default:
-		return nil, fmt.Errorf("client not found: %s", clientName)
+		if attempt == maxSchemaRepairs {
+			recordOutcome(ctx, role, experiments.MetricParseFailures)
+			return tokensUsed, fmt.Errorf("response did not match the %s schema after %d repairs: %w", schema.Name, maxSchemaRepairs, err)
+		}
+
+		log.Printf("Response from %s did not match the %s schema, asking for a repair: %v", role, schema.Name, err)
+		recordOutcome(ctx, role, experiments.MetricSchemaRepairs)
+		set, promptErr := promptsFor(ctx, role)
+		if promptErr != nil {
+			return tokensUsed, promptErr
+		}
+		repair, promptErr := set.Render(prompts.SchemaRepair, SchemaRepairData{Error: err.Error()})
+		if promptErr != nil {
+			return tokensUsed, promptErr
+		}
+		// a response that skipped the tool may have no text to send back
+		if completion != "" {
+			messages = append(messages, GameMessage{Provider: "assistant", Message: completion})
+		}
+		messages = append(messages, GameMessage{Provider: "user", Message: repair})
+	}
+}
+
+func toAiMessages(messages []GameMessage) []aiapi.AiMessage {
+	aiMessages := []aiapi.AiMessage{}
+	for _, message := range messages {
+		aiMessages = append(aiMessages, aiapi.AiMessage{
+			Provider: message.Provider,
+			Message:  message.Message,
+		})
 	}
+	return aiMessages
+}
+
+// callClientStream streams the completion when the role's client supports it
+// and otherwise delivers the full completion as a single chunk.
+func callClientStream(ctx context.Context, role string, messages []GameMessage, onChunk func(string)) (aiapi.ChatResponse, error) {
+	client, err := clientFor(ctx, role)
+	if err != nil {
+		return nil, err
+	}
+
+	streamingClient, ok := client.(aiapi.StreamingAIClient)
+	if !ok {
+		response, err := callClient(ctx, role, messages)
+		if err != nil {
+			return nil, err
+		}
+		onChunk(response.GetChatCompletion())
+		return response, nil
+	}
+
+	start := time.Now()
+	response, err := streamingClient.DoStreamRequest(ctx, toAiMessages(messages), onChunk)
+	if err != nil {
+		return nil, err
+	}
+	recordCall(ctx, role, start, response)
+
+	return response, nil
 }
@@ -0,0 +1,50 @@
+package game
+
+import (
+	"log"
+
+	"github.com/sessionsdev/blue-octopus/internal/aiapi"
+)
+
+// packContext builds the messages sent to a role.  The system prompts and
+// the final prompt are always sent, then as much of the history as fits the
+// role's token budget, newest first.  The oldest turns are dropped first,
+// what happened in them is carried forward by the story threads in the state
+// prompts.
+func packContext(role string, system []GameMessage, history []GameMessage, prompt GameMessage) []GameMessage {
+	budget, model := aiapi.ContextBudget(role)
+	tokenizer := aiapi.TokenizerFor(model)
+
+	fixed := append(append([]GameMessage{}, system...), prompt)
+	used := aiapi.CountMessageTokens(tokenizer, toAiMessages(fixed))
+
+	first := len(history)
+	for first > 0 {
+		tokens := messageTokens(tokenizer, history[first-1])
+		if used+tokens > budget {
+			break
+		}
+		used += tokens
+		first--
+	}
+
+	// don't open the history part way through a turn
+	for first < len(history) && history[first].Provider == "assistant" {
+		used -= messageTokens(tokenizer, history[first])
+		first++
+	}
+
+	kept := history[first:]
+	log.Printf("Context for %s (%s): %d of %d budget tokens, %d of %d history messages", role, model, used, budget, len(kept), len(history))
+	if used > budget {
+		log.Printf("Context for %s is over budget even without history", role)
+	}
+
+	messages := append([]GameMessage{}, system...)
+	messages = append(messages, kept...)
+	return append(messages, prompt)
+}
+
+func messageTokens(tokenizer aiapi.Tokenizer, message GameMessage) int {
+	return aiapi.MessageTokens(tokenizer, aiapi.AiMessage{Provider: message.Provider, Message: message.Message})
+}
@@ -0,0 +1,40 @@
+package game
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sessionsdev/blue-octopus/internal/aiapi"
+)
+
+func TestPackContextDropsOldestTurns(t *testing.T) {
+	err := aiapi.ApplyModelConfig(&aiapi.ModelConfig{Roles: map[string]aiapi.RoleConfig{
+		"pack-test": {Provider: aiapi.ProviderOpenAI, Model: "gpt-4o-mini", ContextBudget: 100},
+	}})
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+
+	// each message is about 24 tokens with its overhead
+	turn := strings.Repeat("word ", 20)
+	history := []GameMessage{}
+	for i := 0; i < 4; i++ {
+		history = append(history, GameMessage{Provider: "user", Message: turn}, GameMessage{Provider: "assistant", Message: turn})
+	}
+
+	system := []GameMessage{{Provider: "system", Message: "system"}}
+	prompt := GameMessage{Provider: "user", Message: "look"}
+	messages := packContext("pack-test", system, history, prompt)
+
+	if messages[0] != system[0] || messages[len(messages)-1] != prompt {
+		t.Errorf("Expected the system prompt first and the command last, but got %v", messages)
+	}
+
+	kept := messages[1 : len(messages)-1]
+	if len(kept) != 2 {
+		t.Fatalf("Expected the newest turn to fit the budget, but got %d history messages", len(kept))
+	}
+	if kept[0].Provider != "user" {
+		t.Errorf("Expected the history to open with a user message, but got %s", kept[0].Provider)
+	}
+}
@@ -0,0 +1,353 @@
+package game
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/sessionsdev/blue-octopus/internal/redis"
+)
+
+// Types of the events a game's changes are recorded as.  A game is rebuilt
+// by replaying its events from the latest baseline, an event that carries
+// the whole game.
+const (
+	// baselines
+	EventGameStarted  = "game_started"
+	EventGameRestored = "game_restored"
+	EventGameForked   = "game_forked"
+	EventLogCompacted = "log_compacted"
+
+	EventPlayerMoved         = "player_moved"
+	EventMoveReverted        = "move_reverted"
+	EventPathsFound          = "paths_found"
+	EventObjectsFound        = "objects_found"
+	EventObjectsRemoved      = "objects_removed"
+	EventEnemiesSpawned      = "enemies_spawned"
+	EventEnemiesRemoved      = "enemies_removed"
+	EventItemsAdded          = "items_added"
+	EventItemsRemoved        = "items_removed"
+	EventStoryThreadAdded    = "story_thread_added"
+	EventStoryThreadsUpdated = "story_threads_updated"
+	EventTurnPlayed          = "turn_played"
+	EventTokensUsed          = "tokens_used"
+	EventSettingChanged      = "setting_changed"
+)
+
+// Sources of the events, who made the change.
+const (
+	SourceNarrator        = "narrator"
+	SourceStateManager    = "state_manager"
+	SourceStorySummarizer = "story_summarizer"
+	SourceEngine          = "engine"
+	SourceAdmin           = "admin"
+)
+
+// Settings an admin can change.
+const (
+	SettingContentRating = "content_rating"
+	SettingPromptVersion = "prompt_version"
+)
+
+// Event is a change made to a game.  Only the fields of its type are set.
+type Event struct {
+	Type   string    `json:"type"`
+	Turn   int       `json:"turn"`
+	Source string    `json:"source"`
+	At     time.Time `json:"at"`
+
+	Location         string   `json:"location,omitempty"`
+	PreviousLocation string   `json:"previous_location,omitempty"`
+	Names            []string `json:"names,omitempty"`
+	Command          string   `json:"command,omitempty"`
+	Narrative        string   `json:"narrative,omitempty"`
+	Tokens           int      `json:"tokens,omitempty"`
+	Setting          string   `json:"setting,omitempty"`
+	Value            string   `json:"value,omitempty"`
+	// Game is the whole game, set on baselines.
+	Game *Game `json:"game,omitempty"`
+}
+
+const defaultEventLogRetention = 200
+
+// eventLogRetention is about how many events are kept per save slot, set
+// with EVENT_LOG_RETENTION.
+func eventLogRetention() int {
+	if n, err := strconv.Atoi(os.Getenv("EVENT_LOG_RETENTION")); err == nil && n > 0 {
+		return n
+	}
+	return defaultEventLogRetention
+}
+
+func isBaseline(eventType string) bool {
+	switch eventType {
+	case EventGameStarted, EventGameRestored, EventGameForked, EventLogCompacted:
+		return true
+	}
+	return false
+}
+
+// record applies a change to the game and queues its event, which is
+// appended to the game's log when the game is saved.
+func (g *Game) record(source string, e Event) {
+	e.Source = source
+	e.At = time.Now().UTC()
+	g.apply(e)
+	g.pendingEvents = append(g.pendingEvents, e)
+}
+
+// recordBaseline queues an event carrying the game as it is now, replays
+// start from it.
+func (g *Game) recordBaseline(source string, eventType string, value string) {
+	baseline, err := cloneGame(g)
+	if err != nil {
+		log.Println("Error recording baseline: ", err)
+		return
+	}
+	g.pendingEvents = append(g.pendingEvents, Event{
+		Type:   eventType,
+		Source: source,
+		At:     time.Now().UTC(),
+		Value:  value,
+		Game:   baseline,
+	})
+}
+
+// apply makes the change an event describes.  Baselines are applied by
+// replay, which starts over from them.
+func (g *Game) apply(e Event) {
+	switch e.Type {
+	case EventPlayerMoved:
+		g.World.NextLocation(g.World.SafeAddLocation(e.Location))
+	case EventMoveReverted:
+		if location, ok := g.World.GetLocationByName(e.Location); ok {
+			g.World.CurrentLocation = location
+		}
+		g.World.PreviousLocationKey = e.PreviousLocation
+	case EventPathsFound:
+		location := g.eventLocation(e.Location)
+		for _, name := range e.Names {
+			location.SafeAddAdjacentLocation(g.World.SafeAddLocation(name))
+		}
+	case EventObjectsFound:
+		g.eventLocation(e.Location).InteractiveItems.AddAll(e.Names...)
+	case EventObjectsRemoved:
+		g.eventLocation(e.Location).InteractiveItems.RemoveAll(e.Names...)
+	case EventEnemiesSpawned:
+		g.eventLocation(e.Location).Enemies.AddAll(e.Names...)
+	case EventEnemiesRemoved:
+		g.eventLocation(e.Location).Enemies.RemoveAll(e.Names...)
+	case EventItemsAdded:
+		g.Player.Inventory.AddAll(e.Names...)
+	case EventItemsRemoved:
+		g.Player.Inventory.RemoveAll(e.Names...)
+	case EventStoryThreadAdded:
+		g.StoryThreads = append(g.StoryThreads, e.Names...)
+	case EventStoryThreadsUpdated:
+		g.StoryThreads = append([]string(nil), e.Names...)
+	case EventTurnPlayed:
+		g.UpdateGameHistory(GameMessage{Provider: "user", Message: e.Command}, GameMessage{Provider: "assistant", Message: e.Narrative})
+	case EventTokensUsed:
+		g.TotalTokensUsed += e.Tokens
+	case EventSettingChanged:
+		switch e.Setting {
+		case SettingContentRating:
+			g.ContentRating = e.Value
+		case SettingPromptVersion:
+			g.PromptVersion = e.Value
+		}
+	}
+}
+
+// eventLocation returns the location an event happened at, the current
+// location if it isn't known.
+func (g *Game) eventLocation(name string) *Location {
+	if location, ok := g.World.GetLocationByName(name); ok {
+		return location
+	}
+	return g.World.CurrentLocation
+}
+
+// SetContentRating changes the game's content rating for an admin.
+func (g *Game) SetContentRating(rating string) {
+	g.record(SourceAdmin, Event{Type: EventSettingChanged, Setting: SettingContentRating, Value: rating})
+}
+
+// SetPromptVersion pins the game to a prompt version for an admin, empty to
+// follow the default version.
+func (g *Game) SetPromptVersion(version string) {
+	g.record(SourceAdmin, Event{Type: EventSettingChanged, Setting: SettingPromptVersion, Value: version})
+}
+
+// cloneGame deep copies a game through JSON.
+func cloneGame(g *Game) (*Game, error) {
+	data, err := json.Marshal(g)
+	if err != nil {
+		return nil, err
+	}
+
+	var clone Game
+	if err := json.Unmarshal(data, &clone); err != nil {
+		return nil, err
+	}
+	clone.relink()
+	clone.slot = g.slot
+	return &clone, nil
+}
+
+// relink points the current location back at the world's copy of it.
+// Encoding a game flattens pointers, so a decoded game's current location
+// is a copy that changes would otherwise be lost from.
+func (g *Game) relink() {
+	if g.World == nil || g.World.CurrentLocation == nil {
+		return
+	}
+	if location, ok := g.World.GetLocationByName(g.World.CurrentLocation.LocationName); ok {
+		g.World.CurrentLocation = location
+	}
+}
+
+func eventsKey(email string, slot string) *redis.GameEventsKey {
+	if normalizeSlot(slot) == MainSlot {
+		return &redis.GameEventsKey{Email: email}
+	}
+	return &redis.GameEventsKey{Email: email, Slot: slot}
+}
+
+// appendEvents adds events to a save slot's log, stamped with the turn they
+// were saved at, and returns their ids.
+func appendEvents(ctx context.Context, email string, slot string, turn int, events []Event) ([]string, error) {
+	entries := make([]map[string]interface{}, 0, len(events))
+	for _, e := range events {
+		e.Turn = turn
+		data, err := json.Marshal(e)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, map[string]interface{}{
+			"type":   e.Type,
+			"turn":   turn,
+			"source": e.Source,
+			"data":   string(data),
+		})
+	}
+	return redis.AppendStream(ctx, eventsKey(email, slot), entries)
+}
+
+// flushEvents appends the game's queued events to its log.  A log that
+// would grow past eventLogRetention gets a new baseline and the events
+// before it are dropped, replays don't need them.  The log is for auditing,
+// so failures are only logged.
+func (g *Game) flushEvents(ctx context.Context, email string) {
+	if len(g.pendingEvents) == 0 {
+		return
+	}
+	defer func() { g.pendingEvents = nil }()
+
+	length, err := redis.StreamLength(ctx, eventsKey(email, g.slot))
+	if err != nil {
+		log.Println("Error checking game events: ", err)
+		return
+	}
+	compact := length+int64(len(g.pendingEvents)) > int64(eventLogRetention())
+	if compact {
+		g.recordBaseline(SourceEngine, EventLogCompacted, "")
+		compact = isBaseline(g.pendingEvents[len(g.pendingEvents)-1].Type)
+	}
+
+	ids, err := appendEvents(ctx, email, g.slot, g.currentTurn(), g.pendingEvents)
+	if err != nil {
+		log.Println("Error appending game events: ", err)
+		return
+	}
+	if compact {
+		if err := redis.TrimStream(ctx, eventsKey(email, g.slot), ids[len(ids)-1]); err != nil {
+			log.Println("Error trimming game events: ", err)
+		}
+	}
+}
+
+// ensureEventLog starts the log of a game that has none, such as one saved
+// before there was a log, with a baseline of the game as it is.
+func (g *Game) ensureEventLog(ctx context.Context, email string) {
+	length, err := redis.StreamLength(ctx, eventsKey(email, g.slot))
+	if err != nil {
+		log.Println("Error checking game events: ", err)
+		return
+	}
+	if length > 0 {
+		return
+	}
+
+	baseline, err := cloneGame(g)
+	if err != nil {
+		log.Println("Error recording baseline: ", err)
+		return
+	}
+	e := Event{Type: EventGameStarted, Source: SourceEngine, At: time.Now().UTC(), Game: baseline}
+	if _, err := appendEvents(ctx, email, g.slot, g.currentTurn(), []Event{e}); err != nil {
+		log.Println("Error appending game events: ", err)
+	}
+}
+
+// ReadEvents returns the log of a save slot's game, oldest first.
+func ReadEvents(ctx context.Context, email string, slot string) ([]Event, error) {
+	entries, err := redis.ReadStream(ctx, eventsKey(email, slot))
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]Event, 0, len(entries))
+	for _, entry := range entries {
+		var e Event
+		if err := json.Unmarshal([]byte(entry.Values["data"]), &e); err != nil {
+			return nil, fmt.Errorf("error decoding game event %s: %w", entry.ID, err)
+		}
+		if e.Turn == 0 {
+			e.Turn, _ = strconv.Atoi(entry.Values["turn"])
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// RebuildGame rebuilds a save slot's game by replaying its log.
+func RebuildGame(ctx context.Context, email string, slot string) (*Game, error) {
+	events, err := ReadEvents(ctx, email, slot)
+	if err != nil {
+		return nil, err
+	}
+
+	g, err := replay(events)
+	if err != nil {
+		return nil, err
+	}
+	g.slot = normalizeSlot(slot)
+	return g, nil
+}
+
+// replay applies events from the latest baseline.
+func replay(events []Event) (*Game, error) {
+	start := -1
+	for i, e := range events {
+		if isBaseline(e.Type) && e.Game != nil {
+			start = i
+		}
+	}
+	if start < 0 {
+		return nil, fmt.Errorf("the game's log has no baseline to replay from")
+	}
+
+	g, err := cloneGame(events[start].Game)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range events[start+1:] {
+		g.apply(e)
+	}
+	return g, nil
+}
@@ -0,0 +1,77 @@
+package game
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestReplayingEventsRebuildsGame(t *testing.T) {
+	g := BuildNewGame(NewGameDetails{
+		StartingLocation:          "Village Square",
+		PlayerName:                "Test Player",
+		PlayerInventory:           []string{"torch"},
+		StartingAdjacentLocations: []string{"Old Mill"},
+	})
+	g.recordBaseline(SourceEngine, EventGameStarted, "")
+
+	tools := newTurnTools(g)
+	tools.movePlayer(movePlayerArgs{Location: "Old Mill", ConnectedLocations: []string{"River Bank"}})
+	tools.addItem("rusty key")
+	tools.spawnEnemy("troll")
+	tools.addStoryThread("The miller is missing")
+	g.record(SourceNarrator, Event{Type: EventTurnPlayed, Command: "go to the mill", Narrative: "You walk to the Old Mill and find a rusty key. A troll blocks the door."})
+	g.UpdateGameState(GameStateUpdateResponse{
+		InteactiveObjectsIdentified: []string{"millstone"},
+		PlayerInventoryRemoved:      []string{"torch"},
+	})
+	g.SetContentRating("mature")
+
+	// events are stored as JSON, replay what would be read back
+	var events []Event
+	for _, e := range g.pendingEvents {
+		data, err := json.Marshal(e)
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		var decoded Event
+		json.Unmarshal(data, &decoded)
+		events = append(events, decoded)
+	}
+
+	rebuilt, err := replay(events)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+
+	want, _ := json.Marshal(g)
+	got, _ := json.Marshal(rebuilt)
+	if string(want) != string(got) {
+		t.Errorf("Expected the rebuilt game to match\n%s\nbut got\n%s", want, got)
+	}
+	if rebuilt.World.CurrentLocation != rebuilt.World.Locations["old_mill"] {
+		t.Errorf("Expected the current location to be the world's Old Mill")
+	}
+}
+
+func TestReplayNeedsBaseline(t *testing.T) {
+	if _, err := replay([]Event{{Type: EventItemsAdded, Names: []string{"sword"}}}); err == nil {
+		t.Errorf("Expected an error replaying a log without a baseline")
+	}
+}
+
+func TestReplayStartsFromCompactedLog(t *testing.T) {
+	g := InitializeNewGame()
+	g.recordBaseline(SourceEngine, EventGameStarted, "")
+	g.record(SourceNarrator, Event{Type: EventItemsAdded, Names: []string{"sword"}})
+	g.recordBaseline(SourceEngine, EventLogCompacted, "")
+	g.record(SourceNarrator, Event{Type: EventItemsAdded, Names: []string{"shield"}})
+
+	// a compacted log keeps only the latest baseline and what follows it
+	rebuilt, err := replay(g.pendingEvents[2:])
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if !rebuilt.Player.Inventory.Contains("sword") || !rebuilt.Player.Inventory.Contains("shield") {
+		t.Errorf("Expected the sword and shield, but got %v", rebuilt.Player.Inventory.ToSlice())
+	}
+}
@@ -0,0 +1,149 @@
+package game
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+
+	"github.com/sessionsdev/blue-octopus/internal/aiapi"
+	"github.com/sessionsdev/blue-octopus/internal/experiments"
+	"github.com/sessionsdev/blue-octopus/internal/redis"
+)
+
+type variantsContextKey struct{}
+
+// withVariants records the experiment variants a turn is played with.
+func withVariants(ctx context.Context, variants map[string]experiments.Assignment) context.Context {
+	return context.WithValue(ctx, variantsContextKey{}, variants)
+}
+
+func variantsFromContext(ctx context.Context) map[string]experiments.Assignment {
+	variants, _ := ctx.Value(variantsContextKey{}).(map[string]experiments.Assignment)
+	return variants
+}
+
+// variantFor returns the variant the turn plays a role with, if the role is
+// being experimented on.
+func variantFor(ctx context.Context, role string) (experiments.Assignment, bool) {
+	variant, ok := variantsFromContext(ctx)[role]
+	return variant, ok
+}
+
+// variants returns the experiment variants for the game.  A game pinned to
+// a prompt version is kept out of experiments so it stays reproducible.
+func (g *Game) variants(username string) map[string]experiments.Assignment {
+	if g.PromptVersion != "" {
+		return nil
+	}
+	return experiments.Assign(username, g.ID)
+}
+
+// clientFor returns the client for a role, or the client of the role's
+// variant if it changes the model.
+func clientFor(ctx context.Context, role string) (aiapi.AIClient, error) {
+	if variant, ok := variantFor(ctx, role); ok && variant.Client != nil {
+		return variant.Client, nil
+	}
+	return aiapi.GetClient(role)
+}
+
+// recordOutcome counts an outcome against the variant of a role.
+func recordOutcome(ctx context.Context, role string, metric string) {
+	variant, ok := variantFor(ctx, role)
+	if !ok {
+		return
+	}
+	if err := experiments.RecordOutcome(context.WithoutCancel(ctx), variant, metric); err != nil {
+		log.Println("Error recording experiment outcome: ", err)
+	}
+}
+
+// recordTurnOutcome counts an outcome against every variant of the turn.
+func recordTurnOutcome(ctx context.Context, metric string) {
+	for role := range variantsFromContext(ctx) {
+		recordOutcome(ctx, role, metric)
+	}
+}
+
+type turnNotifierContextKey struct{}
+
+// withTurnNotifier has notify called with the number of the turn a command
+// played, once its narrative is ready.  Commands that don't play a turn
+// don't call it.
+func withTurnNotifier(ctx context.Context, notify func(turn int)) context.Context {
+	return context.WithValue(ctx, turnNotifierContextKey{}, notify)
+}
+
+func notifyTurn(ctx context.Context, turn int) {
+	if notify, ok := ctx.Value(turnNotifierContextKey{}).(func(turn int)); ok {
+		notify(turn)
+	}
+}
+
+var errAlreadyVoted = errors.New("you already voted on this turn")
+
+func feedbackKey(email string, slot string) *redis.TurnFeedbackKey {
+	if normalizeSlot(slot) == MainSlot {
+		return &redis.TurnFeedbackKey{Email: email}
+	}
+	return &redis.TurnFeedbackKey{Email: email, Slot: slot}
+}
+
+// saveTurnVariants keeps the variants a turn is played with for the votes
+// on it, and clears the vote on a turn of the same number that was undone.
+// Feedback is a nice to have, so failures are only logged.
+func saveTurnVariants(ctx context.Context, email string, slot string, turn int, variants map[string]experiments.Assignment) {
+	assignments := make([]experiments.Assignment, 0, len(variants))
+	for _, variant := range variants {
+		assignments = append(assignments, variant)
+	}
+	data, err := json.Marshal(assignments)
+	if err != nil {
+		log.Println("Error encoding turn variants: ", err)
+		return
+	}
+
+	key := feedbackKey(email, slot)
+	if err := redis.SetHashField(ctx, key, "variants:"+strconv.Itoa(turn), string(data)); err != nil {
+		log.Println("Error saving turn variants: ", err)
+	}
+	if err := redis.DeleteHashField(ctx, key, "vote:"+strconv.Itoa(turn)); err != nil {
+		log.Println("Error clearing turn vote: ", err)
+	}
+}
+
+// recordTurnFeedback counts a player's vote on a turn, one of the feedback
+// Metric constants, against the variants the turn was played with.  Only
+// the first vote on a turn is counted, later ones return errAlreadyVoted.
+func recordTurnFeedback(ctx context.Context, email string, slot string, turn int, metric string) error {
+	key := feedbackKey(email, slot)
+	first, err := redis.SetHashFieldIfMissing(ctx, key, "vote:"+strconv.Itoa(turn), metric)
+	if err != nil {
+		return err
+	}
+	if !first {
+		return errAlreadyVoted
+	}
+
+	data, err := redis.GetHashField(ctx, key, "variants:"+strconv.Itoa(turn))
+	var notFound *redis.NotFoundError
+	if errors.As(err, &notFound) {
+		// the turn was played outside any experiment
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var assignments []experiments.Assignment
+	if err := json.Unmarshal([]byte(data), &assignments); err != nil {
+		return err
+	}
+	for _, assignment := range assignments {
+		if err := experiments.RecordOutcome(ctx, assignment, metric); err != nil {
+			log.Println("Error recording experiment outcome: ", err)
+		}
+	}
+	return nil
+}
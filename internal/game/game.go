@@ -24,19 +24,30 @@ func (m *GameMessage) NewMessage(provider string, message string) Message {
 }
 
 type Game struct {
+	// ID tells games apart, games saved before it existed have none.
+	ID                 string        `json:"id"`
 	World              *World        `json:"world"`
 	Player             *Player       `json:"player"`
 	MainQuest          string        `json:"main_quest"`
 	StoryThreads       []string      `json:"story_threads"`
 	GameMessageHistory []GameMessage `json:"game_message_history"`
 	TotalTokensUsed    int           `json:"total_tokens_used"`
+	ContentRating      string        `json:"content_rating"`
+	// PromptVersion pins the game to a version of the prompts, empty to
+	// follow the default version.
+	PromptVersion string `json:"prompt_version"`
+
+	// slot is the save slot the game was loaded from, it isn't saved.
+	slot string
+	// pendingEvents are the changes made since the game was last saved.
+	pendingEvents []Event
 }
 
 func (g *Game) GetRecentHistory(numItems int) []GameMessage {
 	currentHistory := g.GameMessageHistory
-	if len(currentHistory) > 5 {
-		// Take the 5 most recent history items
-		return currentHistory[len(currentHistory)-5:]
+	if len(currentHistory) > numItems {
+		// Take the most recent history items
+		return currentHistory[len(currentHistory)-numItems:]
 	} else {
 		return currentHistory
 	}
@@ -2,10 +2,17 @@ package game
 
 import (
 	"encoding/json"
+	"errors"
 	"html/template"
+	"log"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/sessionsdev/blue-octopus/internal/aiapi"
 	"github.com/sessionsdev/blue-octopus/internal/auth"
+	"github.com/sessionsdev/blue-octopus/internal/experiments"
+	"github.com/sessionsdev/blue-octopus/internal/quota"
 )
 
 type Command struct {
@@ -31,6 +38,10 @@ func ServeGamePage(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// HandleGameCommand processes a command and answers with the whole turn once
+// it is played, for clients that can't read HandleGameCommandStream.  While
+// a busy Game Master is retried ServeTurnStatus reports it, for the client
+// to poll.
 func HandleGameCommand(w http.ResponseWriter, r *http.Request) {
 	// Only POST requests are allowed
 	if r.Method != http.MethodPost {
@@ -53,10 +64,21 @@ func HandleGameCommand(w http.ResponseWriter, r *http.Request) {
 
 	user := userValue.(*auth.User)
 
-	resultMsg, err := ProcessGameCommand(r.Context(), command, user.Email)
+	slot := auth.GetSessionSlot(r)
+	ctx := aiapi.WithRetryNotifier(withSlot(r.Context(), slot), func(attempt int, err *aiapi.APIError, wait time.Duration) {
+		setTurnStatus(user.Email, slot, retryStatus)
+	})
+	defer setTurnStatus(user.Email, slot, "")
+
+	turn := 0
+	ctx = withTurnNotifier(ctx, func(played int) {
+		turn = played
+	})
+
+	resultMsg, err := ProcessGameCommand(ctx, command, user.Email)
 	if err != nil {
 		w.Header().Set("Content-Type", "text/html")
-		executeTemplate(w, "templates/error-update.html", "game-update", resultMsg)
+		executeTemplate(w, "templates/error-update.html", "error-update", resultMsg)
 	} else {
 		w.Header().Set("HX-Trigger-After-Settle", "stats-update")
 		w.Header().Set("Content-Type", "text/html")
@@ -64,25 +86,131 @@ func HandleGameCommand(w http.ResponseWriter, r *http.Request) {
 		executeTemplate(w, "templates/game-update.html", "game-update", struct {
 			PlayerCommand      string
 			GameMasterResponse string
+			Turn               int
 		}{
 			PlayerCommand:      command,
 			GameMasterResponse: resultMsg,
+			Turn:               turn,
 		})
 	}
 }
 
+// ServeTurnStatus reports what is happening to the turn HandleGameCommand is
+// playing, empty when there is nothing to report.
+func ServeTurnStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userValue := r.Context().Value("user")
+	if userValue == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	user := userValue.(*auth.User)
+
+	w.Header().Set("Content-Type", "text/html")
+	w.Write([]byte(template.HTMLEscapeString(turnStatus(user.Email, auth.GetSessionSlot(r)))))
+}
+
+// HandleTurnFeedback records a player's vote on one of the Game Master's
+// turns against the experiment variants the turn was played with.  A turn
+// can be voted on once.
+func HandleTurnFeedback(w http.ResponseWriter, r *http.Request) {
+	// Only POST requests are allowed
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var metric string
+	switch r.FormValue("vote") {
+	case "up":
+		metric = experiments.MetricFeedbackUp
+	case "down":
+		metric = experiments.MetricFeedbackDown
+	default:
+		http.Error(w, "Vote must be up or down", http.StatusBadRequest)
+		return
+	}
+
+	turn, err := strconv.Atoi(r.FormValue("turn"))
+	if err != nil || turn < 1 {
+		http.Error(w, "Missing turn", http.StatusBadRequest)
+		return
+	}
+
+	userValue := r.Context().Value("user")
+	if userValue == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	user := userValue.(*auth.User)
+
+	err = recordTurnFeedback(r.Context(), user.Email, auth.GetSessionSlot(r), turn, metric)
+	w.Header().Set("Content-Type", "text/html")
+	if errors.Is(err, errAlreadyVoted) {
+		w.Write([]byte("You already voted on this turn."))
+		return
+	} else if err != nil {
+		log.Println("Error recording feedback: ", err)
+		http.Error(w, "Unable to record feedback", http.StatusInternalServerError)
+		return
+	}
+
+	w.Write([]byte("Thanks for the feedback."))
+}
+
+// ServeGameStats renders the stats panel from the player's saved game.
 func ServeGameStats(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Only GET requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userValue := r.Context().Value("user")
+	if userValue == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	user := userValue.(*auth.User)
+
+	g, err := LoadGameFromRedis(r.Context(), user.Email, auth.GetSessionSlot(r))
+	if err != nil {
+		http.Error(w, "No game found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	executeTemplate(w, "templates/stats-panel.html", "stats-panel", g.prepareStats())
+}
+
+func ServeUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET requests are allowed", http.StatusMethodNotAllowed)
+		return
 	}
 
-	if PreparedStatsCache == nil {
-		http.Error(w, "No stats available", http.StatusNotFound)
+	userValue := r.Context().Value("user")
+	if userValue == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	user := userValue.(*auth.User)
+
+	usage, err := quota.GetUsage(r.Context(), user.Email)
+	if err != nil {
+		http.Error(w, "Error loading usage", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "text/html")
-	executeTemplate(w, "templates/stats-panel.html", "stats-panel", PreparedStatsCache)
+	executeTemplate(w, "templates/usage-panel.html", "usage-panel", usage)
 }
 
 func HandleGameState(w http.ResponseWriter, r *http.Request) {
@@ -93,7 +221,7 @@ func HandleGameState(w http.ResponseWriter, r *http.Request) {
 
 	username := r.Context().Value("username").(string)
 
-	g, err := LoadGameFromRedis(r.Context(), username)
+	g, err := LoadGameFromRedis(r.Context(), username, auth.GetSessionSlot(r))
 	if err != nil {
 		http.Error(w, "Error loading game from redis", http.StatusInternalServerError)
 		return
@@ -120,3 +248,228 @@ func executeTemplate(w http.ResponseWriter, templateFile string, templateName st
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
+
+// SlotsPanel is what the slot picker shows, branches nested under the save
+// they were forked from.
+type SlotsPanel struct {
+	Slots []*SlotNode
+	Error string
+}
+
+// ServeSlots renders the player's save slots.
+func ServeSlots(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userValue := r.Context().Value("user")
+	if userValue == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	user := userValue.(*auth.User)
+
+	renderSlots(w, r, user.Email, "")
+}
+
+// HandleSlotAction creates, loads, renames, duplicates or deletes a save
+// slot and renders the slot picker again.  Switching slots reloads the page
+// so every panel shows the new game.
+func HandleSlotAction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userValue := r.Context().Value("user")
+	if userValue == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	user := userValue.(*auth.User)
+
+	ctx := r.Context()
+	slot := r.FormValue("slot")
+	name := r.FormValue("name")
+
+	var err error
+	switch r.FormValue("action") {
+	case "create":
+		var info SlotInfo
+		if info, err = CreateSlot(ctx, user.Email, name); err == nil {
+			err = switchSlot(w, r, info.ID)
+		}
+	case "load":
+		if _, err = LoadGameFromRedis(ctx, user.Email, slot); err != nil {
+			err = ErrSlotNotFound
+		} else {
+			err = switchSlot(w, r, slot)
+		}
+	case "rename":
+		err = RenameSlot(ctx, user.Email, slot, name)
+	case "duplicate":
+		_, err = DuplicateSlot(ctx, user.Email, slot, name)
+	case "delete":
+		if normalizeSlot(slot) == normalizeSlot(auth.GetSessionSlot(r)) {
+			err = errors.New("switch to another save before deleting this one")
+		} else {
+			err = DeleteSlot(ctx, user.Email, slot)
+		}
+	default:
+		http.Error(w, "Unknown action", http.StatusBadRequest)
+		return
+	}
+
+	message := ""
+	if errors.Is(err, errTurnInProgress) {
+		message = turnInProgressMessage
+	} else if err != nil {
+		log.Println("Error changing save slot: ", err)
+		message = err.Error()
+	}
+	renderSlots(w, r, user.Email, message)
+}
+
+func switchSlot(w http.ResponseWriter, r *http.Request, slot string) error {
+	if err := auth.SetSessionSlot(r, slot); err != nil {
+		return err
+	}
+	w.Header().Set("HX-Refresh", "true")
+	return nil
+}
+
+func renderSlots(w http.ResponseWriter, r *http.Request, email string, message string) {
+	slots, err := ListSlots(r.Context(), email)
+	if err != nil {
+		http.Error(w, "Error loading saves", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	executeTemplate(w, "templates/slots-panel.html", "slots-panel", SlotsPanel{
+		Slots: slotTree(slots, normalizeSlot(auth.GetSessionSlot(r))),
+		Error: message,
+	})
+}
+
+// TimelinePanel is what the timeline shows, the newest turn first.
+type TimelinePanel struct {
+	Turns   []Snapshot
+	Current int
+	Error   string
+}
+
+// ServeTimeline renders the turns the player's game can be rewound to.
+func ServeTimeline(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userValue := r.Context().Value("user")
+	if userValue == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	user := userValue.(*auth.User)
+
+	renderTimeline(w, r, user.Email, "")
+}
+
+// HandleRewind rewinds the player's game to a turn and reloads the page so
+// every panel shows the rewound game.
+func HandleRewind(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	turn, err := strconv.Atoi(r.FormValue("turn"))
+	if err != nil || turn < 0 {
+		http.Error(w, "Missing or invalid turn", http.StatusBadRequest)
+		return
+	}
+
+	userValue := r.Context().Value("user")
+	if userValue == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	user := userValue.(*auth.User)
+
+	_, err = Rewind(r.Context(), user.Email, auth.GetSessionSlot(r), turn)
+	if errors.Is(err, errTurnInProgress) {
+		renderTimeline(w, r, user.Email, turnInProgressMessage)
+		return
+	} else if err != nil {
+		log.Println("Error rewinding game: ", err)
+		renderTimeline(w, r, user.Email, err.Error())
+		return
+	}
+
+	w.Header().Set("HX-Refresh", "true")
+	renderTimeline(w, r, user.Email, "")
+}
+
+// HandleBranch forks the player's game at a turn into a new save and
+// switches to it, leaving the original as it is.
+func HandleBranch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	turn, err := strconv.Atoi(r.FormValue("turn"))
+	if err != nil || turn < 0 {
+		http.Error(w, "Missing or invalid turn", http.StatusBadRequest)
+		return
+	}
+
+	userValue := r.Context().Value("user")
+	if userValue == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	user := userValue.(*auth.User)
+
+	info, err := BranchSlot(r.Context(), user.Email, auth.GetSessionSlot(r), turn)
+	if errors.Is(err, errTurnInProgress) {
+		renderTimeline(w, r, user.Email, turnInProgressMessage)
+		return
+	} else if err == nil {
+		err = switchSlot(w, r, info.ID)
+	}
+	if err != nil {
+		log.Println("Error branching game: ", err)
+		renderTimeline(w, r, user.Email, err.Error())
+		return
+	}
+
+	renderTimeline(w, r, user.Email, "")
+}
+
+func renderTimeline(w http.ResponseWriter, r *http.Request, email string, message string) {
+	slot := auth.GetSessionSlot(r)
+	snapshots, err := ListSnapshots(r.Context(), email, slot)
+	if err != nil {
+		http.Error(w, "Error loading timeline", http.StatusInternalServerError)
+		return
+	}
+
+	panel := TimelinePanel{Error: message}
+	if g, err := LoadGameFromRedis(r.Context(), email, slot); err == nil {
+		panel.Current = g.currentTurn()
+	}
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		panel.Turns = append(panel.Turns, snapshots[i])
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	executeTemplate(w, "templates/timeline-panel.html", "timeline-panel", panel)
+}
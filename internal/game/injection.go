@@ -0,0 +1,144 @@
+package game
+
+import (
+	"context"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sessionsdev/blue-octopus/internal/moderation"
+)
+
+// wrapCommand delimits a player command so the models can tell the player's
+// words from their instructions.  Tags inside the command are removed so it
+// can't close the delimiter early.
+func wrapCommand(command string) string {
+	command = commandTagPattern.ReplaceAllString(command, "")
+	return "<player_command>\n" + strings.TrimSpace(command) + "\n</player_command>"
+}
+
+var commandTagPattern = regexp.MustCompile(`(?i)</?\s*player_command\s*>`)
+
+// delimitHistory wraps the player commands in the history.  The history is
+// stored raw, so the delimiters can change without migrating saved games.
+func delimitHistory(history []GameMessage) []GameMessage {
+	delimited := make([]GameMessage, len(history))
+	for i, message := range history {
+		if message.Provider == "user" {
+			message.Message = wrapCommand(message.Message)
+		}
+		delimited[i] = message
+	}
+	return delimited
+}
+
+// injectionPatterns catch commands addressed to the model rather than to
+// the game world.  Ignoring rules or taking items is fine in the story, so
+// those patterns need the command to refer to the model, its instructions
+// or the game state.
+var injectionPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\b(ignore|disregard|forget|override)\s+(all\s+|any\s+)?(of\s+)?(the\s+|these\s+|those\s+)?((previous|prior|above|earlier|preceding|original|initial|system)\s+|your\s+((previous|prior|original|initial|system)\s+)?)(instructions?|prompts?|directions|rules|guidelines|programming)\b`),
+	regexp.MustCompile(`(?i)\b(ignore|disregard|forget|override)\s+(all|any)\s+(of\s+)?(the\s+)?(instructions|prompts)\b`),
+	regexp.MustCompile(`(?i)\b(system|developer)\s+(prompt|message|mode)\b`),
+	regexp.MustCompile(`(?i)\byou\s+are\s+(now|no\s+longer)\b.{0,40}\b(assistant|ai|model|game\s*master|gm|narrator)\b`),
+	regexp.MustCompile(`(?i)\b(new|updated)\s+instructions\b`),
+	regexp.MustCompile(`(?i)\b(add|put|insert|give)\b.{0,40}\bto\s+the\s+player'?s?\s+inventory\b`),
+	regexp.MustCompile(`(?i)\b(system|developer|admin|ai|gm|game\s*master|narrator)\s*[:,]\s*(add|put|insert|give|set|change|update|remove)\b`),
+	regexp.MustCompile(`(?i)\b(set|change|update)\s+(my|the\s+player'?s?)\s+(location|inventory|state)\b`),
+	regexp.MustCompile(`(?i)"?(player_location|player_inventory_added|player_inventory)"?\s*:`),
+	regexp.MustCompile(`(?i)</?\s*player_command\s*>`),
+}
+
+// classifyInjection reports whether a command looks like an attempt to
+// instruct the models, and the text that gave it away.
+func classifyInjection(command string) (bool, string) {
+	for _, pattern := range injectionPatterns {
+		if match := pattern.FindString(command); match != "" {
+			return true, match
+		}
+	}
+	return false, ""
+}
+
+// rejectInjection checks a command for prompt injection, recording any
+// attempt.  It returns the in fiction response to show the player instead.
+func rejectInjection(ctx context.Context, g *Game, username string, command string) (string, bool) {
+	injection, match := classifyInjection(command)
+	if !injection {
+		return "", true
+	}
+
+	recordRejection(ctx, g, username, "injection", command, "prompt_injection", "matched "+strconv.Quote(match))
+	return "The Game Master smiles knowingly. \"The world doesn't bend to words like those.\" Try an action your character could take.", false
+}
+
+// validateStateUpdate drops the inventory additions and location change in
+// a state update that the narrative doesn't mention, so a command can't talk
+// the state manager into items or places the story never gave the player.
+func (g *Game) validateStateUpdate(ctx context.Context, username string, command string, narrative string, update GameStateUpdateResponse) GameStateUpdateResponse {
+	narrativeWords := wordSet(narrative)
+
+	justified := []string{}
+	for _, item := range update.PlayerInventoryAdded {
+		if mentions(narrativeWords, item) {
+			justified = append(justified, item)
+			continue
+		}
+		recordRejection(ctx, g, username, "state_change", command, "inventory", "narrative doesn't mention "+strconv.Quote(item))
+	}
+	update.PlayerInventoryAdded = justified
+
+	location := update.PlayerLocation
+	currentLocation := g.World.CurrentLocation.LocationName
+	if location != "" && normalizedLocationName(location) != normalizedLocationName(currentLocation) && !mentions(narrativeWords, location) {
+		recordRejection(ctx, g, username, "state_change", command, "location", "narrative doesn't mention "+strconv.Quote(location))
+		update.PlayerLocation = currentLocation
+	}
+
+	return update
+}
+
+func recordRejection(ctx context.Context, g *Game, username string, source string, command string, category string, reason string) {
+	log.Printf("Rejected %s for %s: %s, %s", source, username, category, reason)
+
+	err := moderation.RecordFlagged(context.WithoutCancel(ctx), moderation.FlaggedTurn{
+		Time:      time.Now().UTC(),
+		User:      username,
+		Source:    source,
+		Text:      command,
+		Rating:    g.Rating(),
+		Category:  category,
+		Reason:    reason,
+		Moderator: "injection-guard",
+	})
+	if err != nil {
+		log.Println("Error recording rejected turn: ", err)
+	}
+}
+
+// words too common to show a name is mentioned
+var stopWords = map[string]bool{
+	"the": true, "a": true, "an": true, "of": true, "some": true, "and": true, "to": true, "in": true, "on": true, "with": true,
+}
+
+var wordPattern = regexp.MustCompile(`[a-z0-9']+`)
+
+func wordSet(text string) map[string]bool {
+	words := map[string]bool{}
+	for _, word := range wordPattern.FindAllString(strings.ToLower(text), -1) {
+		words[strings.TrimSuffix(word, "s")] = true
+	}
+	return words
+}
+
+// mentions reports whether any significant word of name is in the words.
+func mentions(words map[string]bool, name string) bool {
+	for word := range wordSet(name) {
+		if len(word) >= 3 && !stopWords[word] && words[word] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package game
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/sessionsdev/blue-octopus/internal/memory"
+)
+
+// narratorHistoryMessages is how much of the history the narrator sees, the
+// turns inside it don't need recalling.
+const narratorHistoryMessages = 20
+
+const defaultMemoryTopK = 5
+
+// memoryTopK is how many memories are recalled for each command, set with
+// MEMORY_TOP_K.  Zero turns recall off.
+func memoryTopK() int {
+	if k, err := strconv.Atoi(os.Getenv("MEMORY_TOP_K")); err == nil && k >= 0 {
+		return k
+	}
+	return defaultMemoryTopK
+}
+
+// currentTurn is the number of turns played so far.
+func (g *Game) currentTurn() int {
+	return len(g.GameMessageHistory) / 2
+}
+
+// recallMemories returns the memories relevant to a command, formatted for
+// the narrator.  Memory is a nice to have, so failures are only logged.
+func (g *Game) recallMemories(ctx context.Context, username string, command string) []string {
+	query := command
+	if g.World.CurrentLocation != nil {
+		query = g.World.CurrentLocation.LocationName + ": " + command
+	}
+
+	oldestRecentTurn := g.currentTurn() - narratorHistoryMessages/2
+	recollections, err := memory.Recall(ctx, memoryOwner(username, g.slot), query, memoryTopK(), func(m memory.Memory) bool {
+		return m.Kind == memory.KindTurn && m.Turn > oldestRecentTurn
+	})
+	if err != nil {
+		log.Println("Error recalling memories: ", err)
+		return nil
+	}
+
+	memories := make([]string, len(recollections))
+	for i, recollection := range recollections {
+		memories[i] = fmt.Sprintf("(turn %d) %s", recollection.Turn, recollection.Text)
+	}
+	return memories
+}
+
+// rememberTurn stores memories of a finished turn: the exchange itself, the
+// location the player ended up in and anything the state manager noticed.
+func (g *Game) rememberTurn(ctx context.Context, username string, command string, narrative string, previousLocation string, update *GameStateUpdateResponse) {
+	turn := g.currentTurn()
+	memories := []memory.Memory{
+		{Kind: memory.KindTurn, Text: fmt.Sprintf("Player: %s\nGame Master: %s", command, narrative), Turn: turn},
+	}
+
+	locationName := ""
+	if g.World.CurrentLocation != nil {
+		locationName = g.World.CurrentLocation.LocationName
+		if locationName != previousLocation {
+			memories = append(memories, memory.Memory{Kind: memory.KindLocation, Subject: locationName, Text: fmt.Sprintf("%s: %s", locationName, narrative), Turn: turn})
+		}
+	}
+
+	if update != nil {
+		for _, name := range update.CharactersIdentified {
+			memories = append(memories, memory.Memory{Kind: memory.KindCharacter, Subject: name, Text: fmt.Sprintf("%s, a character met at %s", name, locationName), Turn: turn})
+		}
+		for _, name := range update.EnemiesIdentified {
+			memories = append(memories, memory.Memory{Kind: memory.KindEnemy, Subject: name, Text: fmt.Sprintf("%s, an enemy encountered at %s", name, locationName), Turn: turn})
+		}
+		for _, name := range update.InteactiveObjectsIdentified {
+			memories = append(memories, memory.Memory{Kind: memory.KindObject, Subject: name, Text: fmt.Sprintf("%s, an object found at %s", name, locationName), Turn: turn})
+		}
+	}
+
+	if err := memory.Remember(ctx, memoryOwner(username, g.slot), memories); err != nil {
+		log.Println("Error remembering turn: ", err)
+	}
+}
@@ -0,0 +1,139 @@
+package game
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sessionsdev/blue-octopus/internal/moderation"
+)
+
+// Rating returns the content rating the game is played at.
+func (g *Game) Rating() moderation.Rating {
+	if rating, ok := moderation.ParseRating(g.ContentRating); ok {
+		return rating
+	}
+	return moderation.DefaultRating()
+}
+
+// moderate checks a command or narrative against the game's content rating.
+// Blocked text is stored for review and the in fiction refusal to show the
+// player is returned instead.
+func (g *Game) moderate(ctx context.Context, username string, source string, text string) (string, bool) {
+	verdict := moderation.Check(ctx, text, g.Rating())
+	if !verdict.Blocked {
+		return "", true
+	}
+
+	log.Printf("Blocked %s for %s at rating %s: %s %s", source, username, g.Rating(), verdict.Category, verdict.Reason)
+	err := moderation.RecordFlagged(context.WithoutCancel(ctx), moderation.FlaggedTurn{
+		Time:      time.Now().UTC(),
+		User:      username,
+		Source:    source,
+		Text:      text,
+		Rating:    g.Rating(),
+		Category:  verdict.Category,
+		Reason:    verdict.Reason,
+		Moderator: verdict.Moderator,
+	})
+	if err != nil {
+		log.Println("Error recording flagged turn: ", err)
+	}
+
+	return refusalMessage(source, verdict.Category), false
+}
+
+func refusalMessage(source string, category string) string {
+	if strings.HasPrefix(category, "self") {
+		return "The Game Master sets down their dice and looks up. \"Let's pause the tale for a moment. If you're going through something hard, please reach out to someone you trust or a local helpline.\""
+	}
+	if source == "narrative" {
+		return "The scene blurs like ink in the rain as the Game Master thinks better of it. Try your command again, or take the story another way."
+	}
+	return "The Game Master raises an eyebrow. \"That's not the kind of tale we're telling here.\" Try something else."
+}
+
+// moderationOverlap is how much of the narrative already passed on is
+// moderated again along with new sentences, so they are judged in context.
+const moderationOverlap = 200
+
+// moderatedStream holds streamed narrative back until it has been
+// moderated.  Text is passed on a sentence at a time once the new sentences,
+// and a little of the text before them, pass.  A blocked narrative is
+// stopped before the player sees the part that was blocked.  The whole
+// narrative is moderated once more when it is finished.
+type moderatedStream struct {
+	ctx      context.Context
+	g        *Game
+	username string
+	onChunk  func(string)
+	// cancel stops the narrator once the narrative is blocked
+	cancel func()
+
+	text     strings.Builder
+	released int
+	blocked  bool
+	refusal  string
+}
+
+func newModeratedStream(ctx context.Context, g *Game, username string, onChunk func(string), cancel func()) *moderatedStream {
+	return &moderatedStream{ctx: ctx, g: g, username: username, onChunk: onChunk, cancel: cancel}
+}
+
+func (s *moderatedStream) write(chunk string) {
+	if s.blocked {
+		return
+	}
+	s.text.WriteString(chunk)
+
+	text := s.text.String()
+	end := strings.LastIndexAny(text, ".!?\n") + 1
+	if end <= s.released {
+		return
+	}
+
+	start := max(0, s.released-moderationOverlap)
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start++
+	}
+	if s.check(text[start:end]) {
+		s.release(text[:end])
+	}
+}
+
+// finish moderates the whole narrative and passes on the rest of it.  If
+// it is blocked the refusal to show instead is returned.
+func (s *moderatedStream) finish() (string, bool) {
+	if s.blocked {
+		return s.refusal, false
+	}
+
+	text := s.text.String()
+	if !s.check(text) {
+		return s.refusal, false
+	}
+	s.release(text)
+	return "", true
+}
+
+// check moderates text, stopping the narrator if it is blocked.
+func (s *moderatedStream) check(text string) bool {
+	refusal, ok := s.g.moderate(s.ctx, s.username, "narrative", text)
+	if !ok {
+		s.blocked = true
+		s.refusal = refusal
+		s.cancel()
+	}
+	return ok
+}
+
+// release passes on the part of text the player hasn't seen yet.
+func (s *moderatedStream) release(text string) {
+	if len(text) <= s.released {
+		return
+	}
+	s.onChunk(text[s.released:])
+	s.released = len(text)
+}
@@ -0,0 +1,63 @@
+package game
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/sessionsdev/blue-octopus/internal/moderation"
+)
+
+type recordingModerator struct {
+	texts []string
+}
+
+func (m *recordingModerator) Check(ctx context.Context, text string, rating moderation.Rating) (moderation.Verdict, error) {
+	m.texts = append(m.texts, text)
+	return moderation.Verdict{}, nil
+}
+
+func TestModeratedStreamChecksNewSentences(t *testing.T) {
+	rules, err := moderation.NewRuleModerator(moderation.DefaultRules)
+	if err != nil {
+		t.Fatalf("Expected the default rules to compile, but got %v", err)
+	}
+	defer moderation.SetModerator(rules)
+
+	moderator := &recordingModerator{}
+	moderation.SetModerator(moderator)
+
+	g := BuildNewGame(NewGameDetails{StartingLocation: "Cave Mouth", PlayerName: "Test Player"})
+
+	var streamed strings.Builder
+	stream := newModeratedStream(context.Background(), g, "stream@example.com", func(chunk string) {
+		streamed.WriteString(chunk)
+	}, func() {})
+
+	sentence := "The torchlight flickers across the damp cave walls. "
+	for i := 0; i < 20; i++ {
+		stream.write(sentence)
+	}
+	stream.write("A drip echoes")
+	if _, ok := stream.finish(); !ok {
+		t.Fatalf("Expected the narrative to pass")
+	}
+
+	narrative := strings.Repeat(sentence, 20) + "A drip echoes"
+	if streamed.String() != narrative {
+		t.Errorf("Expected the whole narrative to be streamed, but got %q", streamed.String())
+	}
+
+	checks := moderator.texts
+	if len(checks) != 21 {
+		t.Fatalf("Expected a check per sentence and one for the whole narrative, but got %d", len(checks))
+	}
+	for _, text := range checks[:len(checks)-1] {
+		if len(text) > len(sentence)+moderationOverlap {
+			t.Errorf("Expected only the new sentence and some context to be checked, but got %d bytes", len(text))
+		}
+	}
+	if checks[len(checks)-1] != narrative {
+		t.Errorf("Expected the finished narrative to be checked whole, but got %q", checks[len(checks)-1])
+	}
+}
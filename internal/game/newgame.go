@@ -1,5 +1,13 @@
 package game
 
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+
+	"github.com/sessionsdev/blue-octopus/internal/moderation"
+)
+
 func InitializeNewGame() *Game {
 	newGameDetails := NewGameDetails{
 		StartingLocation:          "Blue House",
@@ -10,6 +18,18 @@ func InitializeNewGame() *Game {
 
 	newGame := BuildNewGame(newGameDetails)
 	newGame.TotalTokensUsed = 0
+	newGame.ContentRating = string(moderation.DefaultRating())
+	newGame.ID = newID()
 
 	return newGame
 }
+
+// newID returns a random id for games and locks.
+func newID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		log.Println("Error generating id: ", err)
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
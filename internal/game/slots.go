@@ -0,0 +1,413 @@
+package game
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sessionsdev/blue-octopus/internal/memory"
+	"github.com/sessionsdev/blue-octopus/internal/redis"
+)
+
+// MainSlot is the save slot every player starts with.  It can't be deleted.
+const MainSlot = "main"
+
+const (
+	mainSlotName      = "Main campaign"
+	maxSlots          = 10
+	maxSlotNameLength = 40
+)
+
+// ErrSlotNotFound is returned for a save slot, or a user's game, that
+// doesn't exist.
+var ErrSlotNotFound = errors.New("that save no longer exists")
+
+// SlotInfo describes a save slot for the slot picker.  It is refreshed each
+// time the slot's game is saved.
+type SlotInfo struct {
+	ID         string    `json:"-"`
+	Name       string    `json:"name"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	Location   string    `json:"location"`
+	Turns      int       `json:"turns"`
+	TokensUsed int       `json:"tokens_used"`
+	// Parent is the slot a branch was forked from at ParentTurn, empty for
+	// saves that aren't branches.
+	Parent     string `json:"parent,omitempty"`
+	ParentTurn int    `json:"parent_turn,omitempty"`
+	// ContentRating and PromptVersion are copied from the game so the admin
+	// page can list them without loading every game.
+	ContentRating string `json:"content_rating,omitempty"`
+	PromptVersion string `json:"prompt_version,omitempty"`
+}
+
+type slotContextKey struct{}
+
+// withSlot records the save slot a request plays.
+func withSlot(ctx context.Context, slot string) context.Context {
+	return context.WithValue(ctx, slotContextKey{}, slot)
+}
+
+// slotFromContext returns the save slot a request plays, the main slot if
+// none was picked.
+func slotFromContext(ctx context.Context) string {
+	slot, _ := ctx.Value(slotContextKey{}).(string)
+	return normalizeSlot(slot)
+}
+
+func normalizeSlot(slot string) string {
+	if slot == "" {
+		return MainSlot
+	}
+	return slot
+}
+
+// Slot returns the save slot the game is kept in.
+func (g *Game) Slot() string {
+	return normalizeSlot(g.slot)
+}
+
+func saveKey(email string, slot string) *redis.UserSavedGameKey {
+	if normalizeSlot(slot) == MainSlot {
+		return &redis.UserSavedGameKey{Email: email}
+	}
+	return &redis.UserSavedGameKey{Email: email, Slot: slot}
+}
+
+// memoryOwner keeps the memories of each save slot apart.
+func memoryOwner(email string, slot string) string {
+	if normalizeSlot(slot) == MainSlot {
+		return email
+	}
+	return email + "/" + slot
+}
+
+func getSlotInfo(ctx context.Context, email string, slot string) (SlotInfo, error) {
+	value, err := redis.GetHashField(ctx, &redis.UserSaveSlotsKey{Email: email}, slot)
+	var notFound *redis.NotFoundError
+	if errors.As(err, &notFound) {
+		return SlotInfo{}, ErrSlotNotFound
+	} else if err != nil {
+		return SlotInfo{}, err
+	}
+
+	var info SlotInfo
+	if err := json.Unmarshal([]byte(value), &info); err != nil {
+		return SlotInfo{}, err
+	}
+	info.ID = slot
+	return info, nil
+}
+
+func setSlotInfo(ctx context.Context, email string, info SlotInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return redis.SetHashField(ctx, &redis.UserSaveSlotsKey{Email: email}, info.ID, string(data))
+}
+
+// updateSlotInfo refreshes the slot's description after the game is saved,
+// renaming it too if name isn't empty.
+func (g *Game) updateSlotInfo(ctx context.Context, email string, name string) error {
+	info, err := getSlotInfo(ctx, email, g.Slot())
+	if err != nil && !errors.Is(err, ErrSlotNotFound) {
+		return err
+	}
+
+	now := time.Now().UTC()
+	info.ID = g.Slot()
+	if name != "" {
+		info.Name = name
+	}
+	if info.Name == "" {
+		info.Name = "Untitled adventure"
+		if info.ID == MainSlot {
+			info.Name = mainSlotName
+		}
+	}
+	if info.CreatedAt.IsZero() {
+		info.CreatedAt = now
+	}
+	info.UpdatedAt = now
+	info.Turns = g.currentTurn()
+	info.TokensUsed = g.TotalTokensUsed
+	if g.World != nil && g.World.CurrentLocation != nil {
+		info.Location = g.World.CurrentLocation.LocationName
+	}
+	info.ContentRating = string(g.Rating())
+	info.PromptVersion = g.PromptVersion
+
+	return setSlotInfo(ctx, email, info)
+}
+
+// MainSlotInfo returns the description of a user's main slot.  A slot saved
+// before its description kept the content rating is refreshed from its game
+// first.
+func MainSlotInfo(ctx context.Context, email string) (SlotInfo, error) {
+	info, err := getSlotInfo(ctx, email, MainSlot)
+	if err == nil && info.ContentRating != "" {
+		return info, nil
+	} else if err != nil && !errors.Is(err, ErrSlotNotFound) {
+		return SlotInfo{}, err
+	}
+
+	g, err := LoadGameFromRedis(ctx, email, MainSlot)
+	if err != nil {
+		return SlotInfo{}, ErrSlotNotFound
+	}
+	if err := g.updateSlotInfo(ctx, email, ""); err != nil {
+		return SlotInfo{}, err
+	}
+	return getSlotInfo(ctx, email, MainSlot)
+}
+
+// ListSlots returns a user's save slots, the most recently played first.  A
+// game saved before there were slots is listed as the main slot.
+func ListSlots(ctx context.Context, email string) ([]SlotInfo, error) {
+	fields, err := redis.GetHash(ctx, &redis.UserSaveSlotsKey{Email: email})
+	if err != nil {
+		return nil, err
+	}
+
+	slots := []SlotInfo{}
+	for id, value := range fields {
+		var info SlotInfo
+		if err := json.Unmarshal([]byte(value), &info); err != nil {
+			log.Println("Error decoding save slot: ", err)
+			continue
+		}
+		info.ID = id
+		slots = append(slots, info)
+	}
+
+	if _, ok := fields[MainSlot]; !ok {
+		if g, err := LoadGameFromRedis(ctx, email, MainSlot); err == nil {
+			if err := g.updateSlotInfo(ctx, email, ""); err != nil {
+				return nil, err
+			}
+			info, err := getSlotInfo(ctx, email, MainSlot)
+			if err != nil {
+				return nil, err
+			}
+			slots = append(slots, info)
+		}
+	}
+
+	sort.Slice(slots, func(i, j int) bool {
+		return slots[i].UpdatedAt.After(slots[j].UpdatedAt)
+	})
+	return slots, nil
+}
+
+func validSlotName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("give the save a name")
+	}
+	if utf8.RuneCountInString(name) > maxSlotNameLength {
+		return "", fmt.Errorf("save names can be at most %d characters", maxSlotNameLength)
+	}
+	return name, nil
+}
+
+// checkSlotLimit returns an error if the user can't have another save slot.
+// It is called under lockUser.
+func checkSlotLimit(ctx context.Context, email string) error {
+	slots, err := ListSlots(ctx, email)
+	if err != nil {
+		return err
+	}
+	if len(slots) >= maxSlots {
+		return fmt.Errorf("you can keep at most %d saves, delete one first", maxSlots)
+	}
+	return nil
+}
+
+// newGameFor starts a new game in a slot.  It keeps the content rating and
+// prompt version of the game it replaces, or of the main slot's game for a
+// new slot, since those are set for the player by an admin.
+func newGameFor(ctx context.Context, email string, slot string) *Game {
+	g := InitializeNewGame()
+	g.slot = slot
+
+	oldGame, err := LoadGameFromRedis(ctx, email, slot)
+	if err != nil && normalizeSlot(slot) != MainSlot {
+		oldGame, err = LoadGameFromRedis(ctx, email, MainSlot)
+	}
+	if err == nil {
+		if oldGame.ContentRating != "" {
+			g.ContentRating = oldGame.ContentRating
+		}
+		g.PromptVersion = oldGame.PromptVersion
+	}
+	g.recordBaseline(SourceEngine, EventGameStarted, "")
+	return g
+}
+
+// CreateSlot starts a new game in a new save slot.
+func CreateSlot(ctx context.Context, email string, name string) (SlotInfo, error) {
+	name, err := validSlotName(name)
+	if err != nil {
+		return SlotInfo{}, err
+	}
+
+	// the limit is checked under the lock so another new slot can't slip in
+	if err := lockUser(ctx, email); err != nil {
+		return SlotInfo{}, err
+	}
+	defer releaseUser(email)
+
+	if err := checkSlotLimit(ctx, email); err != nil {
+		return SlotInfo{}, err
+	}
+
+	g := newGameFor(ctx, email, newID())
+	if err := g.save(ctx, email, name); err != nil {
+		return SlotInfo{}, err
+	}
+	return getSlotInfo(ctx, email, g.Slot())
+}
+
+// RenameSlot renames a save slot.
+func RenameSlot(ctx context.Context, email string, slot string, name string) error {
+	name, err := validSlotName(name)
+	if err != nil {
+		return err
+	}
+
+	info, err := getSlotInfo(ctx, email, slot)
+	if err != nil {
+		return err
+	}
+	info.Name = name
+	return setSlotInfo(ctx, email, info)
+}
+
+// DuplicateSlot copies a save slot's game and memories into a new slot, so
+// the player can try something without risking the original.
+func DuplicateSlot(ctx context.Context, email string, slot string, name string) (SlotInfo, error) {
+	name, err := validSlotName(name)
+	if err != nil {
+		return SlotInfo{}, err
+	}
+
+	// the limit is checked under the lock so another new slot can't slip in
+	if err := lockUser(ctx, email); err != nil {
+		return SlotInfo{}, err
+	}
+	defer releaseUser(email)
+
+	if err := checkSlotLimit(ctx, email); err != nil {
+		return SlotInfo{}, err
+	}
+
+	// a turn resolving in the slot would be copied half done
+	if err := lockTurn(ctx, email, slot); err != nil {
+		return SlotInfo{}, err
+	}
+	defer releaseTurn(email, slot)
+
+	g, err := LoadGameFromRedis(ctx, email, slot)
+	if err != nil {
+		return SlotInfo{}, ErrSlotNotFound
+	}
+
+	g.slot = newID()
+	g.ID = newID()
+	g.recordBaseline(SourceEngine, EventGameForked, slot)
+	if err := g.save(ctx, email, name); err != nil {
+		return SlotInfo{}, err
+	}
+	if err := memory.Copy(ctx, memoryOwner(email, slot), memoryOwner(email, g.slot)); err != nil {
+		log.Println("Error copying memories: ", err)
+	}
+	return getSlotInfo(ctx, email, g.Slot())
+}
+
+// DeleteSlot deletes a save slot along with its game and memories.
+func DeleteSlot(ctx context.Context, email string, slot string) error {
+	if normalizeSlot(slot) == MainSlot {
+		return fmt.Errorf("the main campaign can't be deleted")
+	}
+	if _, err := getSlotInfo(ctx, email, slot); err != nil {
+		return err
+	}
+
+	// a turn resolving in the slot would save it again
+	if err := lockTurn(ctx, email, slot); err != nil {
+		return err
+	}
+	defer releaseTurn(email, slot)
+
+	if err := redis.DeleteKey(ctx, saveKey(email, slot)); err != nil {
+		return err
+	}
+	if err := redis.DeleteHashField(ctx, &redis.UserSaveSlotsKey{Email: email}, slot); err != nil {
+		return err
+	}
+	clearSnapshots(ctx, email, slot)
+	if err := redis.DeleteKey(ctx, eventsKey(email, slot)); err != nil {
+		log.Println("Error deleting game events: ", err)
+	}
+	if err := redis.DeleteKey(ctx, feedbackKey(email, slot)); err != nil {
+		log.Println("Error deleting turn feedback: ", err)
+	}
+	if err := memory.Forget(ctx, memoryOwner(email, slot)); err != nil {
+		log.Println("Error forgetting memories: ", err)
+	}
+	return nil
+}
+
+// UpdateAllSlots loads, changes and saves the game in every one of a
+// user's save slots.  It returns ErrSlotNotFound if the user has no game and
+// ErrSavesBusy, changing nothing, if a turn is resolving in one of them.
+func UpdateAllSlots(ctx context.Context, email string, update func(g *Game)) error {
+	if err := lockUser(ctx, email); err != nil {
+		return err
+	}
+	defer releaseUser(email)
+
+	slots, err := ListSlots(ctx, email)
+	if err != nil {
+		return err
+	}
+	if len(slots) == 0 {
+		return ErrSlotNotFound
+	}
+
+	// a turn resolving in a slot would save its own copy of the game over
+	// the change, so every slot is locked before any is changed
+	for i, slot := range slots {
+		if err := lockTurn(ctx, email, slot.ID); err != nil {
+			for _, locked := range slots[:i] {
+				releaseTurn(email, locked.ID)
+			}
+			return ErrSavesBusy
+		}
+	}
+	defer func() {
+		for _, slot := range slots {
+			releaseTurn(email, slot.ID)
+		}
+	}()
+
+	for _, slot := range slots {
+		g, err := LoadGameFromRedis(ctx, email, slot.ID)
+		if err != nil {
+			return fmt.Errorf("error loading save slot %s: %w", slot.ID, err)
+		}
+		update(g)
+		if err := g.save(ctx, email, ""); err != nil {
+			return fmt.Errorf("error saving save slot %s: %w", slot.ID, err)
+		}
+	}
+	return nil
+}
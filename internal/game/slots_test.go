@@ -0,0 +1,128 @@
+package game
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/sessionsdev/blue-octopus/internal/redis"
+)
+
+func TestMainSlotKeepsLegacyKeys(t *testing.T) {
+	email := "player@example.com"
+	legacyKey := (&redis.UserSavedGameKey{Email: email}).GetKey()
+
+	for _, slot := range []string{"", MainSlot} {
+		if key := saveKey(email, slot).GetKey(); key != legacyKey {
+			t.Errorf("Expected slot %q to be saved at %s, but got %s", slot, legacyKey, key)
+		}
+		if owner := memoryOwner(email, slot); owner != email {
+			t.Errorf("Expected slot %q memories to belong to %s, but got %s", slot, email, owner)
+		}
+	}
+
+	if key := saveKey(email, "abc").GetKey(); key != legacyKey+":abc" {
+		t.Errorf("Expected slot abc to be saved at %s:abc, but got %s", legacyKey, key)
+	}
+	if owner := memoryOwner(email, "abc"); owner == email {
+		t.Errorf("Expected slot abc memories to be kept apart from the main slot")
+	}
+}
+
+func TestSlotFromContext(t *testing.T) {
+	if slot := slotFromContext(context.Background()); slot != MainSlot {
+		t.Errorf("Expected %s, but got %s", MainSlot, slot)
+	}
+	if slot := slotFromContext(withSlot(context.Background(), "abc")); slot != "abc" {
+		t.Errorf("Expected abc, but got %s", slot)
+	}
+}
+
+func TestSlotTreeNestsBranches(t *testing.T) {
+	slots := []SlotInfo{
+		{ID: "fight", Name: "Main campaign, turn 3", Parent: MainSlot, ParentTurn: 3},
+		{ID: "orphan", Name: "Lost, turn 1", Parent: "deleted", ParentTurn: 1},
+		{ID: MainSlot, Name: "Main campaign"},
+		{ID: "deeper", Name: "Main campaign, turn 3, turn 5", Parent: "fight", ParentTurn: 5},
+	}
+
+	roots := slotTree(slots, "deeper")
+	if len(roots) != 2 || roots[0].ID != MainSlot || roots[1].ID != "orphan" {
+		t.Fatalf("Expected the main slot and the orphaned branch as roots, but got %v", roots)
+	}
+
+	fight := roots[0].Branches
+	if len(fight) != 1 || fight[0].ID != "fight" || len(fight[0].Branches) != 1 || fight[0].Branches[0].ID != "deeper" {
+		t.Fatalf("Expected main > fight > deeper, but got %v", roots[0])
+	}
+	if !roots[0].Open || !fight[0].Open || !fight[0].Branches[0].Playing {
+		t.Errorf("Expected the played branch and its parents to be open")
+	}
+	if roots[1].Open {
+		t.Errorf("Expected the orphaned branch to be closed")
+	}
+}
+
+func TestBranchNameFitsSlotNames(t *testing.T) {
+	name := branchName("A very long adventure name that barely fits", 12)
+	if len(name) > maxSlotNameLength {
+		t.Errorf("Expected at most %d characters, but got %q", maxSlotNameLength, name)
+	}
+	if name := branchName("Main campaign", 3); name != "Main campaign, turn 3" {
+		t.Errorf("Expected Main campaign, turn 3, but got %q", name)
+	}
+
+	// names are cut between characters, not inside one
+	name = branchName(strings.Repeat("é", maxSlotNameLength), 7)
+	if !utf8.ValidString(name) || utf8.RuneCountInString(name) != maxSlotNameLength {
+		t.Errorf("Expected %d valid characters, but got %q", maxSlotNameLength, name)
+	}
+	if _, err := validSlotName(name); err != nil {
+		t.Errorf("Expected the branch name to be a valid slot name, but got %v", err)
+	}
+}
+
+func TestValidSlotNameCountsCharacters(t *testing.T) {
+	if _, err := validSlotName(strings.Repeat("ü", maxSlotNameLength)); err != nil {
+		t.Errorf("Expected %d characters to fit, but got %v", maxSlotNameLength, err)
+	}
+	if _, err := validSlotName(strings.Repeat("ü", maxSlotNameLength+1)); err == nil {
+		t.Errorf("Expected %d characters to be too long", maxSlotNameLength+1)
+	}
+}
+
+func TestUpdateAllSlotsIsBusyDuringAnotherChange(t *testing.T) {
+	useUnreachableRedis()
+	ctx := context.Background()
+
+	if err := lockUser(ctx, "admin@example.com"); err != nil {
+		t.Fatalf("Expected the user's lock to be taken, but got %v", err)
+	}
+	defer releaseUser("admin@example.com")
+
+	err := UpdateAllSlots(ctx, "admin@example.com", func(g *Game) {
+		t.Errorf("Expected no save to be changed")
+	})
+	if !errors.Is(err, ErrSavesBusy) {
+		t.Errorf("Expected %v, but got %v", ErrSavesBusy, err)
+	}
+}
+
+func TestNewSlotsWaitForTheSlotLimit(t *testing.T) {
+	useUnreachableRedis()
+	ctx := context.Background()
+
+	if err := lockUser(ctx, "brancher@example.com"); err != nil {
+		t.Fatalf("Expected the user's lock to be taken, but got %v", err)
+	}
+	defer releaseUser("brancher@example.com")
+
+	if _, err := BranchSlot(ctx, "brancher@example.com", MainSlot, 1); !errors.Is(err, ErrSavesBusy) {
+		t.Errorf("Expected branching to wait for the slot limit, but got %v", err)
+	}
+	if _, err := CreateSlot(ctx, "brancher@example.com", "Side quest"); !errors.Is(err, ErrSavesBusy) {
+		t.Errorf("Expected a new slot to wait for the slot limit, but got %v", err)
+	}
+}
@@ -0,0 +1,316 @@
+package game
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sessionsdev/blue-octopus/internal/memory"
+	"github.com/sessionsdev/blue-octopus/internal/redis"
+)
+
+const defaultSnapshotRetention = 20
+
+var errNothingToUndo = errors.New("there is nothing to undo")
+
+// snapshotRetention is how many snapshots are kept per save slot, set with
+// SNAPSHOT_RETENTION.
+func snapshotRetention() int {
+	if n, err := strconv.Atoi(os.Getenv("SNAPSHOT_RETENTION")); err == nil && n > 0 {
+		return n
+	}
+	return defaultSnapshotRetention
+}
+
+// Snapshot is the game as it was after a turn, turn 0 being the game before
+// its first recorded turn.
+type Snapshot struct {
+	Turn     int
+	Command  string
+	Location string
+	SavedAt  time.Time
+	Game     *Game
+}
+
+func snapshotsKey(email string, slot string) *redis.UserGameSnapshotsKey {
+	if normalizeSlot(slot) == MainSlot {
+		return &redis.UserGameSnapshotsKey{Email: email}
+	}
+	return &redis.UserGameSnapshotsKey{Email: email, Slot: slot}
+}
+
+// snapshotStore keeps the encoded snapshots of each save slot, oldest first.
+type snapshotStore interface {
+	// Push adds a snapshot, dropping the oldest past snapshotRetention.
+	Push(ctx context.Context, email string, slot string, value string) error
+	All(ctx context.Context, email string, slot string) ([]string, error)
+	Count(ctx context.Context, email string, slot string) (int, error)
+	// Keep drops all but the first n snapshots.
+	Keep(ctx context.Context, email string, slot string, n int) error
+	Clear(ctx context.Context, email string, slot string) error
+}
+
+// redisSnapshotStore keeps snapshots in a list per save slot.
+type redisSnapshotStore struct{}
+
+func (redisSnapshotStore) Push(ctx context.Context, email string, slot string, value string) error {
+	return redis.PushValue(ctx, snapshotsKey(email, slot), value, int64(snapshotRetention()), 0)
+}
+
+func (redisSnapshotStore) All(ctx context.Context, email string, slot string) ([]string, error) {
+	return redis.GetValues(ctx, snapshotsKey(email, slot), 0, -1)
+}
+
+func (redisSnapshotStore) Count(ctx context.Context, email string, slot string) (int, error) {
+	length, err := redis.ListLength(ctx, snapshotsKey(email, slot))
+	return int(length), err
+}
+
+func (redisSnapshotStore) Keep(ctx context.Context, email string, slot string, n int) error {
+	if n <= 0 {
+		return redis.DeleteKey(ctx, snapshotsKey(email, slot))
+	}
+	return redis.TrimValues(ctx, snapshotsKey(email, slot), 0, int64(n-1))
+}
+
+func (redisSnapshotStore) Clear(ctx context.Context, email string, slot string) error {
+	return redis.DeleteKey(ctx, snapshotsKey(email, slot))
+}
+
+// inMemorySnapshotStore keeps snapshots in the process, for tests.
+type inMemorySnapshotStore struct {
+	mu        sync.Mutex
+	snapshots map[string][]string
+}
+
+func newInMemorySnapshotStore() *inMemorySnapshotStore {
+	return &inMemorySnapshotStore{snapshots: map[string][]string{}}
+}
+
+func (s *inMemorySnapshotStore) Push(ctx context.Context, email string, slot string, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := snapshotsKey(email, slot).GetKey()
+	all := append(s.snapshots[key], value)
+	if len(all) > snapshotRetention() {
+		all = all[len(all)-snapshotRetention():]
+	}
+	s.snapshots[key] = all
+	return nil
+}
+
+func (s *inMemorySnapshotStore) All(ctx context.Context, email string, slot string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.snapshots[snapshotsKey(email, slot).GetKey()]...), nil
+}
+
+func (s *inMemorySnapshotStore) Count(ctx context.Context, email string, slot string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.snapshots[snapshotsKey(email, slot).GetKey()]), nil
+}
+
+func (s *inMemorySnapshotStore) Keep(ctx context.Context, email string, slot string, n int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := snapshotsKey(email, slot).GetKey()
+	if n < len(s.snapshots[key]) {
+		s.snapshots[key] = s.snapshots[key][:max(n, 0)]
+	}
+	return nil
+}
+
+func (s *inMemorySnapshotStore) Clear(ctx context.Context, email string, slot string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.snapshots, snapshotsKey(email, slot).GetKey())
+	return nil
+}
+
+var (
+	snapshotStorage   snapshotStore = redisSnapshotStore{}
+	snapshotStorageMu sync.RWMutex
+)
+
+// setSnapshotStore replaces the store snapshots are kept in.
+func setSnapshotStore(s snapshotStore) {
+	snapshotStorageMu.Lock()
+	defer snapshotStorageMu.Unlock()
+
+	snapshotStorage = s
+}
+
+func currentSnapshotStore() snapshotStore {
+	snapshotStorageMu.RLock()
+	defer snapshotStorageMu.RUnlock()
+
+	return snapshotStorage
+}
+
+// snapshot records the game as it is now.  Rewinding is a nice to have, so
+// failures are only logged.
+func (g *Game) snapshot(ctx context.Context, email string, command string) {
+	snapshot := Snapshot{
+		Turn:    g.currentTurn(),
+		Command: command,
+		SavedAt: time.Now().UTC(),
+		Game:    g,
+	}
+	if g.World != nil && g.World.CurrentLocation != nil {
+		snapshot.Location = g.World.CurrentLocation.LocationName
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(snapshot); err != nil {
+		log.Println("Error encoding snapshot: ", err)
+		return
+	}
+
+	if err := currentSnapshotStore().Push(ctx, email, g.slot, buf.String()); err != nil {
+		log.Println("Error saving snapshot: ", err)
+	}
+}
+
+// ensureSnapshot snapshots a game that has none yet, such as a new game or
+// one saved before there were snapshots, so its next turn can be undone.
+func (g *Game) ensureSnapshot(ctx context.Context, email string) {
+	count, err := currentSnapshotStore().Count(ctx, email, g.slot)
+	if err != nil {
+		log.Println("Error loading snapshots: ", err)
+		return
+	}
+	if count == 0 {
+		g.snapshot(ctx, email, "")
+	}
+}
+
+// clearSnapshots drops a save slot's snapshots, as when its game is
+// replaced.
+func clearSnapshots(ctx context.Context, email string, slot string) {
+	if err := currentSnapshotStore().Clear(ctx, email, slot); err != nil {
+		log.Println("Error deleting snapshots: ", err)
+	}
+}
+
+// ListSnapshots returns the snapshots of a save slot, oldest first.
+func ListSnapshots(ctx context.Context, email string, slot string) ([]Snapshot, error) {
+	values, err := currentSnapshotStore().All(ctx, email, slot)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := []Snapshot{}
+	for _, value := range values {
+		var snapshot Snapshot
+		if err := gob.NewDecoder(bytes.NewBufferString(value)).Decode(&snapshot); err != nil {
+			log.Println("Error decoding snapshot: ", err)
+			continue
+		}
+		if snapshot.Game != nil {
+			snapshot.Game.relink()
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, nil
+}
+
+// findSnapshot returns the index of the newest snapshot of a turn, -1 if
+// there is none.
+func findSnapshot(snapshots []Snapshot, turn int) int {
+	index := -1
+	for i, snapshot := range snapshots {
+		if snapshot.Turn == turn && snapshot.Game != nil {
+			index = i
+		}
+	}
+	return index
+}
+
+// Rewind restores a save slot's game to how it was after turn, discarding
+// the turns played since and their memories.  The content rating and prompt
+// version are kept, they are set for the player by an admin.
+func Rewind(ctx context.Context, email string, slot string, turn int) (*Game, error) {
+	if err := lockTurn(ctx, email, slot); err != nil {
+		return nil, err
+	}
+	defer releaseTurn(email, slot)
+
+	return rewindLocked(ctx, email, slot, turn)
+}
+
+func rewindLocked(ctx context.Context, email string, slot string, turn int) (*Game, error) {
+	current, err := LoadGameFromRedis(ctx, email, slot)
+	if err != nil {
+		return nil, ErrSlotNotFound
+	}
+
+	g, index, err := restoreSnapshot(ctx, email, current, turn)
+	if err != nil {
+		return nil, err
+	}
+	if err := g.save(ctx, email, ""); err != nil {
+		return nil, err
+	}
+
+	dropSnapshotsAfter(ctx, email, slot, index)
+	if err := memory.ForgetAfter(ctx, memoryOwner(email, slot), turn); err != nil {
+		log.Println("Error forgetting memories: ", err)
+	}
+	return g, nil
+}
+
+// restoreSnapshot returns current's game as it was after turn, along with
+// the index of the snapshot it came from.
+func restoreSnapshot(ctx context.Context, email string, current *Game, turn int) (*Game, int, error) {
+	snapshots, err := ListSnapshots(ctx, email, current.slot)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	index := findSnapshot(snapshots, turn)
+	if index < 0 {
+		return nil, 0, fmt.Errorf("turn %d is too far back to rewind to", turn)
+	}
+
+	g := snapshots[index].Game
+	g.slot = current.slot
+	g.ContentRating = current.ContentRating
+	g.PromptVersion = current.PromptVersion
+	// the tokens were spent even if the turns are discarded
+	g.TotalTokensUsed = current.TotalTokensUsed
+	g.recordBaseline(SourceEngine, EventGameRestored, strconv.Itoa(turn))
+	return g, index, nil
+}
+
+// dropSnapshotsAfter drops the snapshots of a save slot newer than index,
+// the turns they were taken at have been discarded.
+func dropSnapshotsAfter(ctx context.Context, email string, slot string, index int) {
+	if err := currentSnapshotStore().Keep(ctx, email, slot, index+1); err != nil {
+		log.Println("Error trimming snapshots: ", err)
+	}
+}
+
+// undo rewinds the game by one turn.  The caller holds the turn lock.
+func undo(ctx context.Context, email string, slot string) (*Game, error) {
+	g, err := LoadGameFromRedis(ctx, email, slot)
+	if err != nil {
+		return nil, ErrSlotNotFound
+	}
+	if g.currentTurn() == 0 {
+		return nil, errNothingToUndo
+	}
+	return rewindLocked(ctx, email, slot, g.currentTurn()-1)
+}
@@ -0,0 +1,114 @@
+package game
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// useTestSnapshots keeps snapshots in the process for the test.
+func useTestSnapshots(t *testing.T) {
+	setSnapshotStore(newInMemorySnapshotStore())
+	t.Cleanup(func() { setSnapshotStore(redisSnapshotStore{}) })
+}
+
+// playTurns plays n turns, picking up an item each turn, snapshotting the
+// game after each as finishTurn does.
+func playTurns(ctx context.Context, g *Game, email string, n int) {
+	g.ensureSnapshot(ctx, email)
+	for i := 0; i < n; i++ {
+		turn := g.currentTurn() + 1
+		command := fmt.Sprintf("take item %d", turn)
+		g.record(SourceEngine, Event{Type: EventItemsAdded, Names: []string{fmt.Sprintf("item %d", turn)}})
+		g.record(SourceNarrator, Event{Type: EventTurnPlayed, Command: command, Narrative: "Taken."})
+		g.snapshot(ctx, email, command)
+	}
+}
+
+func snapshotTurns(t *testing.T, ctx context.Context, email string, slot string) []int {
+	snapshots, err := ListSnapshots(ctx, email, slot)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	turns := []int{}
+	for _, snapshot := range snapshots {
+		turns = append(turns, snapshot.Turn)
+	}
+	return turns
+}
+
+func TestUndoRestoresThePreviousTurn(t *testing.T) {
+	useTestSnapshots(t)
+	ctx := context.Background()
+	email := "undo@example.com"
+
+	g := InitializeNewGame()
+	playTurns(ctx, g, email, 3)
+	g.ContentRating = "mature"
+	g.TotalTokensUsed = 42
+
+	restored, _, err := restoreSnapshot(ctx, email, g, g.currentTurn()-1)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if restored.currentTurn() != 2 {
+		t.Errorf("Expected turn 2, but got %d", restored.currentTurn())
+	}
+	if !restored.Player.Inventory.Contains("item 2") || restored.Player.Inventory.Contains("item 3") {
+		t.Errorf("Expected the inventory after turn 2, but got %v", restored.Player.Inventory.ToSlice())
+	}
+	if restored.ContentRating != "mature" || restored.TotalTokensUsed != 42 {
+		t.Errorf("Expected the rating and tokens to be kept, but got %s and %d", restored.ContentRating, restored.TotalTokensUsed)
+	}
+	if restored.World.CurrentLocation != restored.World.Locations["blue_house"] {
+		t.Errorf("Expected the current location to be the world's Blue House")
+	}
+}
+
+func TestRewindDropsLaterSnapshots(t *testing.T) {
+	useTestSnapshots(t)
+	ctx := context.Background()
+	email := "rewind@example.com"
+
+	g := InitializeNewGame()
+	playTurns(ctx, g, email, 5)
+
+	restored, index, err := restoreSnapshot(ctx, email, g, 2)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	dropSnapshotsAfter(ctx, email, g.slot, index)
+
+	if turns := snapshotTurns(t, ctx, email, g.slot); fmt.Sprint(turns) != "[0 1 2]" {
+		t.Errorf("Expected snapshots of turns [0 1 2], but got %v", turns)
+	}
+	if _, _, err := restoreSnapshot(ctx, email, restored, 4); err == nil {
+		t.Errorf("Expected the discarded turn 4 to be gone")
+	}
+
+	// playing on from the rewound turn snapshots the new turns
+	playTurns(ctx, restored, email, 1)
+	if turns := snapshotTurns(t, ctx, email, g.slot); fmt.Sprint(turns) != "[0 1 2 3]" {
+		t.Errorf("Expected snapshots of turns [0 1 2 3], but got %v", turns)
+	}
+}
+
+func TestSnapshotRetention(t *testing.T) {
+	useTestSnapshots(t)
+	t.Setenv("SNAPSHOT_RETENTION", "3")
+	ctx := context.Background()
+	email := "retention@example.com"
+
+	g := InitializeNewGame()
+	playTurns(ctx, g, email, 5)
+
+	if turns := snapshotTurns(t, ctx, email, g.slot); fmt.Sprint(turns) != "[3 4 5]" {
+		t.Errorf("Expected snapshots of turns [3 4 5], but got %v", turns)
+	}
+
+	_, _, err := restoreSnapshot(ctx, email, g, 1)
+	if err == nil || !strings.Contains(err.Error(), "too far back") {
+		t.Errorf("Expected turn 1 to be too far back, but got %v", err)
+	}
+}
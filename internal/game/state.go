@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/gob"
 	"log"
+	"sort"
 
 	"github.com/sessionsdev/blue-octopus/internal/redis"
 	"github.com/sessionsdev/blue-octopus/internal/util"
@@ -22,41 +23,55 @@ type GameStateUpdateResponse struct {
 	InteractiveObjectsRemoved   []string `json:"interactive_objects_removed"`
 	EnemiesIdentified           []string `json:"enemies_identified"`
 	EnemiesRemoved              []string `json:"enemies_removed"`
+	CharactersIdentified        []string `json:"characters_identified"`
 	PlayerInventoryAdded        []string `json:"player_inventory_added"`
 	PlayerInventoryRemoved      []string `json:"player_inventory_removed"`
 	StoryThreads                []string `json:"current_story_threads"`
 }
 
+// PreparedStats is what the stats panel shows of a player's game.
 type PreparedStats struct {
-	Location         string
-	PreviousLocation string
-	Inventory        []string
-	Enemies          []string
-	InteractiveItems []string
+	Location          string
+	PreviousLocation  string
+	AdjacentLocations []string
+	Inventory         []string
+	Enemies           []string
+	InteractiveItems  []string
+	StoryThreads      []string
+	Turns             int
+	TotalTokensUsed   int
 }
 
-var PreparedStatsCache *PreparedStats
+func (g *Game) prepareStats() *PreparedStats {
+	stats := &PreparedStats{
+		Location:         "Unknown Location",
+		PreviousLocation: "Unknown Location",
+		Inventory:        g.Player.Inventory.ToSlice(),
+		StoryThreads:     g.StoryThreads,
+		Turns:            g.currentTurn(),
+		TotalTokensUsed:  g.TotalTokensUsed,
+	}
 
-func (g *Game) populatePreparedStatsCache() {
-	PreparedStatsCache = &PreparedStats{}
+	if previousLocation, ok := g.World.GetLocationByName(g.World.PreviousLocationKey); ok {
+		stats.PreviousLocation = previousLocation.LocationName
+	}
 
 	location := g.World.CurrentLocation
-	if location != nil {
-		PreparedStatsCache.Location = location.LocationName
-	} else {
-		PreparedStatsCache.Location = "Unknown Location"
+	if location == nil {
+		return stats
 	}
 
-	previousLocation, _ := g.World.GetLocationByName(g.World.PreviousLocationKey)
-	if previousLocation != nil {
-		PreparedStatsCache.PreviousLocation = previousLocation.LocationName
-	} else {
-		PreparedStatsCache.PreviousLocation = "Unknown Location"
+	stats.Location = location.LocationName
+	stats.Enemies = location.Enemies.ToSlice()
+	stats.InteractiveItems = location.InteractiveItems.ToSlice()
+	for key := range location.AdjacentLocationKeys {
+		if adjacentLocation, ok := g.World.GetLocationByName(key); ok {
+			stats.AdjacentLocations = append(stats.AdjacentLocations, adjacentLocation.LocationName)
+		}
 	}
+	sort.Strings(stats.AdjacentLocations)
 
-	PreparedStatsCache.Inventory = g.Player.Inventory.ToSlice()
-	PreparedStatsCache.Enemies = g.World.CurrentLocation.Enemies.ToSlice()
-	PreparedStatsCache.InteractiveItems = g.World.CurrentLocation.InteractiveItems.ToSlice()
+	return stats
 }
 
 func (g *Game) UpdateGameHistory(userMessage GameMessage, assistantMessage GameMessage) {
@@ -64,66 +79,80 @@ func (g *Game) UpdateGameHistory(userMessage GameMessage, assistantMessage GameM
 	g.GameMessageHistory = append(g.GameMessageHistory, assistantMessage)
 }
 
+// UpdateGameState applies the state manager's update to the game.
 func (g *Game) UpdateGameState(stateUpdate GameStateUpdateResponse) {
-	g.handleLocationUpdate(stateUpdate)
+	g.handleLocationUpdate(SourceStateManager, stateUpdate)
 
 	if len(stateUpdate.PlayerInventoryAdded) > 0 {
-		g.Player.Inventory.AddAll(stateUpdate.PlayerInventoryAdded...)
+		g.record(SourceStateManager, Event{Type: EventItemsAdded, Names: stateUpdate.PlayerInventoryAdded})
 	}
 
 	if len(stateUpdate.PlayerInventoryRemoved) > 0 {
-		g.Player.Inventory.RemoveAll(stateUpdate.PlayerInventoryRemoved...)
+		g.record(SourceStateManager, Event{Type: EventItemsRemoved, Names: stateUpdate.PlayerInventoryRemoved})
 	}
 }
 
-func (g *Game) handleLocationUpdate(stateUpdate GameStateUpdateResponse) {
+func (g *Game) handleLocationUpdate(source string, stateUpdate GameStateUpdateResponse) {
 	potentialLocationName := stateUpdate.PlayerLocation
-	newOrExistingLocation := g.World.SafeAddLocation(potentialLocationName)
-	currentLocation := g.World.NextLocation(newOrExistingLocation)
+	current := g.World.CurrentLocation
+	if potentialLocationName != "" && (current == nil || current.getNormalizedName() != normalizedLocationName(potentialLocationName)) {
+		g.record(source, Event{Type: EventPlayerMoved, Location: potentialLocationName})
+	}
 
-	if len(stateUpdate.PotentialLocations) > 0 {
-		for _, adjacentLocation := range stateUpdate.PotentialLocations {
-			adjacentLocation := g.World.SafeAddLocation(adjacentLocation)
-			currentLocation.SafeAddAdjacentLocation(adjacentLocation)
-		}
+	if g.World.CurrentLocation == nil {
+		return
+	}
+	currentLocation := g.World.CurrentLocation.LocationName
 
+	if len(stateUpdate.PotentialLocations) > 0 {
+		g.record(source, Event{Type: EventPathsFound, Location: currentLocation, Names: stateUpdate.PotentialLocations})
 	}
 
 	if len(stateUpdate.InteactiveObjectsIdentified) > 0 {
-		currentLocation.InteractiveItems.AddAll(stateUpdate.InteactiveObjectsIdentified...)
+		g.record(source, Event{Type: EventObjectsFound, Location: currentLocation, Names: stateUpdate.InteactiveObjectsIdentified})
 	}
 
 	if len(stateUpdate.InteractiveObjectsRemoved) > 0 {
-		currentLocation.InteractiveItems.RemoveAll(stateUpdate.InteractiveObjectsRemoved...)
+		g.record(source, Event{Type: EventObjectsRemoved, Location: currentLocation, Names: stateUpdate.InteractiveObjectsRemoved})
 	}
 
 	if len(stateUpdate.EnemiesIdentified) > 0 {
-		currentLocation.Enemies.AddAll(stateUpdate.EnemiesIdentified...)
+		g.record(source, Event{Type: EventEnemiesSpawned, Location: currentLocation, Names: stateUpdate.EnemiesIdentified})
 	}
 
 	if len(stateUpdate.EnemiesRemoved) > 0 {
-		currentLocation.Enemies.RemoveAll(stateUpdate.EnemiesRemoved...)
+		g.record(source, Event{Type: EventEnemiesRemoved, Location: currentLocation, Names: stateUpdate.EnemiesRemoved})
 	}
-
 }
 
+// SaveGameToRedis saves the game in the save slot it was loaded from.
 func SaveGameToRedis(ctx context.Context, g *Game, email string) {
-	key := &redis.UserSavedGameKey{Email: email}
-
-	err := redis.SetObj(ctx, key, g, 0)
-	if err != nil {
+	if err := g.save(ctx, email, ""); err != nil {
 		log.Printf("Error saving game to redis for user: %s", email)
 	}
 }
 
-func LoadGameFromRedis(ctx context.Context, email string) (*Game, error) {
-	key := &redis.UserSavedGameKey{Email: email}
+// save saves the game and refreshes its slot's description, renaming the
+// slot if name isn't empty.
+func (g *Game) save(ctx context.Context, email string, name string) error {
+	err := redis.SetObj(ctx, saveKey(email, g.slot), g, 0)
+	if err != nil {
+		return err
+	}
+	g.flushEvents(ctx, email)
+	return g.updateSlotInfo(ctx, email, name)
+}
 
+// LoadGameFromRedis loads the game in one of a user's save slots, the main
+// slot if slot is empty.
+func LoadGameFromRedis(ctx context.Context, email string, slot string) (*Game, error) {
 	var game Game
-	_, err := redis.GetObj(ctx, key, &game)
+	_, err := redis.GetObj(ctx, saveKey(email, slot), &game)
 	if err != nil {
 		return nil, err
 	}
 
+	game.relink()
+	game.slot = normalizeSlot(slot)
 	return &game, nil
 }
@@ -1,6 +1,8 @@
 package game
 
 import (
+	"html/template"
+	"strings"
 	"testing"
 )
 
@@ -49,3 +51,34 @@ func TestUpdateGameState(t *testing.T) {
 
 	testGame.UpdateGameState(stateUpdate)
 }
+
+func TestPrepareStatsRendersPlayersGame(t *testing.T) {
+	g := BuildNewGame(NewGameDetails{
+		StartingLocation:          "Lighthouse Entrance",
+		PlayerName:                "Test Player",
+		PlayerInventory:           []string{"map"},
+		StartingAdjacentLocations: []string{"Rocky Shore", "Cliff Path"},
+	})
+	g.World.CurrentLocation.InteractiveItems.AddAll("rusty lantern")
+	g.StoryThreads = []string{"Find the keeper"}
+	g.TotalTokensUsed = 1200
+
+	stats := g.prepareStats()
+	if strings.Join(stats.AdjacentLocations, ",") != "Cliff Path,Rocky Shore" {
+		t.Errorf("Expected the adjacent locations in order, but got %v", stats.AdjacentLocations)
+	}
+
+	tmpl, err := template.ParseFiles("../../templates/stats-panel.html")
+	if err != nil {
+		t.Fatalf("Expected the stats panel to parse, but got %v", err)
+	}
+	var panel strings.Builder
+	if err := tmpl.ExecuteTemplate(&panel, "stats-panel", stats); err != nil {
+		t.Fatalf("Expected the stats panel to render, but got %v", err)
+	}
+	for _, want := range []string{"Lighthouse Entrance", "Rocky Shore", "rusty lantern", "Find the keeper", "1200 tokens used"} {
+		if !strings.Contains(panel.String(), want) {
+			t.Errorf("Expected the panel to show %q, but got %s", want, panel.String())
+		}
+	}
+}
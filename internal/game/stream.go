@@ -0,0 +1,88 @@
+package game
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sessionsdev/blue-octopus/internal/aiapi"
+	"github.com/sessionsdev/blue-octopus/internal/auth"
+)
+
+// retryStatus tells the player their command is being retried.
+const retryStatus = "The Game Master is busy, retrying…"
+
+// HandleGameCommandStream processes a command and streams the narrative back
+// as server sent events.  "token" events carry pieces of the narrative as they
+// are generated and "status" events report retries.  A "turn" event gives the
+// number of the turn played, for voting on it.  A single "done" event with the
+// full text, or a "game-error" event if the turn failed, ends the stream.
+// Closing the connection cancels the narrator call.
+func HandleGameCommandStream(w http.ResponseWriter, r *http.Request) {
+	// Only GET requests are allowed, EventSource cannot POST
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	command := r.URL.Query().Get("command")
+	if command == "" {
+		http.Error(w, "Missing command", http.StatusBadRequest)
+		return
+	}
+
+	userValue := r.Context().Value("user")
+	if userValue == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	user := userValue.(*auth.User)
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// let the player know when the game master is busy and we are retrying
+	ctx := aiapi.WithRetryNotifier(withSlot(r.Context(), auth.GetSessionSlot(r)), func(attempt int, err *aiapi.APIError, wait time.Duration) {
+		writeEvent(w, flusher, "status", retryStatus)
+	})
+	ctx = withTurnNotifier(ctx, func(turn int) {
+		writeEvent(w, flusher, "turn", strconv.Itoa(turn))
+	})
+
+	resultMsg, err := ProcessGameCommandStream(ctx, command, user.Email, func(chunk string) {
+		writeEvent(w, flusher, "token", chunk)
+	})
+	if err != nil {
+		log.Println("Error processing streamed command: ", err)
+		writeEvent(w, flusher, "game-error", resultMsg)
+		return
+	}
+
+	writeEvent(w, flusher, "done", resultMsg)
+}
+
+// writeEvent writes a single server sent event.  The data is JSON encoded so
+// newlines in the narrative survive the event framing.
+func writeEvent(w http.ResponseWriter, flusher http.Flusher, event string, data string) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Println("Error encoding event: ", err)
+		return
+	}
+
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+	flusher.Flush()
+}
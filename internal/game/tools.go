@@ -0,0 +1,432 @@
+package game
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sessionsdev/blue-octopus/internal/aiapi"
+	"github.com/sessionsdev/blue-octopus/internal/util"
+)
+
+// maxToolRounds is how many times the narrator can call tools in a turn
+// before it has to finish the narrative.
+const maxToolRounds = 4
+
+// narratorToolsEnabled reports whether the narrator is offered the engine
+// tools, on unless NARRATOR_TOOLS is off.
+func narratorToolsEnabled() bool {
+	return os.Getenv("NARRATOR_TOOLS") != "off"
+}
+
+// alwaysReconcile reports whether the state manager still reconciles turns
+// the narrator played with tools, set with RECONCILE_STATE=always.  By
+// default only turns played without tools are reconciled.
+func alwaysReconcile() bool {
+	return os.Getenv("RECONCILE_STATE") == "always"
+}
+
+type movePlayerArgs struct {
+	Location           string   `json:"location"`
+	ConnectedLocations []string `json:"connected_locations"`
+}
+
+type itemArgs struct {
+	Item string `json:"item"`
+}
+
+type enemyArgs struct {
+	Enemy string `json:"enemy"`
+}
+
+type rollDiceArgs struct {
+	Count int `json:"count"`
+	Sides int `json:"sides"`
+}
+
+type storyThreadArgs struct {
+	Thread string `json:"thread"`
+}
+
+var narratorTools = []aiapi.Tool{
+	{
+		Name:        "move_player",
+		Description: "Move the player to a location, adding it to the map if it is new. connected_locations are places that can be reached from it.",
+		Parameters:  aiapi.SchemaFor("move_player", movePlayerArgs{}).Schema,
+	},
+	{
+		Name:        "add_item",
+		Description: "Put an item the player picked up or was given in their inventory.",
+		Parameters:  aiapi.SchemaFor("add_item", itemArgs{}).Schema,
+	},
+	{
+		Name:        "remove_item",
+		Description: "Take an item the player used up, dropped or gave away out of their inventory. Fails if they don't have it.",
+		Parameters:  aiapi.SchemaFor("remove_item", itemArgs{}).Schema,
+	},
+	{
+		Name:        "spawn_enemy",
+		Description: "Place an enemy at the player's location.",
+		Parameters:  aiapi.SchemaFor("spawn_enemy", enemyArgs{}).Schema,
+	},
+	{
+		Name:        "defeat_enemy",
+		Description: "Remove an enemy that was defeated or fled from the player's location. Fails if it isn't there.",
+		Parameters:  aiapi.SchemaFor("defeat_enemy", enemyArgs{}).Schema,
+	},
+	{
+		Name:        "roll_dice",
+		Description: "Roll dice to decide a risky action. Returns each roll and the total.",
+		Parameters:  aiapi.SchemaFor("roll_dice", rollDiceArgs{}).Schema,
+	},
+	{
+		Name:        "add_story_thread",
+		Description: "Record a new quest, mystery or goal the player has taken on.",
+		Parameters:  aiapi.SchemaFor("add_story_thread", storyThreadArgs{}).Schema,
+	},
+}
+
+// rollDie rolls a die with the given number of sides.
+var rollDie = func(sides int) int {
+	return rand.IntN(sides) + 1
+}
+
+// turnTools runs the narrator's tool calls against the game during a turn.
+// The changes are applied as they are made and collected in update, in the
+// shape the state manager would have produced them.
+type turnTools struct {
+	g      *Game
+	update GameStateUpdateResponse
+
+	// where the player was before the turn, to undo a move the narrative
+	// doesn't back up
+	startLocation    *Location
+	startPreviousKey string
+
+	// items add_item put in the inventory, leaving out those the player
+	// already held, so undoing an add doesn't take away the player's own
+	added util.StringSet
+}
+
+func newTurnTools(g *Game) *turnTools {
+	return &turnTools{
+		g:                g,
+		startLocation:    g.World.CurrentLocation,
+		startPreviousKey: g.World.PreviousLocationKey,
+		added:            util.EmptyStringSet(),
+	}
+}
+
+// call runs a tool call and returns the result to send back to the model.
+// A call that can't be carried out is reported to the model as an error
+// rather than failing the turn.
+func (t *turnTools) call(call aiapi.ToolCall) string {
+	result, err := t.run(call)
+	if err != nil {
+		log.Printf("Tool call %s(%s) failed: %v", call.Name, call.Arguments, err)
+		result = map[string]interface{}{"error": err.Error()}
+	} else {
+		log.Printf("Tool call %s(%s)", call.Name, call.Arguments)
+	}
+
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		return `{"error":"the result could not be encoded"}`
+	}
+	return string(encoded)
+}
+
+func (t *turnTools) run(call aiapi.ToolCall) (map[string]interface{}, error) {
+	switch call.Name {
+	case "move_player":
+		var args movePlayerArgs
+		if err := decodeToolArgs(call, &args); err != nil {
+			return nil, err
+		}
+		return t.movePlayer(args)
+	case "add_item", "remove_item":
+		var args itemArgs
+		if err := decodeToolArgs(call, &args); err != nil {
+			return nil, err
+		}
+		if call.Name == "add_item" {
+			return t.addItem(args.Item)
+		}
+		return t.removeItem(args.Item)
+	case "spawn_enemy", "defeat_enemy":
+		var args enemyArgs
+		if err := decodeToolArgs(call, &args); err != nil {
+			return nil, err
+		}
+		if call.Name == "spawn_enemy" {
+			return t.spawnEnemy(args.Enemy)
+		}
+		return t.defeatEnemy(args.Enemy)
+	case "roll_dice":
+		var args rollDiceArgs
+		if err := decodeToolArgs(call, &args); err != nil {
+			return nil, err
+		}
+		return t.rollDice(args)
+	case "add_story_thread":
+		var args storyThreadArgs
+		if err := decodeToolArgs(call, &args); err != nil {
+			return nil, err
+		}
+		return t.addStoryThread(args.Thread)
+	default:
+		return nil, fmt.Errorf("there is no tool called %s", call.Name)
+	}
+}
+
+func decodeToolArgs(call aiapi.ToolCall, args interface{}) error {
+	if err := json.Unmarshal([]byte(call.Arguments), args); err != nil {
+		return fmt.Errorf("the arguments are not valid JSON: %v", err)
+	}
+	return nil
+}
+
+func (t *turnTools) movePlayer(args movePlayerArgs) (map[string]interface{}, error) {
+	location := strings.TrimSpace(args.Location)
+	if location == "" {
+		return nil, fmt.Errorf("a location is required")
+	}
+
+	move := GameStateUpdateResponse{PlayerLocation: location, PotentialLocations: args.ConnectedLocations}
+	t.g.handleLocationUpdate(SourceNarrator, move)
+	t.update.PlayerLocation = location
+	t.update.PotentialLocations = append(t.update.PotentialLocations, args.ConnectedLocations...)
+
+	var connected []string
+	for key := range t.g.World.CurrentLocation.AdjacentLocationKeys {
+		if adjacent, ok := t.g.World.GetLocationByName(key); ok {
+			connected = append(connected, adjacent.LocationName)
+		}
+	}
+	sort.Strings(connected)
+	return map[string]interface{}{"location": t.g.World.CurrentLocation.LocationName, "connected_locations": connected}, nil
+}
+
+func (t *turnTools) addItem(item string) (map[string]interface{}, error) {
+	item = strings.TrimSpace(item)
+	if item == "" {
+		return nil, fmt.Errorf("an item is required")
+	}
+
+	if !t.g.Player.Inventory.Contains(item) {
+		t.added.AddAll(item)
+	}
+	t.g.record(SourceNarrator, Event{Type: EventItemsAdded, Names: []string{item}})
+	t.update.PlayerInventoryAdded = append(t.update.PlayerInventoryAdded, item)
+	return map[string]interface{}{"inventory": t.g.Player.Inventory.ToSlice()}, nil
+}
+
+func (t *turnTools) removeItem(item string) (map[string]interface{}, error) {
+	held, ok := findInSet(t.g.Player.Inventory, item)
+	if !ok {
+		return nil, fmt.Errorf("the player doesn't have %s", item)
+	}
+
+	t.g.record(SourceNarrator, Event{Type: EventItemsRemoved, Names: []string{held}})
+	t.added.RemoveAll(held)
+	t.update.PlayerInventoryRemoved = append(t.update.PlayerInventoryRemoved, held)
+	return map[string]interface{}{"inventory": t.g.Player.Inventory.ToSlice()}, nil
+}
+
+func (t *turnTools) spawnEnemy(enemy string) (map[string]interface{}, error) {
+	enemy = strings.TrimSpace(enemy)
+	if enemy == "" {
+		return nil, fmt.Errorf("an enemy is required")
+	}
+
+	t.g.record(SourceNarrator, Event{Type: EventEnemiesSpawned, Location: t.g.World.CurrentLocation.LocationName, Names: []string{enemy}})
+	t.update.EnemiesIdentified = append(t.update.EnemiesIdentified, enemy)
+	return map[string]interface{}{"enemies": t.g.World.CurrentLocation.Enemies.ToSlice()}, nil
+}
+
+func (t *turnTools) defeatEnemy(enemy string) (map[string]interface{}, error) {
+	present, ok := findInSet(t.g.World.CurrentLocation.Enemies, enemy)
+	if !ok {
+		return nil, fmt.Errorf("there is no %s at %s", enemy, t.g.World.CurrentLocation.LocationName)
+	}
+
+	t.g.record(SourceNarrator, Event{Type: EventEnemiesRemoved, Location: t.g.World.CurrentLocation.LocationName, Names: []string{present}})
+	t.update.EnemiesRemoved = append(t.update.EnemiesRemoved, present)
+	return map[string]interface{}{"enemies": t.g.World.CurrentLocation.Enemies.ToSlice()}, nil
+}
+
+func (t *turnTools) rollDice(args rollDiceArgs) (map[string]interface{}, error) {
+	if args.Count < 1 || args.Count > 10 {
+		return nil, fmt.Errorf("count must be between 1 and 10")
+	}
+	if args.Sides < 2 || args.Sides > 100 {
+		return nil, fmt.Errorf("sides must be between 2 and 100")
+	}
+
+	rolls := make([]int, args.Count)
+	total := 0
+	for i := range rolls {
+		rolls[i] = rollDie(args.Sides)
+		total += rolls[i]
+	}
+	return map[string]interface{}{"rolls": rolls, "total": total}, nil
+}
+
+func (t *turnTools) addStoryThread(thread string) (map[string]interface{}, error) {
+	thread = strings.TrimSpace(thread)
+	if thread == "" {
+		return nil, fmt.Errorf("a thread is required")
+	}
+
+	t.g.record(SourceNarrator, Event{Type: EventStoryThreadAdded, Names: []string{thread}})
+	t.update.StoryThreads = append(t.update.StoryThreads, thread)
+	return map[string]interface{}{"story_threads": t.g.StoryThreads}, nil
+}
+
+// findInSet finds name in the set ignoring case, returning it as stored.
+func findInSet(set util.StringSet, name string) (string, bool) {
+	name = strings.TrimSpace(name)
+	if set.Contains(name) {
+		return name, true
+	}
+	for element := range set {
+		if strings.EqualFold(element, name) {
+			return element, true
+		}
+	}
+	return "", false
+}
+
+// validate undoes the items and move the narrative doesn't mention, as the
+// state manager's updates are checked, so a command can't talk the narrator
+// into calling tools the story never backs up.
+func (t *turnTools) validate(ctx context.Context, username string, command string, narrative string) {
+	narrativeWords := wordSet(narrative)
+
+	justified := []string{}
+	for _, item := range t.update.PlayerInventoryAdded {
+		if mentions(narrativeWords, item) {
+			justified = append(justified, item)
+			continue
+		}
+		recordRejection(ctx, t.g, username, "state_change", command, "inventory", "narrative doesn't mention "+strconv.Quote(item))
+		if t.added.Contains(item) {
+			t.g.record(SourceEngine, Event{Type: EventItemsRemoved, Names: []string{item}})
+			t.added.RemoveAll(item)
+		}
+	}
+	t.update.PlayerInventoryAdded = justified
+
+	location := t.g.World.CurrentLocation
+	if t.startLocation != nil && location != t.startLocation && !mentions(narrativeWords, location.LocationName) {
+		recordRejection(ctx, t.g, username, "state_change", command, "location", "narrative doesn't mention "+strconv.Quote(location.LocationName))
+		t.g.record(SourceEngine, Event{Type: EventMoveReverted, Location: t.startLocation.LocationName, PreviousLocation: t.startPreviousKey})
+		t.update.PlayerLocation = ""
+	}
+}
+
+// merge adds what the state manager noticed in a reconciled turn to the
+// tools' changes, for remembering the turn.
+func (t *turnTools) merge(update *GameStateUpdateResponse) *GameStateUpdateResponse {
+	merged := t.update
+	if update != nil {
+		merged.CharactersIdentified = append(merged.CharactersIdentified, update.CharactersIdentified...)
+		merged.EnemiesIdentified = append(merged.EnemiesIdentified, update.EnemiesIdentified...)
+		merged.InteactiveObjectsIdentified = append(merged.InteactiveObjectsIdentified, update.InteactiveObjectsIdentified...)
+	}
+	return &merged
+}
+
+// callClientWithTools plays the narrator with the engine tools, running
+// the calls it makes and sending back their results until it finishes the
+// narrative.  Text written alongside tool calls is part of the narrative.
+// If the narrator is still calling tools after maxToolRounds without having
+// written anything, it is asked once more with tool calls forbidden.  The
+// returned response holds the whole narrative and the usage of every
+// call.
+func callClientWithTools(ctx context.Context, role string, messages []GameMessage, tools *turnTools, onChunk func(string)) (aiapi.ChatResponse, error) {
+	client, err := clientFor(ctx, role)
+	if err != nil {
+		return nil, err
+	}
+	toolClient, ok := client.(aiapi.ToolAIClient)
+	if !ok {
+		return nil, fmt.Errorf("the %s client doesn't support tools", role)
+	}
+
+	var narrative strings.Builder
+	total := &aiapi.AiChatResponse{}
+	aiMessages := toAiMessages(messages)
+
+	for round := 0; ; round++ {
+		// separate the text of each round, as it arrives
+		var chunked func(string)
+		if onChunk != nil {
+			separated := narrative.Len() == 0
+			chunked = func(chunk string) {
+				if !separated {
+					onChunk("\n\n")
+					separated = true
+				}
+				onChunk(chunk)
+			}
+		}
+
+		// past its tool rounds the narrator can only write
+		roundCtx := ctx
+		if round > maxToolRounds {
+			roundCtx = aiapi.WithoutToolCalls(ctx)
+		}
+
+		start := time.Now()
+		response, calls, err := toolClient.DoToolRequest(roundCtx, aiMessages, narratorTools, chunked)
+		if err != nil {
+			return nil, err
+		}
+		recordCall(ctx, role, start, response)
+
+		usage := response.GetUsage()
+		total.TokensUsed += response.GetTokenUsage()
+		total.Usage.PromptTokens += usage.PromptTokens
+		total.Usage.CompletionTokens += usage.CompletionTokens
+		total.Usage.TotalTokens += usage.TotalTokens
+		total.Model = response.GetModel()
+
+		if text := strings.TrimSpace(response.GetChatCompletion()); text != "" {
+			if narrative.Len() > 0 {
+				narrative.WriteString("\n\n")
+			}
+			narrative.WriteString(text)
+		}
+
+		if len(calls) == 0 {
+			break
+		}
+		if round >= maxToolRounds {
+			log.Printf("The %s was still calling tools after %d rounds, ignoring %d calls", role, maxToolRounds, len(calls))
+			if narrative.Len() > 0 || round > maxToolRounds {
+				break
+			}
+			// ask once more for the narrative, without the ignored calls
+			continue
+		}
+
+		aiMessages = append(aiMessages, aiapi.AiMessage{Provider: "assistant", Message: response.GetChatCompletion(), ToolCalls: calls})
+		for _, call := range calls {
+			aiMessages = append(aiMessages, aiapi.ToolResultMessage(call, tools.call(call)))
+		}
+	}
+
+	if narrative.Len() == 0 {
+		return nil, fmt.Errorf("the %s called tools but wrote no narrative", role)
+	}
+	total.Completion = narrative.String()
+	return total, nil
+}
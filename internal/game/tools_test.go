@@ -0,0 +1,78 @@
+package game
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sessionsdev/blue-octopus/internal/aiapi"
+)
+
+func TestValidateOnlyRevertsItemsTheToolsAdded(t *testing.T) {
+	useUnreachableRedis()
+
+	g := InitializeNewGame()
+	g.Player.Inventory.AddAll("Rusty Sword")
+
+	tools := newTurnTools(g)
+	if _, err := tools.addItem("Rusty Sword"); err != nil {
+		t.Fatalf("Expected add_item to succeed, but got %v", err)
+	}
+	if _, err := tools.addItem("Golden Crown"); err != nil {
+		t.Fatalf("Expected add_item to succeed, but got %v", err)
+	}
+
+	tools.validate(context.Background(), "tools@example.com", "look around", "You look around the quiet house.")
+
+	if !g.Player.Inventory.Contains("Rusty Sword") {
+		t.Errorf("Expected the sword the player already held to be kept, but got %v", g.Player.Inventory.ToSlice())
+	}
+	if g.Player.Inventory.Contains("Golden Crown") {
+		t.Errorf("Expected the unmentioned crown to be removed, but got %v", g.Player.Inventory.ToSlice())
+	}
+	if len(tools.update.PlayerInventoryAdded) != 0 {
+		t.Errorf("Expected no justified items, but got %v", tools.update.PlayerInventoryAdded)
+	}
+}
+
+func TestNarratorWritesOnceOutOfToolRounds(t *testing.T) {
+	var requests []aiapi.ChatRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request aiapi.ChatRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		requests = append(requests, request)
+		if request.ToolChoice == "none" {
+			w.Write([]byte(`{"choices": [{"message": {"role": "assistant", "content": "The dice settle at last."}}], "usage": {"total_tokens": 5}}`))
+			return
+		}
+		w.Write([]byte(`{"choices": [{"message": {"role": "assistant", "tool_calls": [{"id": "call_1", "type": "function", "function": {"name": "roll_dice", "arguments": "{\"sides\": 20}"}}]}}], "usage": {"total_tokens": 3}}`))
+	}))
+	defer server.Close()
+
+	t.Setenv("AI_CASSETTE_MODE", "")
+	t.Setenv("OPENAI_BASE_URL", server.URL)
+	t.Setenv("OPENAI_API_KEY", "test")
+	narrator := aiapi.RoleConfig{Provider: aiapi.ProviderOpenAI, Model: "gpt-4o-mini", Temperature: 0.7, ResponseFormat: "text"}
+	if err := aiapi.ApplyModelConfig(&aiapi.ModelConfig{Roles: map[string]aiapi.RoleConfig{aiapi.RoleNarrator: narrator}}); err != nil {
+		t.Fatalf("Expected the clients to build, but got %v", err)
+	}
+	useUnreachableRedis()
+
+	tools := newTurnTools(BuildNewGame(NewGameDetails{StartingLocation: "Cave Mouth", PlayerName: "Test Player"}))
+	response, err := callClientWithTools(context.Background(), aiapi.RoleNarrator, []GameMessage{{Provider: "user", Message: "roll"}}, tools, nil)
+	if err != nil {
+		t.Fatalf("Expected the narrative, but got %v", err)
+	}
+
+	if response.GetChatCompletion() != "The dice settle at last." {
+		t.Errorf("Expected the narrative of the last call, but got %q", response.GetChatCompletion())
+	}
+	if len(requests) != maxToolRounds+2 {
+		t.Fatalf("Expected %d tool rounds and one more call, but got %d calls", maxToolRounds+1, len(requests))
+	}
+	if last := requests[len(requests)-1]; last.ToolChoice != "none" || len(last.Tools) == 0 {
+		t.Errorf("Expected the last call to offer the tools but forbid them, but got %q with %d tools", last.ToolChoice, len(last.Tools))
+	}
+}
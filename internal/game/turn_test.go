@@ -0,0 +1,266 @@
+package game
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/sessionsdev/blue-octopus/internal/aiapi"
+	"github.com/sessionsdev/blue-octopus/internal/memory"
+	"github.com/sessionsdev/blue-octopus/internal/moderation"
+	"github.com/sessionsdev/blue-octopus/internal/prompts"
+	"github.com/sessionsdev/blue-octopus/internal/redis"
+)
+
+// useCassette points the ai clients at a cassette.  The cassettes replay by
+// default; to record one again, delete it and run the test with
+// AI_CASSETTE_MODE=record and a real OPENAI_API_KEY.
+func useCassette(t *testing.T, path string) {
+	mode := os.Getenv("AI_CASSETTE_MODE")
+	if mode == "" {
+		mode = aiapi.CassetteReplay
+	}
+	t.Setenv("AI_CASSETTE_MODE", mode)
+	t.Setenv("AI_CASSETTE", path)
+
+	roles := map[string]aiapi.RoleConfig{}
+	for _, role := range []string{aiapi.RoleNarrator, aiapi.RoleStateManager, aiapi.RoleStorySummarizer, aiapi.RoleProgressiveSummarizer} {
+		roles[role] = aiapi.RoleConfig{Provider: aiapi.ProviderOpenAI, Model: "gpt-4o-mini", Temperature: 0.7, ResponseFormat: "json_object"}
+	}
+	narrator := roles[aiapi.RoleNarrator]
+	narrator.ResponseFormat = "text"
+	roles[aiapi.RoleNarrator] = narrator
+
+	if err := aiapi.ApplyModelConfig(&aiapi.ModelConfig{Roles: roles}); err != nil {
+		t.Fatalf("Expected the cassette clients to build, but got %v", err)
+	}
+
+	aiapi.SetEmbedder(aiapi.HashEmbedder{})
+	memory.SetStore(memory.NewInMemoryStore())
+	usePrompts(t)
+
+	useUnreachableRedis()
+}
+
+// usePrompts loads the prompts shipped in the repository.
+func usePrompts(t *testing.T) {
+	library, err := prompts.Load("../../prompts", "")
+	if err != nil {
+		t.Fatalf("Expected the prompts to load, but got %v", err)
+	}
+	prompts.SetLibrary(library)
+}
+
+// useUnreachableRedis makes saves and usage counters fail fast instead of
+// needing a redis server.
+func useUnreachableRedis() {
+	if redis.Client == nil {
+		redis.Client = goredis.NewClient(&goredis.Options{Addr: "127.0.0.1:1", MaxRetries: -1, DialTimeout: 100 * time.Millisecond})
+	}
+}
+
+// waitForTurn waits for the background work of a turn to finish.
+func waitForTurn(t *testing.T, username string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		activeTurnsMu.Lock()
+		_, active := activeTurns[turnOwner(username, MainSlot)]
+		activeTurnsMu.Unlock()
+		if !active {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Expected the turn for %s to finish", username)
+}
+
+func TestPlayTurnFromCassette(t *testing.T) {
+	useCassette(t, "testdata/cassettes/turn.json")
+	t.Setenv("NARRATOR_TOOLS", "off")
+
+	g := BuildNewGame(NewGameDetails{
+		StartingLocation:          "Lighthouse Entrance",
+		PlayerName:                "Test Player",
+		PlayerInventory:           []string{"map", "matches"},
+		StartingAdjacentLocations: []string{"Rocky Shore"},
+	})
+
+	username := "cassette@example.com"
+	turn := 0
+	ctx := withTurnNotifier(context.Background(), func(played int) { turn = played })
+	narrative, err := g.processPlayerPrompt(ctx, "open the lighthouse door", username)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if narrative == "" {
+		t.Errorf("Expected a narrative, but got an empty response")
+	}
+	if turn != 1 {
+		t.Errorf("Expected turn 1 to be played, but got %d", turn)
+	}
+
+	waitForTurn(t, username)
+
+	if g.World.CurrentLocation.LocationName != "Lighthouse Interior" {
+		t.Errorf("Expected current location to be 'Lighthouse Interior', but got %s", g.World.CurrentLocation.LocationName)
+	}
+	if !g.World.CurrentLocation.InteractiveItems.Contains("rusty lantern") {
+		t.Errorf("Expected the rusty lantern to be identified, but got %v", g.World.CurrentLocation.InteractiveItems.ToSlice())
+	}
+	if len(g.StoryThreads) != 1 {
+		t.Errorf("Expected 1 story thread, but got %d", len(g.StoryThreads))
+	}
+	if len(g.GameMessageHistory) != 2 {
+		t.Errorf("Expected 2 history messages, but got %d", len(g.GameMessageHistory))
+	}
+
+	recollections, err := memory.Recall(context.Background(), username, "talk to Old Tom", 3, nil)
+	if err != nil || len(recollections) == 0 || recollections[0].Subject != "Old Tom" {
+		t.Errorf("Expected Old Tom to be remembered, but got %v, %v", recollections, err)
+	}
+}
+
+func TestPlayTurnWithToolsFromCassette(t *testing.T) {
+	useCassette(t, "testdata/cassettes/tools.json")
+	// the roll is sent back to the model, so it has to match the recording
+	defer func(roll func(int) int) { rollDie = roll }(rollDie)
+	rollDie = func(sides int) int { return 17 }
+
+	g := BuildNewGame(NewGameDetails{
+		StartingLocation:          "Lighthouse Entrance",
+		PlayerName:                "Test Player",
+		PlayerInventory:           []string{"map", "matches"},
+		StartingAdjacentLocations: []string{"Rocky Shore"},
+	})
+
+	username := "tools@example.com"
+	narrative, err := g.processPlayerPrompt(context.Background(), "take the lantern and go inside", username)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if narrative == "" {
+		t.Errorf("Expected a narrative, but got an empty response")
+	}
+
+	waitForTurn(t, username)
+
+	if g.World.CurrentLocation.LocationName != "Lighthouse Interior" {
+		t.Errorf("Expected move_player to move the player to 'Lighthouse Interior', but got %s", g.World.CurrentLocation.LocationName)
+	}
+	if _, ok := g.World.GetLocationByName("Lighthouse Stairs"); !ok {
+		t.Errorf("Expected the connected location to be added to the map")
+	}
+	if !g.Player.Inventory.Contains("rusty lantern") {
+		t.Errorf("Expected add_item to add the rusty lantern, but got %v", g.Player.Inventory.ToSlice())
+	}
+	if len(g.Player.Inventory) != 3 {
+		t.Errorf("Expected the failed remove_item to leave the inventory alone, but got %v", g.Player.Inventory.ToSlice())
+	}
+	if len(g.StoryThreads) != 1 {
+		t.Errorf("Expected 1 story thread, but got %d", len(g.StoryThreads))
+	}
+}
+
+func TestBlockedStreamedNarrativeIsNotShown(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range []string{"You enter the cave. ", "A dragon sleeps ", "on a pile of gore", " and bones. ", "It does not stir."} {
+			data, _ := json.Marshal(map[string]interface{}{"choices": []interface{}{map[string]interface{}{"delta": map[string]string{"content": chunk}}}})
+			w.Write([]byte("data: " + string(data) + "\n\n"))
+			w.(http.Flusher).Flush()
+		}
+		w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	defer server.Close()
+
+	t.Setenv("AI_CASSETTE_MODE", "")
+	t.Setenv("OPENAI_BASE_URL", server.URL)
+	t.Setenv("OPENAI_API_KEY", "test")
+	t.Setenv("NARRATOR_TOOLS", "off")
+	narrator := aiapi.RoleConfig{Provider: aiapi.ProviderOpenAI, Model: "gpt-4o-mini", Temperature: 0.7, ResponseFormat: "text"}
+	if err := aiapi.ApplyModelConfig(&aiapi.ModelConfig{Roles: map[string]aiapi.RoleConfig{aiapi.RoleNarrator: narrator}}); err != nil {
+		t.Fatalf("Expected the clients to build, but got %v", err)
+	}
+	aiapi.SetEmbedder(aiapi.HashEmbedder{})
+	memory.SetStore(memory.NewInMemoryStore())
+	usePrompts(t)
+	useUnreachableRedis()
+
+	rules, err := moderation.NewRuleModerator(moderation.DefaultRules)
+	if err != nil {
+		t.Fatalf("Expected the default rules to compile, but got %v", err)
+	}
+	moderation.SetModerator(rules)
+
+	g := BuildNewGame(NewGameDetails{StartingLocation: "Cave Mouth", PlayerName: "Test Player"})
+
+	var chunks []string
+	narrative, err := g.processPlayerPromptStream(context.Background(), "go into the cave", "blocked@example.com", func(chunk string) {
+		chunks = append(chunks, chunk)
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+
+	if narrative != refusalMessage("narrative", "graphic_violence") {
+		t.Errorf("Expected the narrative refusal, but got %q", narrative)
+	}
+	if streamed := strings.Join(chunks, ""); streamed != "You enter the cave." {
+		t.Errorf("Expected only the sentence before the blocked one to be streamed, but got %q", streamed)
+	}
+	if len(g.GameMessageHistory) != 0 {
+		t.Errorf("Expected the blocked turn to be kept out of the history, but got %d messages", len(g.GameMessageHistory))
+	}
+}
+
+func TestSchemaRepairWhenToolIsSkipped(t *testing.T) {
+	var requests []aiapi.AnthropicRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request aiapi.AnthropicRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		requests = append(requests, request)
+		if len(requests) == 1 {
+			w.Write([]byte(`{"content": [{"type": "text", "text": "The player went to the river."}], "usage": {"input_tokens": 3, "output_tokens": 4}}`))
+			return
+		}
+		w.Write([]byte(`{"content": [{"type": "tool_use", "id": "toolu_1", "name": "story_threads", "input": {"story_threads": ["cross the river"]}}], "usage": {"input_tokens": 5, "output_tokens": 6}}`))
+	}))
+	defer server.Close()
+
+	t.Setenv("AI_CASSETTE_MODE", "")
+	summarizer := aiapi.RoleConfig{Provider: aiapi.ProviderAnthropic, Model: "claude-3-haiku-20240307", ResponseFormat: "json_object", BaseURL: server.URL}
+	if err := aiapi.ApplyModelConfig(&aiapi.ModelConfig{Roles: map[string]aiapi.RoleConfig{aiapi.RoleStorySummarizer: summarizer}}); err != nil {
+		t.Fatalf("Expected the clients to build, but got %v", err)
+	}
+	usePrompts(t)
+	useUnreachableRedis()
+
+	var target struct {
+		StoryThreads []string `json:"story_threads"`
+	}
+	schema := aiapi.SchemaFor("story_threads", target)
+	tokens, err := callClientJSON(context.Background(), aiapi.RoleStorySummarizer, []GameMessage{{Provider: "user", Message: "summarize"}}, schema, &target)
+	if err != nil {
+		t.Fatalf("Expected the response to be repaired, but got %v", err)
+	}
+
+	if len(requests) != 2 {
+		t.Fatalf("Expected a repair request, but got %d requests", len(requests))
+	}
+	if len(target.StoryThreads) != 1 || target.StoryThreads[0] != "cross the river" {
+		t.Errorf("Expected the repaired story threads, but got %v", target.StoryThreads)
+	}
+	if tokens != 18 {
+		t.Errorf("Expected the tokens of both attempts, but got %d", tokens)
+	}
+	repair := requests[1].Messages[len(requests[1].Messages)-1]
+	if repair.Role != "user" || !strings.Contains(repair.Content, "did not call the story_threads tool") {
+		t.Errorf("Expected the repair prompt to give the error, but got %+v", repair)
+	}
+}
@@ -0,0 +1,227 @@
+package game
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/sessionsdev/blue-octopus/internal/redis"
+)
+
+// baseContext is the parent of work that outlives a request, such as the
+// background reconciliation of a turn.  It is cancelled on server shutdown.
+var baseContext = context.Background()
+
+// SetBaseContext sets the context background turn work runs under.
+func SetBaseContext(ctx context.Context) {
+	baseContext = ctx
+}
+
+var (
+	activeTurns   = map[string]context.CancelFunc{}
+	turnLocks     = map[string]*turnLock{}
+	userLocks     = map[string]*turnLock{}
+	turnStatuses  = map[string]string{}
+	activeTurnsMu sync.Mutex
+)
+
+// turnLockLease is how long a turn's lock outlives the server holding it.
+// The lock is refreshed while the turn runs, so it only lapses when the
+// server dies mid turn, and the player can play again once it has.
+const turnLockLease = 30 * time.Second
+
+// errTurnInProgress is returned when a player sends a command while their
+// previous turn in the same save is still resolving, on this server or
+// another one.
+var errTurnInProgress = errors.New("the previous turn is still resolving")
+
+// ErrSavesBusy is returned when a change to all of a user's saves can't be
+// made because one is already being made, or a turn is resolving.
+var ErrSavesBusy = errors.New("the saves are in use, try again in a moment")
+
+// turnLock is a player's hold on a save for the length of a turn, or on
+// all of their saves.
+type turnLock struct {
+	name  string
+	key   *redis.UserGameLockKey
+	token string
+	// held is false when redis couldn't be reached and the lock only
+	// covers this server
+	held bool
+	stop chan struct{}
+}
+
+// turnOwner names a save slot's turns, a player's turns in different
+// slots don't wait on each other.
+func turnOwner(email string, slot string) string {
+	return email + "/" + normalizeSlot(slot)
+}
+
+// lockTurn takes the lock on a player's save slot for a turn, returning
+// errTurnInProgress if their previous turn in the slot holds it.  The lock
+// is released by releaseTurn.  Play isn't stopped when redis can't be
+// reached, the turn is then only locked on this server.
+func lockTurn(ctx context.Context, email string, slot string) error {
+	lock := &turnLock{
+		name: turnOwner(email, slot),
+		key:  &redis.UserGameLockKey{Email: email, Slot: normalizeSlot(slot)},
+	}
+	return lock.acquire(ctx, turnLocks, errTurnInProgress)
+}
+
+// lockUser takes the lock on all of a player's saves, for changes that
+// touch every slot or the number of slots.  It returns ErrSavesBusy if the
+// lock is held and is released by releaseUser.  Turns don't take it, the
+// slots a change touches are locked with lockTurn too.
+func lockUser(ctx context.Context, email string) error {
+	lock := &turnLock{
+		name: email,
+		key:  &redis.UserGameLockKey{Email: email},
+	}
+	return lock.acquire(ctx, userLocks, ErrSavesBusy)
+}
+
+func (l *turnLock) acquire(ctx context.Context, locks map[string]*turnLock, busy error) error {
+	l.token = newID()
+	l.stop = make(chan struct{})
+
+	activeTurnsMu.Lock()
+	if _, ok := locks[l.name]; ok {
+		activeTurnsMu.Unlock()
+		return busy
+	}
+	locks[l.name] = l
+	activeTurnsMu.Unlock()
+
+	held, err := redis.AcquireLock(ctx, l.key, l.token, turnLockLease)
+	if err != nil {
+		log.Println("Error locking game: ", err)
+		return nil
+	}
+	if !held {
+		activeTurnsMu.Lock()
+		delete(locks, l.name)
+		activeTurnsMu.Unlock()
+		return busy
+	}
+
+	l.held = true
+	go l.keepAlive()
+	return nil
+}
+
+// keepAlive refreshes the lease until the lock is released.
+func (l *turnLock) keepAlive() {
+	ticker := time.NewTicker(turnLockLease / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			held, err := redis.RefreshLock(baseContext, l.key, l.token, turnLockLease)
+			if err != nil {
+				log.Println("Error refreshing game lock: ", err)
+				continue
+			}
+			if !held {
+				log.Printf("Game lock for %s lapsed before the turn finished", l.name)
+				return
+			}
+		}
+	}
+}
+
+func (l *turnLock) release() {
+	close(l.stop)
+	if !l.held {
+		return
+	}
+	if err := redis.ReleaseLock(context.WithoutCancel(baseContext), l.key, l.token); err != nil {
+		log.Println("Error releasing game lock: ", err)
+	}
+}
+
+// trackTurn records the cancel func for the current stage of a turn in a
+// save slot, releasing the context of the stage before it.
+func trackTurn(email string, slot string, cancel context.CancelFunc) {
+	activeTurnsMu.Lock()
+	defer activeTurnsMu.Unlock()
+
+	owner := turnOwner(email, slot)
+	if previous, ok := activeTurns[owner]; ok {
+		previous()
+	}
+	activeTurns[owner] = cancel
+}
+
+// releaseTurn ends the turn in a save slot, whether it finished, failed or
+// was cancelled, and releases the slot's lock so the next command can be
+// processed.
+func releaseTurn(email string, slot string) {
+	owner := turnOwner(email, slot)
+
+	activeTurnsMu.Lock()
+	if cancel, ok := activeTurns[owner]; ok {
+		cancel()
+		delete(activeTurns, owner)
+	}
+	lock, locked := turnLocks[owner]
+	delete(turnLocks, owner)
+	activeTurnsMu.Unlock()
+
+	if locked {
+		lock.release()
+	}
+}
+
+// releaseUser releases the lock taken by lockUser.
+func releaseUser(email string) {
+	activeTurnsMu.Lock()
+	lock, locked := userLocks[email]
+	delete(userLocks, email)
+	activeTurnsMu.Unlock()
+
+	if locked {
+		lock.release()
+	}
+}
+
+// CancelTurn aborts the in flight turn in a save slot.  It reports whether
+// there was a turn to cancel.
+func CancelTurn(email string, slot string) bool {
+	activeTurnsMu.Lock()
+	defer activeTurnsMu.Unlock()
+
+	cancel, ok := activeTurns[turnOwner(email, slot)]
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+// setTurnStatus records what to tell the player about the turn resolving in
+// a save slot, such as the Game Master being retried.  An empty status
+// clears it.
+func setTurnStatus(email string, slot string, status string) {
+	activeTurnsMu.Lock()
+	defer activeTurnsMu.Unlock()
+
+	if status == "" {
+		delete(turnStatuses, turnOwner(email, slot))
+		return
+	}
+	turnStatuses[turnOwner(email, slot)] = status
+}
+
+// turnStatus returns the status of the turn resolving in a save slot, empty
+// if there is nothing to report.
+func turnStatus(email string, slot string) string {
+	activeTurnsMu.Lock()
+	defer activeTurnsMu.Unlock()
+
+	return turnStatuses[turnOwner(email, slot)]
+}
@@ -0,0 +1,75 @@
+package game
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestTurnLockIsPerSave(t *testing.T) {
+	useUnreachableRedis()
+	ctx := context.Background()
+
+	if err := lockTurn(ctx, "first@example.com", MainSlot); err != nil {
+		t.Fatalf("Expected the first turn to take the lock, but got %v", err)
+	}
+	if err := lockTurn(ctx, "first@example.com", ""); !errors.Is(err, errTurnInProgress) {
+		t.Errorf("Expected a second turn to find the previous one resolving, but got %v", err)
+	}
+	if err := lockTurn(ctx, "first@example.com", "abc"); err != nil {
+		t.Errorf("Expected a turn in another save not to wait, but got %v", err)
+	}
+	if err := lockTurn(ctx, "second@example.com", MainSlot); err != nil {
+		t.Errorf("Expected another player's turn not to wait, but got %v", err)
+	}
+
+	message, err := ProcessGameCommand(ctx, "look around", "first@example.com")
+	if err != nil || message != turnInProgressMessage {
+		t.Errorf("Expected the player to be told their turn is resolving, but got %q, %v", message, err)
+	}
+
+	releaseTurn("first@example.com", MainSlot)
+	releaseTurn("first@example.com", "abc")
+	releaseTurn("second@example.com", MainSlot)
+	if err := lockTurn(ctx, "first@example.com", MainSlot); err != nil {
+		t.Errorf("Expected the lock to be free once the turn was released, but got %v", err)
+	}
+	releaseTurn("first@example.com", MainSlot)
+}
+
+func TestUserLockIsApartFromTurns(t *testing.T) {
+	useUnreachableRedis()
+	ctx := context.Background()
+
+	if err := lockTurn(ctx, "user@example.com", MainSlot); err != nil {
+		t.Fatalf("Expected the turn to take the lock, but got %v", err)
+	}
+	defer releaseTurn("user@example.com", MainSlot)
+
+	if err := lockUser(ctx, "user@example.com"); err != nil {
+		t.Fatalf("Expected a turn not to hold the user's lock, but got %v", err)
+	}
+	if err := lockUser(ctx, "user@example.com"); !errors.Is(err, ErrSavesBusy) {
+		t.Errorf("Expected the user's lock to be held, but got %v", err)
+	}
+	releaseUser("user@example.com")
+	if err := lockUser(ctx, "user@example.com"); err != nil {
+		t.Errorf("Expected the user's lock to be free once released, but got %v", err)
+	}
+	releaseUser("user@example.com")
+}
+
+func TestTurnStatusIsPerSave(t *testing.T) {
+	setTurnStatus("status@example.com", "", retryStatus)
+	if status := turnStatus("status@example.com", MainSlot); status != retryStatus {
+		t.Errorf("Expected %q, but got %q", retryStatus, status)
+	}
+	if status := turnStatus("status@example.com", "abc"); status != "" {
+		t.Errorf("Expected no status in another save, but got %q", status)
+	}
+
+	setTurnStatus("status@example.com", MainSlot, "")
+	if status := turnStatus("status@example.com", MainSlot); status != "" {
+		t.Errorf("Expected the status to be cleared, but got %q", status)
+	}
+}
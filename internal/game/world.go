@@ -2,6 +2,7 @@ package game
 
 import (
 	"log"
+	"sort"
 	"strings"
 
 	"github.com/sessionsdev/blue-octopus/internal/util"
@@ -117,6 +118,7 @@ func (w *World) GetAllLocationNames() []string {
 	for _, value := range w.Locations {
 		locationNames = append(locationNames, value.LocationName)
 	}
+	sort.Strings(locationNames)
 	return locationNames
 }
 
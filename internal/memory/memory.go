@@ -0,0 +1,270 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sessionsdev/blue-octopus/internal/aiapi"
+	"github.com/sessionsdev/blue-octopus/internal/redis"
+)
+
+// Kinds of memory.
+const (
+	KindTurn      = "turn"
+	KindLocation  = "location"
+	KindCharacter = "character"
+	KindEnemy     = "enemy"
+	KindObject    = "object"
+)
+
+// Memory is something that happened in a game, kept with its embedding so
+// it can be recalled when it becomes relevant again.
+type Memory struct {
+	Kind string `json:"kind"`
+	// Subject names the location, character or object a memory is about.
+	// Only the newest memory of a subject is recalled.
+	Subject string    `json:"subject,omitempty"`
+	Text    string    `json:"text"`
+	Turn    int       `json:"turn"`
+	Vector  []float32 `json:"vector"`
+}
+
+// Recollection is a recalled memory and how relevant it is to the query.
+type Recollection struct {
+	Memory
+	Score float64
+}
+
+// Store keeps the memories of each player's game.
+type Store interface {
+	Add(ctx context.Context, owner string, memories []Memory) error
+	All(ctx context.Context, owner string) ([]Memory, error)
+	Clear(ctx context.Context, owner string) error
+}
+
+// maxMemories is how many memories are kept per game, the oldest are
+// forgotten first.
+const maxMemories = 500
+
+// RedisStore keeps memories as JSON in a list per player.
+type RedisStore struct{}
+
+func (RedisStore) Add(ctx context.Context, owner string, memories []Memory) error {
+	key := &redis.UserMemoryKey{Email: owner}
+	for _, memory := range memories {
+		data, err := json.Marshal(memory)
+		if err != nil {
+			return err
+		}
+		if err := redis.PushValue(ctx, key, string(data), maxMemories, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (RedisStore) All(ctx context.Context, owner string) ([]Memory, error) {
+	values, err := redis.GetValues(ctx, &redis.UserMemoryKey{Email: owner}, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	memories := make([]Memory, 0, len(values))
+	for _, value := range values {
+		var memory Memory
+		if err := json.Unmarshal([]byte(value), &memory); err != nil {
+			continue
+		}
+		memories = append(memories, memory)
+	}
+	return memories, nil
+}
+
+func (RedisStore) Clear(ctx context.Context, owner string) error {
+	return redis.DeleteKey(ctx, &redis.UserMemoryKey{Email: owner})
+}
+
+// InMemoryStore keeps memories in the process, for tests and offline play.
+type InMemoryStore struct {
+	mu       sync.Mutex
+	memories map[string][]Memory
+}
+
+func NewInMemoryStore() *InMemoryStore {
+	return &InMemoryStore{memories: map[string][]Memory{}}
+}
+
+func (s *InMemoryStore) Add(ctx context.Context, owner string, memories []Memory) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all := append(s.memories[owner], memories...)
+	if len(all) > maxMemories {
+		all = all[len(all)-maxMemories:]
+	}
+	s.memories[owner] = all
+	return nil
+}
+
+func (s *InMemoryStore) All(ctx context.Context, owner string) ([]Memory, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Memory(nil), s.memories[owner]...), nil
+}
+
+func (s *InMemoryStore) Clear(ctx context.Context, owner string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.memories, owner)
+	return nil
+}
+
+var (
+	store   Store = RedisStore{}
+	storeMu sync.RWMutex
+)
+
+// SetStore replaces the store memories are kept in.
+func SetStore(s Store) {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+
+	store = s
+}
+
+func currentStore() Store {
+	storeMu.RLock()
+	defer storeMu.RUnlock()
+
+	return store
+}
+
+// Remember embeds the memories and stores them.
+func Remember(ctx context.Context, owner string, memories []Memory) error {
+	if len(memories) == 0 {
+		return nil
+	}
+
+	texts := make([]string, len(memories))
+	for i, memory := range memories {
+		texts[i] = memory.Text
+	}
+
+	vectors, err := aiapi.GetEmbedder().Embed(ctx, texts)
+	if err != nil {
+		return fmt.Errorf("error embedding memories: %w", err)
+	}
+	for i := range memories {
+		memories[i].Vector = vectors[i]
+	}
+
+	return currentStore().Add(ctx, owner, memories)
+}
+
+// minScore is the similarity below which a memory isn't worth recalling.
+const minScore = 0.2
+
+// Recall returns up to k of the owner's memories most relevant to query,
+// most relevant first.  Memories for which skip returns true are left out.
+func Recall(ctx context.Context, owner string, query string, k int, skip func(Memory) bool) ([]Recollection, error) {
+	if k <= 0 {
+		return nil, nil
+	}
+
+	memories, err := currentStore().All(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+	if len(memories) == 0 {
+		return nil, nil
+	}
+
+	vectors, err := aiapi.GetEmbedder().Embed(ctx, []string{query})
+	if err != nil {
+		return nil, fmt.Errorf("error embedding query: %w", err)
+	}
+
+	// newest first, so only the latest memory of each subject is kept
+	seen := map[string]bool{}
+	recollections := []Recollection{}
+	for i := len(memories) - 1; i >= 0; i-- {
+		memory := memories[i]
+		if memory.Subject != "" {
+			subject := memory.Kind + ":" + strings.ToLower(memory.Subject)
+			if seen[subject] {
+				continue
+			}
+			seen[subject] = true
+		}
+		if skip != nil && skip(memory) {
+			continue
+		}
+
+		score := aiapi.CosineSimilarity(vectors[0], memory.Vector)
+		if score < minScore {
+			continue
+		}
+		recollections = append(recollections, Recollection{Memory: memory, Score: score})
+	}
+
+	sort.SliceStable(recollections, func(i, j int) bool {
+		return recollections[i].Score > recollections[j].Score
+	})
+	if len(recollections) > k {
+		recollections = recollections[:k]
+	}
+	return recollections, nil
+}
+
+// Forget clears the owner's memories.
+func Forget(ctx context.Context, owner string) error {
+	return currentStore().Clear(ctx, owner)
+}
+
+// Copy replaces the memories of one owner with those of another.
+func Copy(ctx context.Context, from string, to string) error {
+	memories, err := currentStore().All(ctx, from)
+	if err != nil {
+		return err
+	}
+	if err := currentStore().Clear(ctx, to); err != nil {
+		return err
+	}
+	if len(memories) == 0 {
+		return nil
+	}
+	return currentStore().Add(ctx, to, memories)
+}
+
+// ForgetAfter forgets an owner's memories of turns after turn, as when the
+// game is rewound to it.
+func ForgetAfter(ctx context.Context, owner string, turn int) error {
+	memories, err := currentStore().All(ctx, owner)
+	if err != nil {
+		return err
+	}
+
+	kept := []Memory{}
+	for _, m := range memories {
+		if m.Turn <= turn {
+			kept = append(kept, m)
+		}
+	}
+	if len(kept) == len(memories) {
+		return nil
+	}
+
+	if err := currentStore().Clear(ctx, owner); err != nil {
+		return err
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+	return currentStore().Add(ctx, owner, kept)
+}
@@ -0,0 +1,93 @@
+package memory
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sessionsdev/blue-octopus/internal/aiapi"
+)
+
+func useTestStore() *InMemoryStore {
+	aiapi.SetEmbedder(aiapi.HashEmbedder{})
+	store := NewInMemoryStore()
+	SetStore(store)
+	return store
+}
+
+func TestRecallRanksRelevantMemories(t *testing.T) {
+	useTestStore()
+	ctx := context.Background()
+	owner := "memory@example.com"
+
+	err := Remember(ctx, owner, []Memory{
+		{Kind: KindCharacter, Subject: "Old Tom", Text: "Old Tom, the lighthouse keeper with a wooden leg", Turn: 1},
+		{Kind: KindTurn, Text: "Player: search the beach\nGame Master: You find driftwood and a crab.", Turn: 2},
+		{Kind: KindObject, Subject: "brass key", Text: "brass key, an object found at the cellar door", Turn: 3},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+
+	recollections, err := Recall(ctx, owner, "ask the lighthouse keeper about Old Tom's leg", 2, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if len(recollections) == 0 || recollections[0].Subject != "Old Tom" {
+		t.Fatalf("Expected Old Tom to be recalled first, but got %v", recollections)
+	}
+	for _, recollection := range recollections {
+		if recollection.Kind == KindTurn {
+			t.Errorf("Expected the unrelated beach turn not to be recalled, but got %q", recollection.Text)
+		}
+	}
+
+	skipped, _ := Recall(ctx, owner, "Old Tom", 5, func(m Memory) bool { return m.Kind == KindCharacter })
+	if len(skipped) != 0 {
+		t.Errorf("Expected skipped memories to be left out, but got %v", skipped)
+	}
+}
+
+func TestRecallKeepsNewestMemoryOfSubject(t *testing.T) {
+	useTestStore()
+	ctx := context.Background()
+	owner := "subject@example.com"
+
+	Remember(ctx, owner, []Memory{{Kind: KindLocation, Subject: "Cellar", Text: "Cellar: the cellar is flooded", Turn: 1}})
+	Remember(ctx, owner, []Memory{{Kind: KindLocation, Subject: "cellar", Text: "Cellar: the cellar has been drained", Turn: 4}})
+
+	recollections, err := Recall(ctx, owner, "go down to the cellar", 5, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if len(recollections) != 1 || recollections[0].Turn != 4 {
+		t.Errorf("Expected only the newest cellar memory, but got %v", recollections)
+	}
+
+	if err := Forget(ctx, owner); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if recollections, _ := Recall(ctx, owner, "cellar", 5, nil); len(recollections) != 0 {
+		t.Errorf("Expected no memories after forgetting, but got %v", recollections)
+	}
+}
+
+func TestForgetAfterKeepsEarlierTurns(t *testing.T) {
+	store := useTestStore()
+	ctx := context.Background()
+	owner := "rewind@example.com"
+
+	Remember(ctx, owner, []Memory{
+		{Kind: KindTurn, Text: "Player: open the door", Turn: 1},
+		{Kind: KindTurn, Text: "Player: walk inside", Turn: 2},
+		{Kind: KindObject, Subject: "lantern", Text: "lantern, an object found at the hall", Turn: 3},
+	})
+
+	if err := ForgetAfter(ctx, owner, 1); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+
+	memories, _ := store.All(ctx, owner)
+	if len(memories) != 1 || memories[0].Turn != 1 {
+		t.Errorf("Expected only the memory of turn 1, but got %v", memories)
+	}
+}
@@ -0,0 +1,335 @@
+package moderation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sessionsdev/blue-octopus/internal/aiapi"
+	"github.com/sessionsdev/blue-octopus/internal/redis"
+)
+
+// Rating is the content rating a game is played at.
+type Rating string
+
+const (
+	RatingFamily Rating = "family"
+	RatingTeen   Rating = "teen"
+	RatingMature Rating = "mature"
+)
+
+var Ratings = []Rating{RatingFamily, RatingTeen, RatingMature}
+
+// ParseRating returns the rating named by s.
+func ParseRating(s string) (Rating, bool) {
+	for _, rating := range Ratings {
+		if strings.EqualFold(s, string(rating)) {
+			return rating, true
+		}
+	}
+	return "", false
+}
+
+// DefaultRating is the rating of new games, set with DEFAULT_CONTENT_RATING.
+func DefaultRating() Rating {
+	if rating, ok := ParseRating(os.Getenv("DEFAULT_CONTENT_RATING")); ok {
+		return rating
+	}
+	return RatingTeen
+}
+
+// Verdict is the outcome of moderating a piece of text.
+type Verdict struct {
+	Blocked   bool
+	Category  string
+	Reason    string
+	Moderator string
+}
+
+// Moderator decides whether text is allowed at a rating.
+type Moderator interface {
+	Check(ctx context.Context, text string, rating Rating) (Verdict, error)
+}
+
+// Rule blocks text matching a regular expression.  Patterns are case
+// insensitive.
+type Rule struct {
+	Category string `json:"category"`
+	Pattern  string `json:"pattern"`
+
+	// BlockAt lists the ratings the rule applies to, every rating if empty.
+	BlockAt []Rating `json:"block_at,omitempty"`
+
+	compiled *regexp.Regexp
+}
+
+func (r *Rule) appliesTo(rating Rating) bool {
+	if len(r.BlockAt) == 0 {
+		return true
+	}
+	for _, blockAt := range r.BlockAt {
+		if blockAt == rating {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultRules are used when no rules file is present.
+var DefaultRules = []Rule{
+	{Category: "sexual_minors", Pattern: `\b(child|children|kid|minor|underage)\w*\b.{0,40}\b(sex|sexual|nude|naked|erotic)\w*\b`},
+	{Category: "self_harm", Pattern: `\b(kill|hurt|harm|cut)\s+(myself|yourself)\b|\bsuicid\w*\b`},
+	{Category: "sexual", Pattern: `\b(sex|sexual|nude|naked|erotic|orgasm)\w*\b`, BlockAt: []Rating{RatingFamily, RatingTeen}},
+	{Category: "graphic_violence", Pattern: `\b(disembowel|dismember|decapitat|mutilat|eviscerat)\w*\b|\bgore\b`, BlockAt: []Rating{RatingFamily, RatingTeen}},
+	{Category: "profanity", Pattern: `\b(fuck|shit|bitch|cunt|bastard)\w*\b`, BlockAt: []Rating{RatingFamily}},
+}
+
+// RuleModerator checks text against keyword and regular expression rules.
+type RuleModerator struct {
+	Rules []Rule
+}
+
+// NewRuleModerator compiles the rules.
+func NewRuleModerator(rules []Rule) (*RuleModerator, error) {
+	compiled := make([]Rule, 0, len(rules))
+	for _, rule := range rules {
+		re, err := regexp.Compile("(?i)" + rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", rule.Category, err)
+		}
+		rule.compiled = re
+		compiled = append(compiled, rule)
+	}
+	return &RuleModerator{Rules: compiled}, nil
+}
+
+// LoadRules reads a JSON list of rules.
+func LoadRules(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("error parsing moderation rules %s: %w", path, err)
+	}
+	return rules, nil
+}
+
+func (m *RuleModerator) Check(ctx context.Context, text string, rating Rating) (Verdict, error) {
+	for _, rule := range m.Rules {
+		if !rule.appliesTo(rating) {
+			continue
+		}
+		if match := rule.compiled.FindString(text); match != "" {
+			return Verdict{
+				Blocked:   true,
+				Category:  rule.Category,
+				Reason:    fmt.Sprintf("matched %q", match),
+				Moderator: "rules",
+			}, nil
+		}
+	}
+	return Verdict{Moderator: "rules"}, nil
+}
+
+// alwaysBlocked are moderation endpoint categories blocked at every rating.
+var alwaysBlocked = map[string]bool{
+	"sexual/minors":          true,
+	"self-harm/intent":       true,
+	"self-harm/instructions": true,
+	"hate/threatening":       true,
+	"illicit/violent":        true,
+}
+
+// EndpointModerator asks OpenAI's moderation endpoint.  Family games block
+// anything it flags, teen games allow non graphic violence and mature games
+// only block the categories in alwaysBlocked.
+type EndpointModerator struct {
+	Client *aiapi.ModerationClient
+}
+
+func (m *EndpointModerator) Check(ctx context.Context, text string, rating Rating) (Verdict, error) {
+	result, err := m.Client.Moderate(ctx, text)
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	verdict := Verdict{Moderator: "openai"}
+	if !result.Flagged {
+		return verdict, nil
+	}
+
+	for category, flagged := range result.Categories {
+		if !flagged {
+			continue
+		}
+
+		blocked := alwaysBlocked[category]
+		switch rating {
+		case RatingFamily:
+			blocked = true
+		case RatingTeen:
+			blocked = blocked || category != "violence"
+		}
+
+		if blocked {
+			verdict.Blocked = true
+			verdict.Category = category
+			verdict.Reason = fmt.Sprintf("flagged %s (score %.2f)", category, result.CategoryScores[category])
+			return verdict, nil
+		}
+	}
+	return verdict, nil
+}
+
+// Chain runs moderators in order and returns the first blocking verdict.
+// A moderator that errors is skipped, so an outage doesn't stop the game.
+type Chain []Moderator
+
+func (c Chain) Check(ctx context.Context, text string, rating Rating) (Verdict, error) {
+	for _, moderator := range c {
+		verdict, err := moderator.Check(ctx, text, rating)
+		if err != nil {
+			log.Println("Error moderating text: ", err)
+			continue
+		}
+		if verdict.Blocked {
+			return verdict, nil
+		}
+	}
+	return Verdict{}, nil
+}
+
+var (
+	current   Moderator
+	currentMu sync.RWMutex
+)
+
+func init() {
+	// moderate with the default rules until Configure is called
+	rulesModerator, err := NewRuleModerator(DefaultRules)
+	if err != nil {
+		log.Printf("Unable to compile the default moderation rules: %v", err)
+		return
+	}
+	current = rulesModerator
+}
+
+// Configure sets up moderation from the environment.  MODERATION_PROVIDER
+// is "rules" by default, "openai" to also ask the moderation endpoint, or
+// "off".  Rules are read from MODERATION_RULES, config/moderation.json by
+// default, falling back to DefaultRules.
+func Configure() error {
+	provider := os.Getenv("MODERATION_PROVIDER")
+	if provider == "off" {
+		SetModerator(nil)
+		return nil
+	}
+
+	path := os.Getenv("MODERATION_RULES")
+	if path == "" {
+		path = "config/moderation.json"
+	}
+
+	rules, err := LoadRules(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		rules = DefaultRules
+	} else if err != nil {
+		return err
+	}
+
+	rulesModerator, err := NewRuleModerator(rules)
+	if err != nil {
+		return err
+	}
+	chain := Chain{rulesModerator}
+
+	switch provider {
+	case "", "rules":
+	case "openai":
+		chain = append(chain, &EndpointModerator{Client: aiapi.NewModerationClient()})
+	default:
+		return fmt.Errorf("unknown moderation provider: %s", provider)
+	}
+
+	SetModerator(chain)
+	return nil
+}
+
+// SetModerator replaces the moderator, nil turns moderation off.
+func SetModerator(moderator Moderator) {
+	currentMu.Lock()
+	defer currentMu.Unlock()
+
+	current = moderator
+}
+
+// Check moderates text with the configured moderator.
+func Check(ctx context.Context, text string, rating Rating) Verdict {
+	currentMu.RLock()
+	moderator := current
+	currentMu.RUnlock()
+
+	if moderator == nil {
+		return Verdict{}
+	}
+
+	verdict, err := moderator.Check(ctx, text, rating)
+	if err != nil {
+		log.Println("Error moderating text: ", err)
+		return Verdict{}
+	}
+	return verdict
+}
+
+// FlaggedTurn is a blocked command or narrative kept for admin review.
+type FlaggedTurn struct {
+	Time      time.Time `json:"time"`
+	User      string    `json:"user"`
+	Source    string    `json:"source"`
+	Text      string    `json:"text"`
+	Rating    Rating    `json:"rating"`
+	Category  string    `json:"category"`
+	Reason    string    `json:"reason"`
+	Moderator string    `json:"moderator"`
+}
+
+// the most flagged turns kept for review
+const maxFlaggedTurns = 1000
+
+// RecordFlagged stores a blocked turn for review.
+func RecordFlagged(ctx context.Context, turn FlaggedTurn) error {
+	data, err := json.Marshal(turn)
+	if err != nil {
+		return err
+	}
+	return redis.PushValue(ctx, &redis.ModerationFlaggedKey{}, string(data), maxFlaggedTurns, 0)
+}
+
+// RecentFlagged returns up to limit of the latest flagged turns, newest first.
+func RecentFlagged(ctx context.Context, limit int64) ([]FlaggedTurn, error) {
+	values, err := redis.GetValues(ctx, &redis.ModerationFlaggedKey{}, -limit, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	turns := make([]FlaggedTurn, 0, len(values))
+	for i := len(values) - 1; i >= 0; i-- {
+		var turn FlaggedTurn
+		if err := json.Unmarshal([]byte(values[i]), &turn); err != nil {
+			continue
+		}
+		turns = append(turns, turn)
+	}
+	return turns, nil
+}
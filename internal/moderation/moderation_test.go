@@ -0,0 +1,56 @@
+package moderation
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sessionsdev/blue-octopus/internal/aiapi"
+)
+
+func TestRuleModeratorRespectsRating(t *testing.T) {
+	moderator, err := NewRuleModerator(DefaultRules)
+	if err != nil {
+		t.Fatalf("Expected the default rules to compile, but got %v", err)
+	}
+
+	verdict, _ := moderator.Check(context.Background(), "I dismember the troll", RatingTeen)
+	if !verdict.Blocked || verdict.Category != "graphic_violence" {
+		t.Errorf("Expected graphic violence to be blocked for teens, but got %+v", verdict)
+	}
+
+	verdict, _ = moderator.Check(context.Background(), "I dismember the troll", RatingMature)
+	if verdict.Blocked {
+		t.Errorf("Expected graphic violence to be allowed for mature games, but got %+v", verdict)
+	}
+
+	verdict, _ = moderator.Check(context.Background(), "I swing my sword at the troll", RatingFamily)
+	if verdict.Blocked {
+		t.Errorf("Expected ordinary combat to be allowed, but got %+v", verdict)
+	}
+}
+
+func TestEndpointModerator(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"results": [{"flagged": true, "categories": {"violence": true}, "category_scores": {"violence": 0.9}}]}`))
+	}))
+	defer server.Close()
+
+	client := aiapi.NewModerationClient()
+	client.BaseURL = server.URL
+	moderator := &EndpointModerator{Client: client}
+
+	verdict, err := moderator.Check(context.Background(), "I swing my sword at the troll", RatingTeen)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if verdict.Blocked {
+		t.Errorf("Expected violence to be allowed for teens, but got %+v", verdict)
+	}
+
+	verdict, _ = moderator.Check(context.Background(), "I swing my sword at the troll", RatingFamily)
+	if !verdict.Blocked || verdict.Category != "violence" {
+		t.Errorf("Expected violence to be blocked for families, but got %+v", verdict)
+	}
+}
@@ -0,0 +1,271 @@
+package prompts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// Names of the prompts every version must provide, each in a file named
+// after it with a .tmpl extension.
+const (
+	GameMaster           = "game_master"
+	GameMasterState      = "game_master_state"
+	StateManager         = "state_manager"
+	StateManagerState    = "state_manager_state"
+	StateManagerRequest  = "state_manager_request"
+	StorySummarizer      = "story_summarizer"
+	StorySummarizerState = "story_summarizer_state"
+	ProgressiveSummary   = "progressive_summary"
+	SchemaRepair         = "schema_repair"
+)
+
+var Names = []string{
+	GameMaster, GameMasterState,
+	StateManager, StateManagerState, StateManagerRequest,
+	StorySummarizer, StorySummarizerState, ProgressiveSummary,
+	SchemaRepair,
+}
+
+var funcs = template.FuncMap{
+	"join": strings.Join,
+	// list renders items as a markdown list, one per line
+	"list": func(items []string) string {
+		var list strings.Builder
+		for _, item := range items {
+			list.WriteString("- " + item + "\n")
+		}
+		return list.String()
+	},
+	// json quotes a string so player text can't break out of a json field
+	"json": func(s string) string {
+		quoted, _ := json.Marshal(s)
+		return string(quoted)
+	},
+}
+
+// Set is one version of the prompts.
+type Set struct {
+	// Version is the name of the directory the prompts were loaded from.
+	Version string
+	// ID is the version and a hash of the prompt files, so an edit made
+	// without creating a new version still shows in the call records.
+	ID        string
+	templates *template.Template
+}
+
+// Render executes the named prompt with data.
+func (s *Set) Render(name string, data any) (string, error) {
+	var prompt strings.Builder
+	if err := s.templates.ExecuteTemplate(&prompt, name+".tmpl", data); err != nil {
+		return "", fmt.Errorf("error rendering prompt %s of version %s: %w", name, s.Version, err)
+	}
+	return prompt.String(), nil
+}
+
+// LoadSet parses the prompts of the version in dir.
+func LoadSet(dir string, version string) (*Set, error) {
+	fsys := os.DirFS(filepath.Join(dir, version))
+
+	hash := sha256.New()
+	templates := template.New(version).Funcs(funcs).Option("missingkey=error")
+	for _, name := range Names {
+		text, err := fs.ReadFile(fsys, name+".tmpl")
+		if err != nil {
+			return nil, fmt.Errorf("error reading prompt %s of version %s: %w", name, version, err)
+		}
+		if _, err := templates.New(name + ".tmpl").Parse(string(text)); err != nil {
+			return nil, fmt.Errorf("error parsing prompt %s of version %s: %w", name, version, err)
+		}
+
+		hash.Write([]byte(name))
+		hash.Write(text)
+	}
+
+	return &Set{
+		Version:   version,
+		ID:        version + "@" + hex.EncodeToString(hash.Sum(nil))[:8],
+		templates: templates,
+	}, nil
+}
+
+// Library is every version of the prompts.
+type Library struct {
+	Sets    map[string]*Set
+	Default string
+}
+
+// Load parses every version in dir, each a subdirectory of prompt files.
+// The default version is defaultVersion, or the latest if it is empty.
+func Load(dir string, defaultVersion string) (*Library, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	library := &Library{Sets: map[string]*Set{}}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		set, err := LoadSet(dir, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		library.Sets[set.Version] = set
+	}
+
+	versions := library.versions()
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("no prompt versions in %s", dir)
+	}
+
+	library.Default = defaultVersion
+	if library.Default == "" {
+		library.Default = versions[len(versions)-1]
+	}
+	if _, ok := library.Sets[library.Default]; !ok {
+		return nil, fmt.Errorf("default prompt version %s not found in %s", library.Default, dir)
+	}
+	return library, nil
+}
+
+// versions returns the version names oldest first, comparing the numbers
+// in them by value so v10 comes after v9.
+func (l *Library) versions() []string {
+	versions := make([]string, 0, len(l.Sets))
+	for version := range l.Sets {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versionLess(versions[i], versions[j])
+	})
+	return versions
+}
+
+func versionLess(a string, b string) bool {
+	prefixA, numberA := splitVersion(a)
+	prefixB, numberB := splitVersion(b)
+	if prefixA != prefixB || numberA < 0 || numberB < 0 || numberA == numberB {
+		return a < b
+	}
+	return numberA < numberB
+}
+
+// splitVersion splits a version like v12 into its prefix and number, the
+// number is -1 if there isn't one.
+func splitVersion(version string) (string, int) {
+	prefix := strings.TrimRight(version, "0123456789")
+	number, err := strconv.Atoi(version[len(prefix):])
+	if err != nil {
+		return version, -1
+	}
+	return prefix, number
+}
+
+var (
+	library   *Library
+	libraryMu sync.RWMutex
+)
+
+// Configure loads the prompts from PROMPTS_DIR, "prompts" by default.
+// PROMPT_VERSION picks the version unpinned games use, the latest by
+// default.
+func Configure() error {
+	dir := os.Getenv("PROMPTS_DIR")
+	if dir == "" {
+		dir = "prompts"
+	}
+
+	loaded, err := Load(dir, os.Getenv("PROMPT_VERSION"))
+	if err != nil {
+		return err
+	}
+
+	SetLibrary(loaded)
+	log.Printf("Loaded prompt versions %v from %s, default %s", loaded.versions(), dir, loaded.Default)
+	return nil
+}
+
+// SetLibrary replaces the prompts in use.
+func SetLibrary(l *Library) {
+	libraryMu.Lock()
+	defer libraryMu.Unlock()
+
+	library = l
+}
+
+// ErrVersionNotLoaded is returned for a version that isn't loaded, such as
+// one a game was pinned to before it was removed.
+var ErrVersionNotLoaded = errors.New("prompt version is not loaded")
+
+// For returns the prompts of a version, or the default prompts if version
+// is empty.  A game pinned to a version that is gone isn't quietly played
+// with other prompts, ErrVersionNotLoaded is returned instead.
+func For(version string) (*Set, error) {
+	libraryMu.RLock()
+	defer libraryMu.RUnlock()
+
+	if library == nil {
+		return nil, fmt.Errorf("prompts have not been loaded")
+	}
+
+	if version == "" {
+		return library.Sets[library.Default], nil
+	}
+	set, ok := library.Sets[version]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrVersionNotLoaded, version)
+	}
+	return set, nil
+}
+
+// Has reports whether a version is loaded.
+func Has(version string) bool {
+	libraryMu.RLock()
+	defer libraryMu.RUnlock()
+
+	if library == nil {
+		return false
+	}
+	_, ok := library.Sets[version]
+	return ok
+}
+
+// VersionInfo describes a loaded version for the admin page.
+type VersionInfo struct {
+	Version string
+	ID      string
+	Default bool
+}
+
+// Versions lists the loaded versions, oldest first.
+func Versions() []VersionInfo {
+	libraryMu.RLock()
+	defer libraryMu.RUnlock()
+
+	if library == nil {
+		return nil
+	}
+
+	infos := []VersionInfo{}
+	for _, version := range library.versions() {
+		infos = append(infos, VersionInfo{
+			Version: version,
+			ID:      library.Sets[version].ID,
+			Default: version == library.Default,
+		})
+	}
+	return infos
+}
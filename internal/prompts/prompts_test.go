@@ -0,0 +1,65 @@
+package prompts
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRepositoryPromptsRender(t *testing.T) {
+	library, err := Load("../../prompts", "")
+	if err != nil {
+		t.Fatalf("Expected the prompts to load, but got %v", err)
+	}
+	set := library.Sets[library.Default]
+
+	prompt, err := set.Render(StorySummarizerState, struct {
+		StoryThreads      []string
+		PlayerAction      string
+		NarrativeResponse string
+	}{[]string{"find the key"}, `say "100%"`, "The door creaks."})
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if !strings.Contains(prompt, `"player_action": "say \"100%\""`) {
+		t.Errorf("Expected the player action to be quoted, but got %s", prompt)
+	}
+
+	if _, err := set.Render(ProgressiveSummary, struct{ Threads []string }{}); err == nil {
+		t.Errorf("Expected an error for a missing field, but got none")
+	}
+}
+
+func TestDefaultIsLatestVersion(t *testing.T) {
+	dir := t.TempDir()
+	for _, version := range []string{"v2", "v10", "v9"} {
+		os.Mkdir(filepath.Join(dir, version), 0755)
+		for _, name := range Names {
+			os.WriteFile(filepath.Join(dir, version, name+".tmpl"), []byte(version+" "+name), 0644)
+		}
+	}
+
+	library, err := Load(dir, "")
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if library.Default != "v10" {
+		t.Errorf("Expected v10 to be the default, but got %s", library.Default)
+	}
+
+	SetLibrary(library)
+	if set, _ := For("v9"); set.Version != "v9" {
+		t.Errorf("Expected the pinned version, but got %s", set.Version)
+	}
+	if set, _ := For(""); set.Version != "v10" {
+		t.Errorf("Expected the default version, but got %s", set.Version)
+	}
+	if _, err := For("v404"); !errors.Is(err, ErrVersionNotLoaded) {
+		t.Errorf("Expected an unknown version to be an error, but got %v", err)
+	}
+	if library.Sets["v9"].ID == library.Sets["v2"].ID {
+		t.Errorf("Expected versions with different prompts to have different ids")
+	}
+}
@@ -0,0 +1,176 @@
+package quota
+
+import (
+	"context"
+	"encoding/gob"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/sessionsdev/blue-octopus/internal/redis"
+)
+
+func init() {
+	gob.Register(Limits{})
+}
+
+// Limits are the most tokens a user may spend per day and per month.  Zero
+// means unlimited.
+type Limits struct {
+	Daily   int
+	Monthly int
+}
+
+// Usage is the tokens a user has spent in the current day and month along
+// with the limits that apply to them.
+type Usage struct {
+	Daily      int
+	Monthly    int
+	Limits     Limits
+	IsOverride bool
+}
+
+// DailyRemaining returns the tokens left today, or -1 when unlimited.
+func (u Usage) DailyRemaining() int {
+	return remaining(u.Daily, u.Limits.Daily)
+}
+
+// MonthlyRemaining returns the tokens left this month, or -1 when unlimited.
+func (u Usage) MonthlyRemaining() int {
+	return remaining(u.Monthly, u.Limits.Monthly)
+}
+
+func remaining(used int, limit int) int {
+	if limit <= 0 {
+		return -1
+	}
+	if used >= limit {
+		return 0
+	}
+	return limit - used
+}
+
+// ExceededError is returned by Check when a user has spent their quota.
+type ExceededError struct {
+	Period string
+	Used   int
+	Limit  int
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("%s token quota exceeded: %d of %d used", e.Period, e.Used, e.Limit)
+}
+
+// DefaultLimits returns the limits for users without an override, set with
+// DAILY_TOKEN_QUOTA and MONTHLY_TOKEN_QUOTA.
+func DefaultLimits() Limits {
+	return Limits{
+		Daily:   envInt("DAILY_TOKEN_QUOTA"),
+		Monthly: envInt("MONTHLY_TOKEN_QUOTA"),
+	}
+}
+
+func envInt(name string) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid value for %s: %s", name, value)
+		return 0
+	}
+	return parsed
+}
+
+// GetLimits returns the admin override for a user if one is set, and the
+// default limits otherwise.
+func GetLimits(ctx context.Context, email string) (Limits, bool) {
+	var limits Limits
+	_, err := redis.GetObj(ctx, &redis.UserQuotaKey{Email: email}, &limits)
+	if err != nil {
+		return DefaultLimits(), false
+	}
+	return limits, true
+}
+
+// SetOverride sets per user limits that replace the defaults.
+func SetOverride(ctx context.Context, email string, limits Limits) error {
+	return redis.SetObj(ctx, &redis.UserQuotaKey{Email: email}, limits, 0)
+}
+
+// ClearOverride returns a user to the default limits.
+func ClearOverride(ctx context.Context, email string) error {
+	return redis.DeleteKey(ctx, &redis.UserQuotaKey{Email: email})
+}
+
+// GetUsage returns a user's token usage for the current day and month.
+func GetUsage(ctx context.Context, email string) (Usage, error) {
+	now := time.Now().UTC()
+
+	daily, err := redis.GetInt(ctx, dailyKey(email, now))
+	if err != nil {
+		return Usage{}, err
+	}
+
+	monthly, err := redis.GetInt(ctx, monthlyKey(email, now))
+	if err != nil {
+		return Usage{}, err
+	}
+
+	limits, isOverride := GetLimits(ctx, email)
+	return Usage{
+		Daily:      int(daily),
+		Monthly:    int(monthly),
+		Limits:     limits,
+		IsOverride: isOverride,
+	}, nil
+}
+
+// Check returns an ExceededError if the user has no tokens left today or
+// this month.
+func Check(ctx context.Context, email string) error {
+	usage, err := GetUsage(ctx, email)
+	if err != nil {
+		return err
+	}
+
+	if usage.Limits.Monthly > 0 && usage.Monthly >= usage.Limits.Monthly {
+		return &ExceededError{Period: "monthly", Used: usage.Monthly, Limit: usage.Limits.Monthly}
+	}
+
+	if usage.Limits.Daily > 0 && usage.Daily >= usage.Limits.Daily {
+		return &ExceededError{Period: "daily", Used: usage.Daily, Limit: usage.Limits.Daily}
+	}
+
+	return nil
+}
+
+// Record adds tokens to a user's usage for the current day and month.
+func Record(ctx context.Context, email string, tokens int) error {
+	if tokens <= 0 {
+		return nil
+	}
+
+	now := time.Now().UTC()
+
+	// keep the counters a little past their period so late reads still work
+	if _, err := redis.IncrBy(ctx, dailyKey(email, now), int64(tokens), 2*24*60); err != nil {
+		return err
+	}
+	if _, err := redis.IncrBy(ctx, monthlyKey(email, now), int64(tokens), 62*24*60); err != nil {
+		return err
+	}
+	return nil
+}
+
+func dailyKey(email string, now time.Time) *redis.UserTokenUsageKey {
+	return &redis.UserTokenUsageKey{Email: email, Period: now.Format("2006-01-02")}
+}
+
+func monthlyKey(email string, now time.Time) *redis.UserTokenUsageKey {
+	return &redis.UserTokenUsageKey{Email: email, Period: now.Format("2006-01")}
+}
@@ -35,12 +35,180 @@ func (k *UserSessionKey) GetKey() string {
 	return "session:" + k.SessionID
 }
 
+type UserSessionSlotKey struct {
+	SessionID string
+}
+
+func (k *UserSessionSlotKey) GetKey() string {
+	return "session:" + k.SessionID + ":slot"
+}
+
+// UserSavedGameKey is where a user's game is saved.  Slot names a save slot,
+// empty for the main slot, which keeps the key games were saved under before
+// there were slots.
 type UserSavedGameKey struct {
 	Email string
+	Slot  string
 }
 
 func (k *UserSavedGameKey) GetKey() string {
 	hasher := sha256.New()
 	hasher.Write([]byte(k.Email))
-	return "user:game:" + hex.EncodeToString(hasher.Sum(nil))
+	key := "user:game:" + hex.EncodeToString(hasher.Sum(nil))
+	if k.Slot != "" {
+		key += ":" + k.Slot
+	}
+	return key
+}
+
+// GameEventsKey is the stream of changes made to a save slot's game.
+type GameEventsKey struct {
+	Email string
+	Slot  string
+}
+
+func (k *GameEventsKey) GetKey() string {
+	hasher := sha256.New()
+	hasher.Write([]byte(k.Email))
+	key := "user:game:events:" + hex.EncodeToString(hasher.Sum(nil))
+	if k.Slot != "" {
+		key += ":" + k.Slot
+	}
+	return key
+}
+
+// TurnFeedbackKey holds the experiment variants each turn of a save slot's
+// game was played with and the player's vote on it.
+type TurnFeedbackKey struct {
+	Email string
+	Slot  string
+}
+
+func (k *TurnFeedbackKey) GetKey() string {
+	hasher := sha256.New()
+	hasher.Write([]byte(k.Email))
+	key := "user:game:feedback:" + hex.EncodeToString(hasher.Sum(nil))
+	if k.Slot != "" {
+		key += ":" + k.Slot
+	}
+	return key
+}
+
+// UserGameSnapshotsKey lists the snapshots of a save slot's game, one per
+// turn, oldest first.
+type UserGameSnapshotsKey struct {
+	Email string
+	Slot  string
+}
+
+func (k *UserGameSnapshotsKey) GetKey() string {
+	hasher := sha256.New()
+	hasher.Write([]byte(k.Email))
+	key := "user:game:snapshots:" + hex.EncodeToString(hasher.Sum(nil))
+	if k.Slot != "" {
+		key += ":" + k.Slot
+	}
+	return key
+}
+
+type UserSaveSlotsKey struct {
+	Email string
+}
+
+func (k *UserSaveSlotsKey) GetKey() string {
+	hasher := sha256.New()
+	hasher.Write([]byte(k.Email))
+	return "user:slots:" + hex.EncodeToString(hasher.Sum(nil))
+}
+
+type UserTokenUsageKey struct {
+	Email  string
+	Period string
+}
+
+func (k *UserTokenUsageKey) GetKey() string {
+	hasher := sha256.New()
+	hasher.Write([]byte(k.Email))
+	return "user:tokens:" + hex.EncodeToString(hasher.Sum(nil)) + ":" + k.Period
+}
+
+type UserQuotaKey struct {
+	Email string
+}
+
+func (k *UserQuotaKey) GetKey() string {
+	hasher := sha256.New()
+	hasher.Write([]byte(k.Email))
+	return "user:quota:" + hex.EncodeToString(hasher.Sum(nil))
+}
+
+type CostCallsKey struct {
+	Date string
+}
+
+func (k *CostCallsKey) GetKey() string {
+	return "costs:calls:" + k.Date
+}
+
+type CostDailyKey struct {
+	Date string
+}
+
+func (k *CostDailyKey) GetKey() string {
+	return "costs:daily:" + k.Date
+}
+
+type AICacheKey struct {
+	Hash string
+}
+
+func (k *AICacheKey) GetKey() string {
+	return "ai:cache:" + k.Hash
+}
+
+type AICacheStatsKey struct{}
+
+func (k *AICacheStatsKey) GetKey() string {
+	return "ai:cache:stats"
+}
+
+type ModerationFlaggedKey struct{}
+
+func (k *ModerationFlaggedKey) GetKey() string {
+	return "moderation:flagged"
+}
+
+type UserMemoryKey struct {
+	Email string
+}
+
+func (k *UserMemoryKey) GetKey() string {
+	hasher := sha256.New()
+	hasher.Write([]byte(k.Email))
+	return "user:memory:" + hex.EncodeToString(hasher.Sum(nil))
+}
+
+type ExperimentKey struct {
+	Name string
+}
+
+func (k *ExperimentKey) GetKey() string {
+	return "experiment:" + k.Name
+}
+
+// UserGameLockKey locks one of a user's save slots while a turn is played
+// in it.  Without a Slot it locks all of the user's saves.
+type UserGameLockKey struct {
+	Email string
+	Slot  string
+}
+
+func (k *UserGameLockKey) GetKey() string {
+	hasher := sha256.New()
+	hasher.Write([]byte(k.Email))
+	key := "user:game:lock:" + hex.EncodeToString(hasher.Sum(nil))
+	if k.Slot != "" {
+		key += ":" + k.Slot
+	}
+	return key
 }
@@ -83,6 +83,201 @@ func GetValue(ctx context.Context, key RedisKey) (string, error) {
 	return value, nil
 }
 
+// IncrBy adds value to the integer stored at key and returns the new total.
+// The expiry is refreshed on every increment when expMinutes is non-zero.
+func IncrBy(ctx context.Context, key RedisKey, value int64, expMinutes int) (int64, error) {
+	pipe := Client.TxPipeline()
+	incr := pipe.IncrBy(ctx, key.GetKey(), value)
+	if expMinutes > 0 {
+		pipe.Expire(ctx, key.GetKey(), time.Duration(expMinutes)*time.Minute)
+	}
+
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+// GetInt returns the integer stored at key, or 0 if the key does not exist.
+func GetInt(ctx context.Context, key RedisKey) (int64, error) {
+	value, err := Client.Get(ctx, key.GetKey()).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return value, nil
+}
+
+// PushValue appends value to the list at key, keeping at most the newest
+// maxLen entries when maxLen is non-zero.
+func PushValue(ctx context.Context, key RedisKey, value string, maxLen int64, expMinutes int) error {
+	pipe := Client.TxPipeline()
+	pipe.RPush(ctx, key.GetKey(), value)
+	if maxLen > 0 {
+		pipe.LTrim(ctx, key.GetKey(), -maxLen, -1)
+	}
+	if expMinutes > 0 {
+		pipe.Expire(ctx, key.GetKey(), time.Duration(expMinutes)*time.Minute)
+	}
+
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// GetValues returns the entries of the list at key between start and stop
+// inclusive.  Negative indexes count from the end of the list.
+func GetValues(ctx context.Context, key RedisKey, start int64, stop int64) ([]string, error) {
+	return Client.LRange(ctx, key.GetKey(), start, stop).Result()
+}
+
+// ListLength returns the number of entries in the list at key.
+func ListLength(ctx context.Context, key RedisKey) (int64, error) {
+	return Client.LLen(ctx, key.GetKey()).Result()
+}
+
+// TrimValues keeps only the values of a list from start to stop.
+func TrimValues(ctx context.Context, key RedisKey, start int64, stop int64) error {
+	return Client.LTrim(ctx, key.GetKey(), start, stop).Err()
+}
+
+// IncrHashFields adds to integer and float fields of the hash at key in a
+// single transaction.
+func IncrHashFields(ctx context.Context, key RedisKey, ints map[string]int64, floats map[string]float64, expMinutes int) error {
+	pipe := Client.TxPipeline()
+	for field, value := range ints {
+		pipe.HIncrBy(ctx, key.GetKey(), field, value)
+	}
+	for field, value := range floats {
+		pipe.HIncrByFloat(ctx, key.GetKey(), field, value)
+	}
+	if expMinutes > 0 {
+		pipe.Expire(ctx, key.GetKey(), time.Duration(expMinutes)*time.Minute)
+	}
+
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// StreamEntry is an entry of a stream and the id redis gave it.
+type StreamEntry struct {
+	ID     string
+	Values map[string]string
+}
+
+// AppendStream adds entries to a stream in one transaction and returns the
+// ids redis gave them.
+func AppendStream(ctx context.Context, key RedisKey, entries []map[string]interface{}) ([]string, error) {
+	pipe := Client.TxPipeline()
+	cmds := make([]*redis.StringCmd, 0, len(entries))
+	for _, values := range entries {
+		cmds = append(cmds, pipe.XAdd(ctx, &redis.XAddArgs{Stream: key.GetKey(), Values: values}))
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(cmds))
+	for _, cmd := range cmds {
+		ids = append(ids, cmd.Val())
+	}
+	return ids, nil
+}
+
+// TrimStream drops the entries of a stream older than the entry minID.
+func TrimStream(ctx context.Context, key RedisKey, minID string) error {
+	return Client.XTrimMinID(ctx, key.GetKey(), minID).Err()
+}
+
+// ReadStream returns every entry of a stream, oldest first.
+func ReadStream(ctx context.Context, key RedisKey) ([]StreamEntry, error) {
+	messages, err := Client.XRange(ctx, key.GetKey(), "-", "+").Result()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]StreamEntry, 0, len(messages))
+	for _, message := range messages {
+		values := map[string]string{}
+		for field, value := range message.Values {
+			values[field], _ = value.(string)
+		}
+		entries = append(entries, StreamEntry{ID: message.ID, Values: values})
+	}
+	return entries, nil
+}
+
+// StreamLength returns the number of entries in a stream.
+func StreamLength(ctx context.Context, key RedisKey) (int64, error) {
+	return Client.XLen(ctx, key.GetKey()).Result()
+}
+
+// GetHash returns every field of the hash at key.
+func GetHash(ctx context.Context, key RedisKey) (map[string]string, error) {
+	return Client.HGetAll(ctx, key.GetKey()).Result()
+}
+
+// GetHashField returns a field of the hash at key.
+func GetHashField(ctx context.Context, key RedisKey, field string) (string, error) {
+	value, err := Client.HGet(ctx, key.GetKey(), field).Result()
+	if err == redis.Nil {
+		return "", &NotFoundError{Key: key.GetKey() + " " + field}
+	} else if err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+func SetHashField(ctx context.Context, key RedisKey, field string, value string) error {
+	return Client.HSet(ctx, key.GetKey(), field, value).Err()
+}
+
+// SetHashFieldIfMissing sets a field of the hash at key unless it is
+// already set, and reports whether it was.
+func SetHashFieldIfMissing(ctx context.Context, key RedisKey, field string, value string) (bool, error) {
+	return Client.HSetNX(ctx, key.GetKey(), field, value).Result()
+}
+
+func DeleteHashField(ctx context.Context, key RedisKey, field string) error {
+	return Client.HDel(ctx, key.GetKey(), field).Err()
+}
+
+// AcquireLock takes the lock at key for token if no one holds it.  The lock
+// expires after lease unless it is refreshed, so a holder that crashes
+// can't keep it forever.  It reports whether the lock was taken.
+func AcquireLock(ctx context.Context, key RedisKey, token string, lease time.Duration) (bool, error) {
+	return Client.SetNX(ctx, key.GetKey(), token, lease).Result()
+}
+
+// the lock is only touched while it still holds the caller's token, so a
+// holder whose lease ran out can't extend or release someone else's lock
+var (
+	refreshLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+	releaseLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+// RefreshLock extends the lease of a lock held by token.  It reports
+// whether the lock was still held.
+func RefreshLock(ctx context.Context, key RedisKey, token string, lease time.Duration) (bool, error) {
+	refreshed, err := refreshLockScript.Run(ctx, Client, []string{key.GetKey()}, token, lease.Milliseconds()).Int()
+	return refreshed == 1, err
+}
+
+// ReleaseLock releases a lock held by token.
+func ReleaseLock(ctx context.Context, key RedisKey, token string) error {
+	return releaseLockScript.Run(ctx, Client, []string{key.GetKey()}, token).Err()
+}
+
 func DeleteKey(ctx context.Context, key RedisKey) error {
 	err := Client.Del(ctx, key.GetKey()).Err()
 	if err != nil {
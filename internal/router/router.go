@@ -25,14 +25,29 @@ func initializeAdminRoutes() {
 	http.Handle("/admin", auth.AdminAuthMiddleware(RequestLoggerMiddleware(http.HandlerFunc(admin.ServeAdminPage))))
 	http.Handle("/admin/create-user", auth.AdminAuthMiddleware(RequestLoggerMiddleware(http.HandlerFunc(admin.HandleCreateUserForm))))
 	http.Handle("/admin/delete-user", auth.AdminAuthMiddleware(RequestLoggerMiddleware(http.HandlerFunc(admin.HandleDeleteUserAction))))
+	http.Handle("/admin/user-quota", auth.AdminAuthMiddleware(RequestLoggerMiddleware(http.HandlerFunc(admin.HandleUserQuotaForm))))
+	http.Handle("/admin/content-rating", auth.AdminAuthMiddleware(RequestLoggerMiddleware(http.HandlerFunc(admin.HandleContentRatingForm))))
+	http.Handle("/admin/prompt-version", auth.AdminAuthMiddleware(RequestLoggerMiddleware(http.HandlerFunc(admin.HandlePromptVersionForm))))
+	http.Handle("/admin/reload-models", auth.AdminAuthMiddleware(RequestLoggerMiddleware(http.HandlerFunc(admin.HandleReloadModelsAction))))
+	http.Handle("/admin/game-events.json", auth.AdminAuthMiddleware(RequestLoggerMiddleware(http.HandlerFunc(admin.ServeGameEvents))))
+	http.Handle("/admin/costs.json", auth.AdminAuthMiddleware(RequestLoggerMiddleware(http.HandlerFunc(admin.ServeCostReport))))
 }
 
 // intialize the ai adventure game routes
 func initializeGameRoutes() {
 	http.Handle("/game", RequestLoggerMiddleware(http.HandlerFunc(game.ServeGamePage)))
 	http.Handle("/game/process-command", auth.AuthMiddleware(RequestLoggerMiddleware(http.HandlerFunc(game.HandleGameCommand))))
+	http.Handle("/game/stream-command", auth.AuthMiddleware(RequestLoggerMiddleware(http.HandlerFunc(game.HandleGameCommandStream))))
+	http.Handle("/game/turn-status", auth.AuthMiddleware(RequestLoggerMiddleware(http.HandlerFunc(game.ServeTurnStatus))))
 	http.Handle("/game/game-state", auth.AuthMiddleware(RequestLoggerMiddleware(http.HandlerFunc(game.HandleGameState))))
-	http.Handle("/game/stats-display", RequestLoggerMiddleware(http.HandlerFunc(game.ServeGameStats)))
+	http.Handle("/game/stats-display", auth.AuthMiddleware(RequestLoggerMiddleware(http.HandlerFunc(game.ServeGameStats))))
+	http.Handle("/game/feedback", auth.AuthMiddleware(RequestLoggerMiddleware(http.HandlerFunc(game.HandleTurnFeedback))))
+	http.Handle("/game/slots", auth.AuthMiddleware(RequestLoggerMiddleware(http.HandlerFunc(game.ServeSlots))))
+	http.Handle("/game/slots/action", auth.AuthMiddleware(RequestLoggerMiddleware(http.HandlerFunc(game.HandleSlotAction))))
+	http.Handle("/game/timeline", auth.AuthMiddleware(RequestLoggerMiddleware(http.HandlerFunc(game.ServeTimeline))))
+	http.Handle("/game/timeline/rewind", auth.AuthMiddleware(RequestLoggerMiddleware(http.HandlerFunc(game.HandleRewind))))
+	http.Handle("/game/timeline/branch", auth.AuthMiddleware(RequestLoggerMiddleware(http.HandlerFunc(game.HandleBranch))))
+	http.Handle("/game/usage", auth.AuthMiddleware(RequestLoggerMiddleware(http.HandlerFunc(game.ServeUsage))))
 }
 
 // intialize api routes
@@ -1,5 +1,7 @@
 package util
 
+import "sort"
+
 type StringSet map[string]struct{}
 type void struct{}
 
@@ -23,8 +25,12 @@ func EmptyStringSet() StringSet {
 	return make(StringSet)
 }
 
+// ToSlice returns the members in sorted order, so prompts built from a set
+// are the same from one call to the next.
 func (s StringSet) ToSlice() []string {
-	return GetStringsFromMap(s)
+	slice := GetStringsFromMap(s)
+	sort.Strings(slice)
+	return slice
 }
 
 func (s StringSet) AddAll(elements ...string) {
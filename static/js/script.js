@@ -0,0 +1,149 @@
+var activeSource = null;
+
+document.addEventListener("DOMContentLoaded", function () {
+    var form = document.getElementById("command-form");
+    if (!form) {
+        return;
+    }
+
+    document.getElementById("cancel-turn").addEventListener("click", cancelTurn);
+
+    form.addEventListener("submit", function (event) {
+        event.preventDefault();
+
+        var command = document.getElementById("command-input").value.trim();
+        if (command === "") {
+            return;
+        }
+
+        form.reset();
+        if (window.EventSource) {
+            streamGameCommand(command);
+        } else {
+            postGameCommand(command);
+        }
+    });
+});
+
+// postGameCommand sends a command for browsers that can't read server sent
+// events.  The turn arrives whole, so its status is polled while it resolves.
+function postGameCommand(command) {
+    var status = document.getElementById("turn-status");
+    var poll = setInterval(function () {
+        htmx.ajax("GET", "/game/turn-status", { target: "#turn-status", swap: "innerHTML" });
+    }, 1000);
+
+    htmx.ajax("POST", "/game/process-command", {
+        target: "#game-output",
+        swap: "beforeend scroll:bottom",
+        values: { command: command }
+    }).then(function () {
+        clearInterval(poll);
+        status.textContent = "";
+    });
+}
+
+// streamGameCommand sends a command to the game and appends the game master's
+// narrative to the output as it is generated.
+function streamGameCommand(command) {
+    var output = document.getElementById("game-output");
+
+    var entry = document.createElement("p");
+    entry.append(
+        "[PLAYER]", document.createElement("br"),
+        command, document.createElement("br"),
+        document.createElement("br"),
+        "[GAME MASTER]", document.createElement("br"));
+
+    var status = document.createElement("em");
+    status.className = "status";
+    entry.appendChild(status);
+
+    var narrative = document.createElement("span");
+    narrative.className = "narrative streaming";
+    entry.appendChild(narrative);
+    output.appendChild(entry);
+    output.scrollTop = output.scrollHeight;
+
+    var source = new EventSource("/game/stream-command?command=" + encodeURIComponent(command));
+    activeSource = source;
+    var turn = 0;
+
+    source.addEventListener("status", function (event) {
+        status.textContent = JSON.parse(event.data);
+    });
+
+    source.addEventListener("token", function (event) {
+        status.textContent = "";
+        narrative.textContent += JSON.parse(event.data);
+        output.scrollTop = output.scrollHeight;
+    });
+
+    source.addEventListener("turn", function (event) {
+        turn = parseInt(JSON.parse(event.data), 10);
+    });
+
+    source.addEventListener("done", function (event) {
+        status.textContent = "";
+        narrative.textContent = JSON.parse(event.data);
+        narrative.classList.remove("streaming");
+        if (turn > 0) {
+            var feedback = feedbackControls(turn);
+            entry.append(document.createElement("br"), feedback);
+            htmx.process(feedback);
+        }
+        output.scrollTop = output.scrollHeight;
+        source.close();
+    });
+
+    source.addEventListener("game-error", function (event) {
+        status.textContent = "";
+        narrative.textContent = "[ERROR] " + JSON.parse(event.data);
+        narrative.classList.remove("streaming");
+        source.close();
+    });
+
+    // the connection dropped before the turn finished, don't let EventSource
+    // resend the command
+    source.onerror = function () {
+        narrative.classList.remove("streaming");
+        source.close();
+    };
+}
+
+// feedbackControls builds the links a player votes on a turn with, the same
+// as the ones in the game-update template.
+function feedbackControls(turn) {
+    var feedback = document.createElement("small");
+    feedback.className = "feedback";
+
+    [["up", "Good turn"], ["down", "Bad turn"]].forEach(function (vote, i) {
+        if (i > 0) {
+            feedback.append(" | ");
+        }
+        var link = document.createElement("a");
+        link.href = "#";
+        link.textContent = vote[1];
+        link.setAttribute("hx-post", "/game/feedback");
+        link.setAttribute("hx-vals", JSON.stringify({ vote: vote[0], turn: turn }));
+        link.setAttribute("hx-target", "closest .feedback");
+        feedback.appendChild(link);
+    });
+
+    return feedback;
+}
+
+// cancelTurn stops the narrative being streamed, if any, and asks the server
+// to abandon the rest of the turn.
+function cancelTurn() {
+    if (activeSource) {
+        activeSource.close();
+        activeSource = null;
+    }
+
+    htmx.ajax("POST", "/game/process-command", {
+        target: "#game-output",
+        swap: "beforeend scroll:bottom",
+        values: { command: "CANCEL TURN" }
+    });
+}